@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// apiClientConfig configures the Yandex 360 API client of the webhook. Every
+// setting is read from an environment variable and may be overridden by the
+// flag of the same name, e.g. API_TIMEOUT by --api-timeout.
+type apiClientConfig struct {
+	timeout   time.Duration
+	userAgent string
+	// proxy is the URL of the proxy of the API requests only, the standard
+	// HTTPS_PROXY and NO_PROXY variables are honoured if it is empty
+	proxy string
+	// caBundle is a PEM file of CAs trusted in addition to the system ones
+	caBundle       string
+	retry          yandex360api.RetryPolicy
+	circuitBreaker yandex360api.CircuitBreakerPolicy
+	rateLimit      yandex360api.RateLimit
+	cacheTTL       time.Duration
+}
+
+// apiClientConfigFromEnv reads the API client configuration from the
+// environment:
+//
+//	API_TIMEOUT                 timeout of a request, "0" disables, default "30s"
+//	API_USER_AGENT              User-Agent of the requests, default "cert-manager-webhook-yandex360"
+//	API_PROXY                   proxy URL of the API requests, default HTTPS_PROXY
+//	API_CA_BUNDLE               PEM file of additional trusted CAs
+//	API_RETRY_MAX_ATTEMPTS      times a request is sent at most, "1" disables retries, default "3"
+//	API_RETRY_BASE_DELAY        delay before the first retry, default "200ms"
+//	API_RETRY_MAX_DELAY         longest delay between retries, default "5s"
+//	API_CACHE_TTL               how long the records of a domain are cached, "0" disables, default "5s"
+//	API_CIRCUIT_FAILURES        consecutive failures opening the circuit of an organization, "0" disables, default "5"
+//	API_CIRCUIT_OPEN_DURATION   how long an open circuit fails requests fast, default "30s"
+//
+// and the rate limit, see rateLimitFromEnv.
+func apiClientConfigFromEnv() (*apiClientConfig, error) {
+	var env envErrors
+	rateLimit, err := rateLimitFromEnv()
+	if err != nil {
+		env = append(env, err)
+	}
+
+	config := &apiClientConfig{
+		timeout:   env.duration("API_TIMEOUT", "30s"),
+		userAgent: getEnv("API_USER_AGENT", "cert-manager-webhook-yandex360"),
+		proxy:     getEnv("API_PROXY", ""),
+		caBundle:  getEnv("API_CA_BUNDLE", ""),
+		retry: yandex360api.RetryPolicy{
+			MaxAttempts: env.integer("API_RETRY_MAX_ATTEMPTS", "3"),
+			BaseDelay:   env.duration("API_RETRY_BASE_DELAY", "200ms"),
+			MaxDelay:    env.duration("API_RETRY_MAX_DELAY", "5s"),
+		},
+		circuitBreaker: yandex360api.CircuitBreakerPolicy{
+			FailureThreshold: env.integer("API_CIRCUIT_FAILURES", "5"),
+			OpenDuration:     env.duration("API_CIRCUIT_OPEN_DURATION", "30s"),
+		},
+		rateLimit: rateLimit,
+		cacheTTL:  env.duration("API_CACHE_TTL", "5s"),
+	}
+	return config, env.err()
+}
+
+// AddFlags adds the flags overriding the environment to fs.
+func (c *apiClientConfig) AddFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&c.timeout, "api-timeout", c.timeout, "Timeout of a Yandex 360 API request, 0 disables it.")
+	fs.StringVar(&c.userAgent, "api-user-agent", c.userAgent, "User-Agent of the Yandex 360 API requests.")
+	fs.StringVar(&c.proxy, "api-proxy", c.proxy, "URL of the proxy of the Yandex 360 API requests. HTTPS_PROXY and NO_PROXY are used if empty.")
+	fs.StringVar(&c.caBundle, "api-ca-bundle", c.caBundle, "PEM file of CAs trusted for the Yandex 360 API in addition to the system ones.")
+	fs.IntVar(&c.retry.MaxAttempts, "api-retry-max-attempts", c.retry.MaxAttempts, "Times a Yandex 360 API request is sent at most, 1 disables retries.")
+	fs.DurationVar(&c.retry.BaseDelay, "api-retry-base-delay", c.retry.BaseDelay, "Delay before the first retry of a Yandex 360 API request, doubled for every following one.")
+	fs.DurationVar(&c.retry.MaxDelay, "api-retry-max-delay", c.retry.MaxDelay, "Longest delay between retries of a Yandex 360 API request.")
+	fs.IntVar(&c.circuitBreaker.FailureThreshold, "api-circuit-failures", c.circuitBreaker.FailureThreshold, "Consecutive failed Yandex 360 API requests opening the circuit breaker of an organization, 0 disables it.")
+	fs.DurationVar(&c.circuitBreaker.OpenDuration, "api-circuit-open-duration", c.circuitBreaker.OpenDuration, "How long an open circuit breaker fails the requests of an organization fast before probing.")
+	fs.Float64Var(&c.rateLimit.QPS, "api-rate-limit-qps", c.rateLimit.QPS, "Sustained Yandex 360 API requests per second, 0 disables the rate limiting.")
+	fs.IntVar(&c.rateLimit.Burst, "api-rate-limit-burst", c.rateLimit.Burst, "Yandex 360 API requests that may be sent at once.")
+	fs.StringVar((*string)(&c.rateLimit.Key), "api-rate-limit-key", string(c.rateLimit.Key), `Rate limit the Yandex 360 API requests per "token" or per "organization".`)
+	fs.DurationVar(&c.cacheTTL, "api-cache-ttl", c.cacheTTL, "How long the DNS records of a domain are cached, 0 disables the cache.")
+}
+
+// options returns the options of the API client.
+func (c *apiClientConfig) options() ([]yandex360api.Option, error) {
+	if c.retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("api-retry-max-attempts must be at least 1, got %d", c.retry.MaxAttempts)
+	}
+	key, err := yandex360api.ParseRateLimitKey(string(c.rateLimit.Key))
+	if err != nil {
+		return nil, fmt.Errorf("api-rate-limit-key: %w", err)
+	}
+	rateLimit := c.rateLimit
+	rateLimit.Key = key
+
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	return []yandex360api.Option{
+		yandex360api.WithHTTPClient(&http.Client{Transport: transport}),
+		yandex360api.WithTimeout(c.timeout),
+		yandex360api.WithUserAgent(c.userAgent),
+		yandex360api.WithRetryPolicy(c.retry),
+		yandex360api.WithCircuitBreaker(c.circuitBreaker),
+		yandex360api.WithRateLimit(rateLimit),
+		yandex360api.WithRecordCache(c.cacheTTL),
+	}, nil
+}
+
+// transport returns the transport of the API requests, with the proxy and the
+// CA bundle configured.
+func (c *apiClientConfig) transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.proxy != "" {
+		proxy, err := url.Parse(c.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api-proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if c.caBundle != "" {
+		pem, err := os.ReadFile(c.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read api-ca-bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in api-ca-bundle %s", c.caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return transport, nil
+}
+
+// rateLimitFromEnv reads the client side rate limit of the Yandex 360 API
+// from the environment:
+//
+//	API_RATE_LIMIT_QPS    sustained requests per second, "0" disables, default "5"
+//	API_RATE_LIMIT_BURST  requests that may be sent at once, default "10"
+//	API_RATE_LIMIT_KEY    "token" or "organization", default "token"
+func rateLimitFromEnv() (yandex360api.RateLimit, error) {
+	var env envErrors
+	qps, err := strconv.ParseFloat(getEnv("API_RATE_LIMIT_QPS", "5"), 64)
+	if err != nil {
+		env = append(env, fmt.Errorf("API_RATE_LIMIT_QPS must be a number: %w", err))
+	}
+	burst := env.integer("API_RATE_LIMIT_BURST", "10")
+	key, err := yandex360api.ParseRateLimitKey(getEnv("API_RATE_LIMIT_KEY", "token"))
+	if err != nil {
+		env = append(env, fmt.Errorf("API_RATE_LIMIT_KEY: %w", err))
+	}
+
+	return yandex360api.RateLimit{QPS: qps, Burst: burst, Key: key}, env.err()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestApiClientConfig_FlagsOverrideEnv(t *testing.T) {
+	t.Setenv("API_TIMEOUT", "10s")
+	t.Setenv("API_USER_AGENT", "from-env")
+	t.Setenv("API_RETRY_MAX_ATTEMPTS", "5")
+	t.Setenv("API_CIRCUIT_FAILURES", "3")
+	config, err := apiClientConfigFromEnv()
+	require.NoError(t, err)
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--api-user-agent=from-flag", "--api-rate-limit-key=organization", "--api-circuit-open-duration=1m"}))
+
+	require.Equal(t, 10*time.Second, config.timeout)
+	require.Equal(t, "from-flag", config.userAgent)
+	require.Equal(t, 5, config.retry.MaxAttempts)
+	require.Equal(t, yandex360api.RateLimitByOrganization, config.rateLimit.Key)
+	require.Equal(t, yandex360api.CircuitBreakerPolicy{FailureThreshold: 3, OpenDuration: time.Minute}, config.circuitBreaker)
+	_, err = config.options()
+	require.NoError(t, err)
+
+	require.NoError(t, fs.Parse([]string{"--api-rate-limit-key=namespace"}))
+	_, err = config.options()
+	require.ErrorContains(t, err, "api-rate-limit-key")
+
+	require.NoError(t, fs.Parse([]string{"--api-rate-limit-key=token", "--api-retry-max-attempts=0"}))
+	_, err = config.options()
+	require.ErrorContains(t, err, "api-retry-max-attempts")
+}
+
+func TestApiClientConfigFromEnv_Invalid(t *testing.T) {
+	t.Setenv("API_RETRY_MAX_ATTEMPTS", "three")
+	t.Setenv("API_RATE_LIMIT_QPS", "fast")
+	t.Setenv("API_RATE_LIMIT_KEY", "namespace")
+
+	_, err := apiClientConfigFromEnv()
+	require.ErrorContains(t, err, "API_RETRY_MAX_ATTEMPTS must be an integer")
+	require.ErrorContains(t, err, "API_RATE_LIMIT_QPS must be a number")
+	require.ErrorContains(t, err, "API_RATE_LIMIT_KEY")
+}
+
+func TestApiClientConfig_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(yandex360api.NewYandex360ApiMock(yandex360api.Yandex360ApiMock_TestData).Handler())
+	defer server.Close()
+	apiUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+
+	config, err := apiClientConfigFromEnv()
+	require.NoError(t, err)
+	config.retry.MaxAttempts = 1
+	opts, err := config.options()
+	require.NoError(t, err)
+	_, err = yandex360api.NewApiClient(opts...).GetDnsRecords(context.TODO(), settings)
+	require.Error(t, err, "the test CA is not trusted by default")
+
+	config.caBundle = filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(config.caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+	opts, err = config.options()
+	require.NoError(t, err)
+	_, err = yandex360api.NewApiClient(opts...).GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(config.caBundle, []byte("not a certificate"), 0o600))
+	_, err = config.options()
+	require.ErrorContains(t, err, "no certificates")
+}
+
+func TestApiClientConfig_Proxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	config, err := apiClientConfigFromEnv()
+	require.NoError(t, err)
+	config.proxy = proxy.URL
+	config.retry.MaxAttempts = 1
+	opts, err := config.options()
+	require.NoError(t, err)
+
+	apiUrl, _ := url.Parse("http://api360.example.invalid")
+	_, err = yandex360api.NewApiClient(opts...).GetDnsRecords(context.TODO(), &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"})
+	require.Error(t, err)
+	require.Len(t, proxied, 1)
+	require.Contains(t, proxied[0], "api360.example.invalid/directory/v1/org/1001/domains/example1.com/dns")
+}
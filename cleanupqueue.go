@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// queuedCleanup is a CleanUp that did not finish, with the fields of its
+// ChallengeRequest needed to run it again.
+type queuedCleanup struct {
+	ResourceNamespace string          `json:"resourceNamespace"`
+	ResolvedFQDN      string          `json:"resolvedFQDN"`
+	ResolvedZone      string          `json:"resolvedZone"`
+	DNSName           string          `json:"dnsName"`
+	Key               string          `json:"key"`
+	Config            json.RawMessage `json:"config,omitempty"`
+	// ChallengeNamespace is the namespace of the Challenge, the policy is
+	// checked for it once the Challenge is deleted
+	ChallengeNamespace string    `json:"challengeNamespace,omitempty"`
+	EnqueuedAt         time.Time `json:"enqueuedAt"`
+	LastError          string    `json:"lastError,omitempty"`
+	// Attempts counts the retries, the next one is due at NextAttempt
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// queuedCleanupStatus is a queued cleanup on the status page.
+type queuedCleanupStatus struct {
+	QueueKey string `json:"queueKey"`
+	queuedCleanup
+}
+
+// request returns the ChallengeRequest of the cleanup.
+func (c *queuedCleanup) request() *v1alpha1.ChallengeRequest {
+	ch := &v1alpha1.ChallengeRequest{
+		Action:            v1alpha1.ChallengeActionCleanUp,
+		Type:              "dns-01",
+		ResourceNamespace: c.ResourceNamespace,
+		ResolvedFQDN:      c.ResolvedFQDN,
+		ResolvedZone:      c.ResolvedZone,
+		DNSName:           c.DNSName,
+		Key:               c.Key,
+	}
+	if len(c.Config) > 0 {
+		ch.Config = &extapi.JSON{Raw: c.Config}
+	}
+	return ch
+}
+
+// cleanupQueue keeps the CleanUps that failed in a ConfigMap, one entry per
+// challenge, so they survive a restart of the webhook, and retries them with
+// an exponential backoff.
+type cleanupQueue struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	now       func() time.Time
+
+	// interval is how often the queue is checked for due entries, zero only
+	// checks it on startup
+	interval time.Duration
+	// baseDelay is the delay after the first failed retry, doubled for every
+	// following one up to maxDelay
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// maxAge is how long an entry is retried, the garbage collector deletes
+	// the records of the entries given up on
+	maxAge time.Duration
+
+	mu sync.Mutex
+	// entries are the entries last listed, for the status page
+	entries map[string]*queuedCleanup
+}
+
+// newCleanupQueue returns the queue stored in the ConfigMap named
+// CLEANUP_QUEUE_CONFIGMAP in POD_NAMESPACE, retried according to:
+//
+//	CLEANUP_RETRY_INTERVAL    how often due entries are retried, default "30s"; "0" only retries them on startup
+//	CLEANUP_RETRY_BASE_DELAY  delay after the first failed retry, doubled for every following one, default "1m"
+//	CLEANUP_RETRY_MAX_DELAY   longest delay between retries, default "1h"
+//	CLEANUP_RETRY_MAX_AGE     how long an entry is retried, default "168h"
+func newCleanupQueue(client kubernetes.Interface) (*cleanupQueue, error) {
+	var env envErrors
+	q := &cleanupQueue{
+		client:    client,
+		namespace: getEnv("POD_NAMESPACE", "cert-manager"),
+		name:      getEnv("CLEANUP_QUEUE_CONFIGMAP", "cert-manager-webhook-yandex360-cleanup-queue"),
+		now:       time.Now,
+		interval:  env.duration("CLEANUP_RETRY_INTERVAL", "30s"),
+		baseDelay: env.duration("CLEANUP_RETRY_BASE_DELAY", "1m"),
+		maxDelay:  env.duration("CLEANUP_RETRY_MAX_DELAY", "1h"),
+		maxAge:    env.duration("CLEANUP_RETRY_MAX_AGE", "168h"),
+	}
+	return q, env.err()
+}
+
+// Add enqueues the CleanUp of ch, which failed with cause, to be retried
+// right away. challengeNamespace is the namespace of its Challenge, empty if
+// unknown. A cleanup already queued keeps its schedule.
+func (q *cleanupQueue) Add(ctx context.Context, ch *v1alpha1.ChallengeRequest, challengeNamespace string, cause error) error {
+	now := q.now().UTC()
+	entry := queuedCleanup{
+		ResourceNamespace:  ch.ResourceNamespace,
+		ResolvedFQDN:       ch.ResolvedFQDN,
+		ResolvedZone:       ch.ResolvedZone,
+		DNSName:            ch.DNSName,
+		Key:                ch.Key,
+		ChallengeNamespace: challengeNamespace,
+		EnqueuedAt:         now,
+		NextAttempt:        now,
+	}
+	if ch.Config != nil {
+		entry.Config = ch.Config.Raw
+	}
+
+	key := correlationID(ch)
+	var mutateErr error
+	err := q.update(ctx, func(cm *corev1.ConfigMap) {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		entry := entry
+		if value, ok := cm.Data[key]; ok {
+			var queued queuedCleanup
+			if json.Unmarshal([]byte(value), &queued) == nil {
+				entry.EnqueuedAt, entry.Attempts, entry.NextAttempt = queued.EnqueuedAt, queued.Attempts, queued.NextAttempt
+				if entry.ChallengeNamespace == "" {
+					entry.ChallengeNamespace = queued.ChallengeNamespace
+				}
+			}
+		}
+		entry.LastError = cause.Error()
+		var value []byte
+		value, mutateErr = json.Marshal(entry)
+		cm.Data[key] = string(value)
+	})
+	if mutateErr != nil {
+		return mutateErr
+	}
+	return err
+}
+
+// List returns the queued cleanups keyed by their queue key.
+func (q *cleanupQueue) List(ctx context.Context) (map[string]*queuedCleanup, error) {
+	cm, err := q.client.CoreV1().ConfigMaps(q.namespace).Get(ctx, q.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]*queuedCleanup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cleanup queue %s/%s: %v", q.namespace, q.name, err)
+	}
+
+	entries := make(map[string]*queuedCleanup, len(cm.Data))
+	for key, value := range cm.Data {
+		var entry queuedCleanup
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("cleanup queue %s/%s: invalid entry %q: %v", q.namespace, q.name, key, err)
+		}
+		entries[key] = &entry
+	}
+	return entries, nil
+}
+
+// recordFailure schedules the next retry of the entry after a failed one.
+func (q *cleanupQueue) recordFailure(ctx context.Context, key string, cause error) error {
+	now := q.now().UTC()
+	return q.update(ctx, func(cm *corev1.ConfigMap) {
+		value, ok := cm.Data[key]
+		if !ok {
+			return
+		}
+		var entry queuedCleanup
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return
+		}
+		entry.Attempts++
+		entry.LastError = cause.Error()
+		entry.NextAttempt = now.Add(q.backoff(entry.Attempts))
+		if updated, err := json.Marshal(entry); err == nil {
+			cm.Data[key] = string(updated)
+		}
+	})
+}
+
+// backoff returns the delay after the given number of failed retries.
+func (q *cleanupQueue) backoff(attempts int) time.Duration {
+	delay := q.baseDelay
+	for i := 1; i < attempts && delay < q.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, q.maxDelay)
+}
+
+// setEntries records the entries last listed for the status page and the
+// metrics.
+func (q *cleanupQueue) setEntries(entries map[string]*queuedCleanup) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = entries
+	solverCleanupQueueLength.Set(float64(len(entries)))
+}
+
+// status returns the entries last listed, oldest first, without their config.
+func (q *cleanupQueue) status() []queuedCleanupStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := make([]queuedCleanupStatus, 0, len(q.entries))
+	for key, entry := range q.entries {
+		entry := *entry
+		entry.Config = nil
+		status = append(status, queuedCleanupStatus{QueueKey: key, queuedCleanup: entry})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].EnqueuedAt.Before(status[j].EnqueuedAt) })
+	return status
+}
+
+// Remove drops the entry, e.g. after the cleanup succeeded.
+func (q *cleanupQueue) Remove(ctx context.Context, key string) error {
+	return q.update(ctx, func(cm *corev1.ConfigMap) {
+		delete(cm.Data, key)
+	})
+}
+
+func (q *cleanupQueue) update(ctx context.Context, mutate func(cm *corev1.ConfigMap)) error {
+	if err := updateConfigMap(ctx, q.client, q.namespace, q.name, mutate); err != nil {
+		return fmt.Errorf("failed to update cleanup queue %s/%s: %v", q.namespace, q.name, err)
+	}
+	return nil
+}
+
+// enqueueCleanup writes the CleanUp of ch, which failed with cause, to the
+// queue, along with the namespace of its Challenge while it still exists.
+// ctx may already be cancelled by the shutdown. Cleanups denied by the policy
+// are not retried, the policy denied presenting the record as well.
+func (y *yandex360DNSSolver) enqueueCleanup(ctx context.Context, ch *v1alpha1.ChallengeRequest, challengeNamespace string, cause error) {
+	var denied *policyDeniedError
+	if y.cleanups == nil || errors.As(cause, &denied) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if challenge := y.challengeFor(ctx, ch); challenge != nil {
+		challengeNamespace = challenge.Namespace
+	}
+	logger := klog.FromContext(ctx)
+	if err := y.cleanups.Add(ctx, ch, challengeNamespace, cause); err != nil {
+		logger.Error(err, "Failed to enqueue the cleanup, the record may be left behind")
+		return
+	}
+	logger.Info("Enqueued the cleanup for a retry", "cause", cause.Error())
+}
+
+// runCleanupQueue retries the due cleanups every interval until stopCh is
+// closed, or once if the interval is zero.
+func (y *yandex360DNSSolver) runCleanupQueue(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	ctx = klog.NewContext(ctx, y.namedLogger("cleanup-queue"))
+
+	retry := func(ctx context.Context) {
+		if err := y.retryQueuedCleanups(ctx); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to retry the queued cleanups")
+		}
+	}
+	if y.cleanups.interval <= 0 {
+		retry(ctx)
+		return
+	}
+	wait.UntilWithContext(ctx, retry, y.cleanups.interval)
+}
+
+// retryQueuedCleanups runs the due cleanups of the queue again. Entries that
+// succeed, i.e. whose record is deleted or confirmed gone, are removed, the
+// ones that fail are retried after a backoff until they reach maxAge.
+func (y *yandex360DNSSolver) retryQueuedCleanups(ctx context.Context) error {
+	q := y.cleanups
+	entries, err := q.List(ctx)
+	if err != nil {
+		return err
+	}
+	q.setEntries(entries)
+
+	logger := klog.FromContext(ctx)
+	now := q.now()
+	for key, entry := range entries {
+		if y.operations.isStopping() || ctx.Err() != nil {
+			break
+		}
+		logger := klog.LoggerWithValues(logger, "queueKey", key, "fqdn", entry.ResolvedFQDN, "attempts", entry.Attempts)
+
+		if q.maxAge > 0 && now.Sub(entry.EnqueuedAt) > q.maxAge {
+			if err := q.Remove(ctx, key); err != nil {
+				return err
+			}
+			solverCleanupRetriesTotal.WithLabelValues("expired").Inc()
+			logger.Info("Giving up on the queued cleanup, the garbage collector deletes its record", "enqueuedAt", entry.EnqueuedAt, "lastError", entry.LastError)
+			continue
+		}
+		if now.Before(entry.NextAttempt) {
+			continue
+		}
+
+		if err := y.cleanUp(entry.request(), entry.ChallengeNamespace); err != nil {
+			solverCleanupRetriesTotal.WithLabelValues("error").Inc()
+			if err := q.recordFailure(ctx, key, err); err != nil {
+				return err
+			}
+			logger.Error(err, "Queued cleanup failed, retrying later")
+			continue
+		}
+		if err := q.Remove(ctx, key); err != nil {
+			return err
+		}
+		solverCleanupRetriesTotal.WithLabelValues("success").Inc()
+		logger.Info("Queued cleanup done")
+	}
+
+	entries, err = q.List(ctx)
+	if err != nil {
+		return err
+	}
+	q.setEntries(entries)
+	return nil
+}
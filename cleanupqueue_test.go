@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestCleanupQueue_Retry(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	cleanups, err := newCleanupQueue(solver.k8sClient)
+	require.NoError(t, err)
+	solver.cleanups = cleanups
+	solver.health.setCleanupQueue(solver.cleanups.status)
+	now := time.Now()
+	solver.cleanups.now = func() time.Time { return now }
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+
+	// the issuer loses its credentials between Present and CleanUp
+	ch := challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "key")
+	require.NoError(t, solver.Present(ch))
+	ch.Config = &extapi.JSON{Raw: []byte(`{"endpoint":"` + apiUrl.String() + `","organizationId":1001,"apiTokenSecretRef":{"name":"rotated-credentials","key":"token"}}`)}
+	require.Error(t, solver.CleanUp(ch))
+	require.Error(t, solver.CleanUp(ch))
+
+	entries, err := solver.cleanups.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "a cleanup is queued once")
+	enqueuedAt := entries[correlationID(ch)].EnqueuedAt
+
+	// failed retries back off
+	failed := testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("error"))
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	require.Equal(t, failed+1, testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("error")), "the retry is not due yet")
+	now = now.Add(solver.cleanups.baseDelay)
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	require.Equal(t, failed+2, testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("error")))
+
+	queued := solver.health.status().CleanupQueue
+	require.Len(t, queued, 1)
+	require.Equal(t, correlationID(ch), queued[0].QueueKey)
+	require.Equal(t, 2, queued[0].Attempts)
+	require.Equal(t, enqueuedAt, queued[0].EnqueuedAt)
+	require.Equal(t, now.UTC().Add(2*solver.cleanups.baseDelay), queued[0].NextAttempt.UTC())
+	require.Contains(t, queued[0].LastError, "rotated-credentials")
+	require.Nil(t, queued[0].Config)
+	require.Equal(t, float64(1), testutil.ToFloat64(solverCleanupQueueLength))
+
+	// and succeed once the credentials are back
+	_, err = solver.k8sClient.CoreV1().Secrets("default").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "rotated-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte(mockToken)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	succeeded := testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("success"))
+	now = now.Add(2 * solver.cleanups.baseDelay)
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	require.Equal(t, succeeded+1, testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("success")))
+	require.Empty(t, solver.health.status().CleanupQueue)
+	require.Equal(t, float64(0), testutil.ToFloat64(solverCleanupQueueLength))
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Empty(t, challengeRecords(records, "_acme-challenge.www", "key"))
+
+	// entries past the max age are given up on
+	expired := testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("expired"))
+	require.NoError(t, solver.cleanups.Add(context.TODO(), ch, "", context.DeadlineExceeded))
+	now = now.Add(solver.cleanups.maxAge + time.Minute)
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	require.Equal(t, expired+1, testutil.ToFloat64(solverCleanupRetriesTotal.WithLabelValues("expired")))
+	require.Empty(t, solver.health.status().CleanupQueue)
+}
+
+func TestCleanupQueue_Backoff(t *testing.T) {
+	q := &cleanupQueue{baseDelay: time.Minute, maxDelay: 5 * time.Minute}
+	require.Equal(t, time.Minute, q.backoff(1))
+	require.Equal(t, 2*time.Minute, q.backoff(2))
+	require.Equal(t, 4*time.Minute, q.backoff(3))
+	require.Equal(t, 5*time.Minute, q.backoff(4))
+	require.Equal(t, 5*time.Minute, q.backoff(100))
+}
+
+func TestCleanupQueue_RetryAfterChallengeDeleted(t *testing.T) {
+	challenge := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "challenge", Namespace: "team-a"},
+		Spec:       cmacme.ChallengeSpec{Key: "key", DNSName: "www.example1.com"},
+	}
+	solver, apiUrl := newTestSolver(t, challenge)
+	cleanups, err := newCleanupQueue(solver.k8sClient)
+	require.NoError(t, err)
+	solver.cleanups = cleanups
+	_, err = solver.k8sClient.CoreV1().ConfigMaps("cert-manager").Create(context.TODO(), policyConfigMap("rules:\n  - namespaces: [team-a]\n    domains: [\"*.example1.com\"]\n"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	solver.policy = &policyEnforcer{client: solver.k8sClient, namespace: "cert-manager", name: "policy"}
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+
+	ch := challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "key")
+	ch.DNSName = "www.example1.com"
+	require.NoError(t, solver.Present(ch))
+	ch.Config = &extapi.JSON{Raw: []byte(`{"endpoint":"` + apiUrl.String() + `","organizationId":1001,"apiTokenSecretRef":{"name":"rotated-credentials","key":"token"}}`)}
+	require.Error(t, solver.CleanUp(ch))
+	entries, err := solver.cleanups.List(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "team-a", entries[correlationID(ch)].ChallengeNamespace)
+
+	// cert-manager deletes the Challenge before the retry
+	require.NoError(t, solver.cmClient.AcmeV1().Challenges("team-a").Delete(context.TODO(), "challenge", metav1.DeleteOptions{}))
+	require.Eventually(t, func() bool { return solver.challengeFor(context.TODO(), ch) == nil }, 5*time.Second, 10*time.Millisecond)
+	_, err = solver.k8sClient.CoreV1().Secrets("default").Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "rotated-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte(mockToken)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	entries, err = solver.cleanups.List(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, entries, "the policy is checked for the namespace of the deleted Challenge")
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Empty(t, challengeRecords(records, "_acme-challenge.www", "key"))
+}
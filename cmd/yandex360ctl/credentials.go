@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// credentials are the sources of the OAuth token, in order of precedence:
+// --token, --token-file, --token-secret and the environment variable tokenEnv.
+type credentials struct {
+	token      string
+	tokenFile  string
+	secret     string
+	secretKey  string
+	kubeconfig string
+	tokenEnv   string
+
+	// kubeClient returns the client reading --token-secret and the namespace
+	// of the current kubeconfig context
+	kubeClient func() (kubernetes.Interface, string, error)
+}
+
+// AddFlags adds the credential flags to fs.
+func (c *credentials) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.token, "token", c.token, "OAuth token. Prefer --token-file or $YANDEX360_TOKEN, flags are visible to other users.")
+	fs.StringVar(&c.tokenFile, "token-file", c.tokenFile, "File containing the OAuth token ($YANDEX360_TOKEN_FILE).")
+	fs.StringVar(&c.secret, "token-secret", c.secret, `Kubernetes Secret containing the OAuth token, as "namespace/name" or "name" in the namespace of the kubeconfig context.`)
+	fs.StringVar(&c.secretKey, "token-secret-key", c.secretKey, "Key of the OAuth token in --token-secret.")
+	fs.StringVar(&c.kubeconfig, "kubeconfig", c.kubeconfig, "Kubeconfig used to read --token-secret, defaults to $KUBECONFIG and ~/.kube/config.")
+}
+
+// tokenSource returns the source of the token of the requests.
+func (c *credentials) tokenSource(ctx context.Context) (yandex360api.TokenSource, error) {
+	switch {
+	case c.token != "":
+		return yandex360api.StaticTokenSource(c.token), nil
+	case c.tokenFile != "":
+		return yandex360api.FileTokenSource(c.tokenFile), nil
+	case c.secret != "":
+		token, err := c.secretToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return yandex360api.StaticTokenSource(token), nil
+	case os.Getenv(c.tokenEnv) != "":
+		return yandex360api.EnvTokenSource(c.tokenEnv), nil
+	}
+	return nil, fmt.Errorf("%w: use --token, --token-file, --token-secret or $%s", yandex360api.ErrNoToken, c.tokenEnv)
+}
+
+// secretToken reads the token from the key of --token-secret.
+func (c *credentials) secretToken(ctx context.Context) (string, error) {
+	client, namespace, err := c.kubeClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read --token-secret: %w", err)
+	}
+	name := c.secret
+	if ns, n, ok := strings.Cut(c.secret, "/"); ok {
+		namespace, name = ns, n
+	}
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read --token-secret: %w", err)
+	}
+	token := strings.TrimSpace(string(secret.Data[c.secretKey]))
+	if token == "" {
+		return "", fmt.Errorf("key %q of secret %s/%s is empty: %w", c.secretKey, namespace, name, yandex360api.ErrNoToken)
+	}
+	return token, nil
+}
+
+// kubeconfigClient returns a client of the current context of the kubeconfig.
+func (c *credentials) kubeconfigClient() (kubernetes.Interface, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
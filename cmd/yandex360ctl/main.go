@@ -0,0 +1,126 @@
+// Command yandex360ctl manages the DNS records of Yandex 360 domains from the
+// command line, e.g. to debug the webhook or to back up a zone.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func main() {
+	if err := newRootCommand(os.Stdout, newOptionsFromEnv()).Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// options are the global flags of yandex360ctl.
+type options struct {
+	endpoint     string
+	org          int
+	domain       string
+	output       string
+	timeout      time.Duration
+	tokenInfoURL string
+	credentials  credentials
+
+	out io.Writer
+}
+
+// newOptionsFromEnv returns the defaults of the flags read from the
+// environment:
+//
+//	YANDEX360_ENDPOINT        API endpoint, default "https://api360.yandex.net"
+//	YANDEX360_ORG_ID          organization id
+//	YANDEX360_DOMAIN          domain
+//	YANDEX360_TOKEN           OAuth token, used if no other credentials are given
+//	YANDEX360_TOKEN_FILE      file containing the OAuth token
+//	YANDEX360_TOKEN_INFO_URL  token-info endpoint asked by "token check"
+func newOptionsFromEnv() *options {
+	o := &options{
+		endpoint:     getEnv("YANDEX360_ENDPOINT", "https://api360.yandex.net"),
+		domain:       getEnv("YANDEX360_DOMAIN", ""),
+		output:       "table",
+		timeout:      30 * time.Second,
+		tokenInfoURL: getEnv("YANDEX360_TOKEN_INFO_URL", ""),
+		credentials:  credentials{tokenFile: getEnv("YANDEX360_TOKEN_FILE", ""), secretKey: "token", tokenEnv: "YANDEX360_TOKEN"},
+	}
+	if value := getEnv("YANDEX360_ORG_ID", ""); value != "" {
+		org, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring $YANDEX360_ORG_ID, it must be an integer: %v\n", err)
+		}
+		o.org = org
+	}
+	o.credentials.kubeClient = o.credentials.kubeconfigClient
+	return o
+}
+
+func getEnv(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func newRootCommand(out io.Writer, o *options) *cobra.Command {
+	o.out = out
+	cmd := &cobra.Command{
+		Use:          "yandex360ctl",
+		Short:        "Manage the DNS records of Yandex 360 domains",
+		SilenceUsage: true,
+	}
+	cmd.SetOut(out)
+
+	fs := cmd.PersistentFlags()
+	fs.StringVar(&o.endpoint, "endpoint", o.endpoint, "Yandex 360 API endpoint ($YANDEX360_ENDPOINT).")
+	fs.IntVar(&o.org, "org", o.org, "Organization id ($YANDEX360_ORG_ID).")
+	fs.StringVar(&o.domain, "domain", o.domain, "Domain ($YANDEX360_DOMAIN).")
+	fs.StringVarP(&o.output, "output", "o", o.output, "Output format: table, json or yaml.")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "Timeout of every API request.")
+	o.credentials.AddFlags(fs)
+
+	cmd.AddCommand(
+		newRecordsCommand(o),
+		newZoneCommand(o),
+		newOrgsCommand(o),
+		newDomainsCommand(o),
+		newTokenCommand(o),
+	)
+	return cmd
+}
+
+// client returns the API client of the commands.
+func (o *options) client() *yandex360api.ApiClient {
+	return yandex360api.NewApiClient(
+		yandex360api.WithTimeout(o.timeout),
+		yandex360api.WithUserAgent("yandex360ctl"),
+	)
+}
+
+// settings returns the API settings of the flags. needOrg and needDomain
+// require --org and --domain.
+func (o *options) settings(cmd *cobra.Command, needOrg bool, needDomain bool) (*yandex360api.ApiSettings, error) {
+	apiUrl, err := url.Parse(o.endpoint)
+	if err != nil || apiUrl.Host == "" {
+		return nil, fmt.Errorf("--endpoint %q is not a URL", o.endpoint)
+	}
+	if needOrg && o.org == 0 {
+		return nil, fmt.Errorf("--org or $YANDEX360_ORG_ID is required")
+	}
+	if needDomain && o.domain == "" {
+		return nil, fmt.Errorf("--domain or $YANDEX360_DOMAIN is required")
+	}
+	source, err := o.credentials.tokenSource(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	return &yandex360api.ApiSettings{ApiUrl: apiUrl, TokenSource: source, OrganizationId: o.org, Domain: o.domain}, nil
+}
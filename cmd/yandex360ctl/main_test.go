@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+const mockToken = "mockTestKey="
+
+// newTestCtl returns a function running yandex360ctl with args against a
+// fresh API mock and returning its output.
+func newTestCtl(t *testing.T) func(args ...string) (string, error) {
+	t.Helper()
+	server := httptest.NewServer(yandex360api.NewYandex360ApiMock(yandex360api.Yandex360ApiMock_TestData).Handler())
+	t.Cleanup(server.Close)
+
+	return func(args ...string) (string, error) {
+		if len(args) >= 2 && args[0] == "token" && args[1] == "check" {
+			args = append(args, "--token-info-url", server.URL+"/tokeninfo")
+		}
+		o := newOptionsFromEnv()
+		o.credentials.kubeClient = func() (kubernetes.Interface, string, error) {
+			return fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "yandex360-credentials", Namespace: "default"},
+				Data:       map[string][]byte{"token": []byte(mockToken)},
+			}), "default", nil
+		}
+		var out bytes.Buffer
+		cmd := newRootCommand(&out, o)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"--endpoint", server.URL, "--org", "1001", "--domain", "example1.com"}, args...))
+		err := cmd.ExecuteContext(context.TODO())
+		return out.String(), err
+	}
+}
+
+func TestRecords(t *testing.T) {
+	t.Setenv("YANDEX360_TOKEN", mockToken)
+	ctl := newTestCtl(t)
+
+	out, err := ctl("records", "list")
+	require.NoError(t, err)
+	require.Equal(t, `ID  NAME      TTL    TYPE   DATA
+1   @         21600  A      1.2.3.4
+2   cname1    21600  CNAME  someother1.site
+3   sometxt1  21600  TXT    "randomtext1"
+`, out)
+
+	out, err = ctl("records", "add", "@", "MX", "10", "mx.yandex.net.")
+	require.NoError(t, err)
+	require.Equal(t, "ID  NAME  TTL  TYPE  DATA\n4   @     300  MX    10 mx.yandex.net.\n", out)
+
+	out, err = ctl("records", "update", "4", "@ 600 IN MX 20 mx.yandex.net.", "-o", "json")
+	require.NoError(t, err)
+	var records []yandex360api.DnsRecord
+	require.NoError(t, json.Unmarshal([]byte(out), &records))
+	require.Equal(t, []yandex360api.DnsRecord{{RecordID: 4, Name: "@", Type: "MX", TTL: 600, Exchange: "mx.yandex.net.", Preference: 20}}, records)
+
+	out, err = ctl("records", "get", "4", "-o", "yaml")
+	require.NoError(t, err)
+	require.Contains(t, out, "preference: 20\n")
+
+	out, err = ctl("records", "list", "--type", "mx")
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(out, "\n"))
+
+	_, err = ctl("records", "delete", "4")
+	require.NoError(t, err)
+	_, err = ctl("records", "get", "4")
+	require.ErrorIs(t, err, yandex360api.ErrRecordNotFound)
+
+	_, err = ctl("records", "add", "www", "A", "not-an-address")
+	require.ErrorContains(t, err, "invalid zone file")
+	_, err = ctl("records", "add", "www", "PTR", "host.example1.com.")
+	require.ErrorContains(t, err, "record type PTR is not supported")
+	_, err = ctl("records", "list", "--domain", "")
+	require.ErrorContains(t, err, "--domain or $YANDEX360_DOMAIN is required")
+}
+
+func TestZone(t *testing.T) {
+	t.Setenv("YANDEX360_TOKEN", mockToken)
+	ctl := newTestCtl(t)
+	path := filepath.Join(t.TempDir(), "example1.com.zone")
+
+	_, err := ctl("zone", "export", "-f", path)
+	require.NoError(t, err)
+	zone, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(zone), "cname1.example1.com.\t21600\tIN\tCNAME\tsomeother1.site.\n")
+
+	require.NoError(t, os.WriteFile(path, append(zone, "www.example1.com. 300 IN A 1.2.3.5\n"...), 0o644))
+	out, err := ctl("zone", "diff", path)
+	require.NoError(t, err)
+	require.Equal(t, "+ www 300 IN A 1.2.3.5\nexample1.com: 1 to create, 0 to update, 0 to delete\n", out)
+
+	_, err = ctl("zone", "import", path)
+	require.NoError(t, err)
+	out, err = ctl("zone", "diff", path)
+	require.NoError(t, err)
+	require.Equal(t, "example1.com: no changes\n", out)
+
+	// a file of another domain is rejected
+	_, err = ctl("zone", "import", path, "--domain", "example2.com")
+	require.ErrorContains(t, err, "example1.com. is not in zone example2.com.")
+
+	require.NoError(t, os.WriteFile(path, []byte("www.example1.com. 300 IN A 1.2.3.5\n"), 0o644))
+	out, err = ctl("zone", "import", path, "--prune", "--dry-run", "-o", "json")
+	require.NoError(t, err)
+	var plan yandex360api.Plan
+	require.NoError(t, json.Unmarshal([]byte(out), &plan))
+	require.Equal(t, 3, plan.Count(yandex360api.ChangeDelete))
+	out, err = ctl("records", "list")
+	require.NoError(t, err)
+	require.Equal(t, 5, strings.Count(out, "\n"), "a dry run changes nothing")
+}
+
+func TestOrgsDomainsAndToken(t *testing.T) {
+	ctl := newTestCtl(t)
+
+	out, err := ctl("orgs", "list", "--token-secret", "yandex360-credentials")
+	require.NoError(t, err)
+	require.Equal(t, `ID    NAME               EMAIL  PLAN
+1001  Organization 1001         business
+1002  Organization 1002         business
+1003  Organization 1003         business
+`, out)
+
+	out, err = ctl("domains", "list", "-o", "json", "--token", mockToken)
+	require.NoError(t, err)
+	var domains []yandex360api.Domain
+	require.NoError(t, json.Unmarshal([]byte(out), &domains))
+	require.Len(t, domains, 2)
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(mockToken+"\n"), 0o600))
+	out, err = ctl("token", "check", "--token-file", path, "-o", "yaml")
+	require.NoError(t, err)
+	require.Contains(t, out, "organizations: 3\n")
+	require.Contains(t, out, "login: mock\n")
+	require.Contains(t, out, "expiry: ")
+
+	// the flags take precedence over the environment
+	t.Setenv("YANDEX360_TOKEN", mockToken)
+	_, err = ctl("token", "check", "--token", "invalid")
+	require.ErrorContains(t, err, "token is not accepted")
+
+	t.Setenv("YANDEX360_TOKEN", "")
+	_, err = ctl("orgs", "list")
+	require.ErrorIs(t, err, yandex360api.ErrNoToken)
+	_, err = ctl("orgs", "list", "--token-secret", "default/missing")
+	require.ErrorContains(t, err, `secrets "missing" not found`)
+	_, err = ctl("orgs", "list", "--token", mockToken, "-o", "xml")
+	require.ErrorContains(t, err, `--output must be "table", "json" or "yaml"`)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func newOrgsCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "orgs",
+		Short: "Organizations the token has access to",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the organizations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			settings, err := o.settings(cmd, false, false)
+			if err != nil {
+				return err
+			}
+			organizations, err := o.client().GetOrganizations(cmd.Context(), settings)
+			if err != nil {
+				return err
+			}
+			t := table{header: []string{"ID", "NAME", "EMAIL", "PLAN"}}
+			for _, org := range organizations {
+				t.rows = append(t.rows, []string{strconv.Itoa(org.Id), org.Name, org.Email, org.SubscriptionPlan})
+			}
+			if organizations == nil {
+				organizations = []yandex360api.Organization{}
+			}
+			return o.print(organizations, t)
+		},
+	})
+	return cmd
+}
+
+func newDomainsCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "domains",
+		Short: "Domains of --org",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the domains",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			settings, err := o.settings(cmd, true, false)
+			if err != nil {
+				return err
+			}
+			domains, err := o.client().GetDomains(cmd.Context(), settings)
+			if err != nil {
+				return err
+			}
+			t := table{header: []string{"NAME", "VERIFIED", "DELEGATED", "MASTER", "MX"}}
+			for _, d := range domains {
+				t.rows = append(t.rows, []string{d.Name, strconv.FormatBool(d.Verified), strconv.FormatBool(d.Delegated), strconv.FormatBool(d.Master), strconv.FormatBool(d.Mx)})
+			}
+			if domains == nil {
+				domains = []yandex360api.Domain{}
+			}
+			return o.print(domains, t)
+		},
+	})
+	return cmd
+}
+
+// tokenStatus is the result of "token check".
+type tokenStatus struct {
+	Organizations int        `json:"organizations"`
+	Login         string     `json:"login,omitempty"`
+	ClientID      string     `json:"clientId,omitempty"`
+	Expiry        *time.Time `json:"expiry,omitempty"`
+}
+
+func newTokenCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "OAuth token of the credentials",
+	}
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Check that the token is accepted and show when it expires",
+		Long:  "Check that the token is accepted by listing the organizations it has access to. With --token-info-url the login, the client and the expiry of the token are shown as well.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			settings, err := o.settings(cmd, false, false)
+			if err != nil {
+				return err
+			}
+			client := o.client()
+			organizations, err := client.GetOrganizations(cmd.Context(), settings)
+			if err != nil {
+				return fmt.Errorf("token is not accepted: %w", err)
+			}
+			status := tokenStatus{Organizations: len(organizations)}
+
+			if o.tokenInfoURL != "" {
+				infoURL, err := url.Parse(o.tokenInfoURL)
+				if err != nil {
+					return fmt.Errorf("--token-info-url %q is not a URL", o.tokenInfoURL)
+				}
+				token, err := settings.TokenSource.Token(cmd.Context())
+				if err != nil {
+					return err
+				}
+				info, err := client.GetTokenInfo(cmd.Context(), infoURL, token)
+				if err != nil {
+					return err
+				}
+				status.Login, status.ClientID = info.Login, info.ClientID
+				if !info.Expiry.IsZero() {
+					status.Expiry = &info.Expiry
+				}
+			}
+
+			expiry := "unknown"
+			if status.Expiry != nil {
+				expiry = fmt.Sprintf("%s (in %s)", status.Expiry.UTC().Format(time.RFC3339), time.Until(*status.Expiry).Round(time.Hour))
+			}
+			return o.print(status, table{
+				header: []string{"ORGANIZATIONS", "LOGIN", "CLIENT", "EXPIRY"},
+				rows:   [][]string{{strconv.Itoa(status.Organizations), status.Login, status.ClientID, expiry}},
+			})
+		},
+	}
+	check.Flags().StringVar(&o.tokenInfoURL, "token-info-url", o.tokenInfoURL, "Token-info endpoint responding with the client_id, login and expires_in of the token ($YANDEX360_TOKEN_INFO_URL).")
+	cmd.AddCommand(check)
+	return cmd
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func newRecordsCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "records",
+		Short: "List, add, update and delete the DNS records of --domain",
+	}
+
+	var query yandex360api.RecordQuery
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the records, optionally filtered by name, type and value",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			records, err := o.client().FindDnsRecords(cmd.Context(), settings, query)
+			if err != nil {
+				return err
+			}
+			return o.printRecords(records)
+		},
+	}
+	list.Flags().StringVar(&query.Name, "name", "", `Name of the records relative to the domain, e.g. "@" or "www".`)
+	list.Flags().StringVar(&query.Type, "type", "", "Type of the records, e.g. TXT.")
+	list.Flags().StringVar(&query.Value, "value", "", "Value of the records: address, target, exchange, text or CAA value.")
+
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show the record with the id",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseRecordID(args[0])
+			if err != nil {
+				return err
+			}
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			record, err := o.client().GetDnsRecord(cmd.Context(), settings, id)
+			if err != nil {
+				return err
+			}
+			return o.printRecords([]yandex360api.DnsRecord{*record})
+		},
+	}
+
+	ttl := 300
+	add := &cobra.Command{
+		Use:     "add RECORD",
+		Short:   "Add a record given in zone file syntax",
+		Example: `  yandex360ctl records add www 300 IN A 1.2.3.4` + "\n" + `  yandex360ctl records add '@ MX 10 mx.yandex.net.'`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			record, err := parseRecord(o.domain, ttl, args)
+			if err != nil {
+				return err
+			}
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			created, err := o.client().AddDnsRecord(cmd.Context(), settings, record)
+			if err != nil {
+				return err
+			}
+			return o.printRecords([]yandex360api.DnsRecord{*created})
+		},
+	}
+	add.Flags().IntVar(&ttl, "ttl", ttl, "TTL of a record given without one.")
+
+	update := &cobra.Command{
+		Use:     "update ID RECORD",
+		Short:   "Replace the record with the id by a record given in zone file syntax",
+		Example: `  yandex360ctl records update 42 www 600 IN A 1.2.3.5`,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseRecordID(args[0])
+			if err != nil {
+				return err
+			}
+			record, err := parseRecord(o.domain, ttl, args[1:])
+			if err != nil {
+				return err
+			}
+			record.RecordID = id
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			updated, err := o.client().UpdateDnsRecord(cmd.Context(), settings, record)
+			if err != nil {
+				return err
+			}
+			return o.printRecords([]yandex360api.DnsRecord{*updated})
+		},
+	}
+	update.Flags().IntVar(&ttl, "ttl", ttl, "TTL of a record given without one.")
+
+	del := &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Delete the records with the ids",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			client := o.client()
+			for _, arg := range args {
+				id, err := parseRecordID(arg)
+				if err != nil {
+					return err
+				}
+				if err := client.DeleteDnsRecord(cmd.Context(), settings, id); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Deleted record %d\n", id)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(list, get, add, update, del)
+	return cmd
+}
+
+func parseRecordID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("record id must be a positive integer, got %q", arg)
+	}
+	return id, nil
+}
+
+// parseRecord parses the record of the args, joined to a line of a zone file
+// of domain whose default TTL is ttl.
+func parseRecord(domain string, ttl int, args []string) (yandex360api.DnsRecord, error) {
+	if domain == "" {
+		return yandex360api.DnsRecord{}, fmt.Errorf("--domain or $YANDEX360_DOMAIN is required")
+	}
+	zone := fmt.Sprintf("$TTL %d\n%s\n", ttl, strings.Join(args, " "))
+	records, err := yandex360api.ParseZone(strings.NewReader(zone), domain)
+	if err != nil {
+		return yandex360api.DnsRecord{}, err
+	}
+	if len(records) != 1 {
+		return yandex360api.DnsRecord{}, fmt.Errorf("expected a single record, got %d", len(records))
+	}
+	return records[0], nil
+}
+
+func (o *options) printRecords(records []yandex360api.DnsRecord) error {
+	t := table{header: []string{"ID", "NAME", "TTL", "TYPE", "DATA"}}
+	for _, r := range records {
+		t.rows = append(t.rows, []string{strconv.Itoa(r.RecordID), r.Name, strconv.Itoa(r.TTL), r.Type, r.Rdata()})
+	}
+	if records == nil {
+		records = []yandex360api.DnsRecord{}
+	}
+	return o.print(records, t)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func newZoneCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "zone",
+		Short: "Export, import and compare --domain as an RFC 1035 zone file",
+	}
+
+	var file string
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Write all records as a zone file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			settings, err := o.settings(cmd, true, true)
+			if err != nil {
+				return err
+			}
+			if file == "" || file == "-" {
+				return o.client().ExportZone(cmd.Context(), settings, o.out)
+			}
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			if err := o.client().ExportZone(cmd.Context(), settings, f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}
+	export.Flags().StringVarP(&file, "file", "f", "", `File to write, "-" or empty for stdout.`)
+
+	var opts yandex360api.ImportOptions
+	importZone := &cobra.Command{
+		Use:   "import FILE",
+		Short: `Apply a zone file, "-" reads stdin`,
+		Long:  "Apply a zone file. The records of the names and types in the file are replaced, the others kept unless --prune is given. Importing the same file again changes nothing.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.importZone(cmd, args[0], opts)
+		},
+	}
+	importZone.Flags().BoolVar(&opts.Prune, "prune", false, "Delete the records missing from the file.")
+	importZone.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Only show the changes.")
+
+	var prune bool
+	diff := &cobra.Command{
+		Use:   "diff FILE",
+		Short: "Show the changes importing a zone file would make",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.importZone(cmd, args[0], yandex360api.ImportOptions{Prune: prune, DryRun: true})
+		},
+	}
+	diff.Flags().BoolVar(&prune, "prune", false, "Include the deletion of the records missing from the file.")
+
+	cmd.AddCommand(export, importZone, diff)
+	return cmd
+}
+
+// importZone imports the zone file path and prints the plan.
+func (o *options) importZone(cmd *cobra.Command, path string, opts yandex360api.ImportOptions) error {
+	settings, err := o.settings(cmd, true, true)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = cmd.InOrStdin()
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	plan, err := o.client().ImportZone(cmd.Context(), settings, r, opts)
+	if plan != nil {
+		if err := o.printPlan(plan); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// printPlan prints the diff of plan, or the plan for the json and yaml
+// output.
+func (o *options) printPlan(plan *yandex360api.Plan) error {
+	if o.output == "table" {
+		_, err := fmt.Fprint(o.out, plan.Diff())
+		return err
+	}
+	if plan.Changes == nil {
+		plan.Changes = []yandex360api.Change{}
+	}
+	return o.print(plan, table{})
+}
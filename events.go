@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cminformers "github.com/cert-manager/cert-manager/pkg/client/informers/externalversions"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// Reasons of the events recorded against Challenge resources.
+const (
+	reasonRecordCreated      = "RecordCreated"
+	reasonRecordReused       = "RecordReused"
+	reasonRecordDeleted      = "RecordDeleted"
+	reasonAPIError           = "APIError"
+	reasonPropagationTimeout = "PropagationTimeout"
+	reasonPolicyDenied       = "PolicyDenied"
+)
+
+// newEventRecorder returns a recorder publishing events through client until
+// stopCh is closed.
+func newEventRecorder(client kubernetes.Interface, stopCh <-chan struct{}) record.EventRecorder {
+	scheme := runtime.NewScheme()
+	if err := cmacme.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	if err := cmapi.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "cert-manager-webhook-yandex360"})
+}
+
+// challengeKeyIndex indexes the Challenges of the informer by their key.
+const challengeKeyIndex = "key"
+
+// startChallengeInformer starts watching the Challenges of all namespaces, so
+// challengeFor looks them up in memory instead of listing them per request.
+func (y *yandex360DNSSolver) startChallengeInformer(stopCh <-chan struct{}) error {
+	factory := cminformers.NewSharedInformerFactory(y.cmClient, 0)
+	informer := factory.Acme().V1().Challenges().Informer()
+	err := informer.AddIndexers(cache.Indexers{challengeKeyIndex: func(obj interface{}) ([]string, error) {
+		challenge, ok := obj.(*cmacme.Challenge)
+		if !ok {
+			return nil, nil
+		}
+		return []string{challenge.Spec.Key}, nil
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to index Challenges: %w", err)
+	}
+
+	y.challenges = informer
+	factory.Start(stopCh)
+	return nil
+}
+
+// challengeFor returns the Challenge resource the request was made for, or
+// nil if it cannot be found. The Challenge may live in another namespace than
+// ch.ResourceNamespace when it was created for a ClusterIssuer.
+func (y *yandex360DNSSolver) challengeFor(ctx context.Context, ch *v1alpha1.ChallengeRequest) *cmacme.Challenge {
+	if y.challenges == nil {
+		return nil
+	}
+	if !y.challenges.HasSynced() {
+		klog.FromContext(ctx).V(4).Info("Challenges not synced yet, not recording events")
+		return nil
+	}
+
+	objs, err := y.challenges.GetIndexer().ByIndex(challengeKeyIndex, ch.Key)
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to look up the Challenge")
+		return nil
+	}
+
+	for _, obj := range objs {
+		challenge := obj.(*cmacme.Challenge)
+		if challenge.Spec.DNSName == ch.DNSName {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// recordEvent records an event against challenge, if the challenge is known.
+func (y *yandex360DNSSolver) recordEvent(challenge *cmacme.Challenge, eventtype, reason, messageFmt string, args ...interface{}) {
+	if y.recorder == nil || challenge == nil {
+		return
+	}
+	y.recorder.Eventf(challenge, eventtype, reason, messageFmt, args...)
+}
+
+// recordAPIError records a warning about the failed Yandex 360 call, including
+// the Yandex 360 error code if there is one.
+func (y *yandex360DNSSolver) recordAPIError(challenge *cmacme.Challenge, err error) {
+	var apiErr *yandex360api.ApiError
+	if errors.As(err, &apiErr) {
+		y.recordEvent(challenge, corev1.EventTypeWarning, reasonAPIError, "Yandex 360 API error: status %d, code %d: %s (request id %s)", apiErr.StatusCode, apiErr.Code, apiErr.Message, apiErr.RequestID)
+		return
+	}
+	y.recordEvent(challenge, corev1.EventTypeWarning, reasonAPIError, "Yandex 360 API error: %v", err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+)
+
+func TestSolver_RecordsEvents(t *testing.T) {
+	challenge := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "challenge", Namespace: "default"},
+		Spec:       cmacme.ChallengeSpec{Key: "key", DNSName: "example1.com"},
+	}
+	solver, apiUrl := newTestSolver(t, challenge)
+	recorder := record.NewFakeRecorder(10)
+	solver.recorder = recorder
+
+	ch := challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")
+	require.NoError(t, solver.Present(ch))
+	require.Contains(t, <-recorder.Events, "Normal RecordCreated Created TXT record _acme-challenge in example1.com, organization 1001")
+
+	require.NoError(t, solver.Present(ch))
+	require.Contains(t, <-recorder.Events, "Normal RecordReused TXT record _acme-challenge already present")
+
+	require.NoError(t, solver.CleanUp(ch))
+	require.Contains(t, <-recorder.Events, "Normal RecordDeleted Deleted TXT record _acme-challenge in example1.com")
+
+	// organization 1003 has no access to example1.com
+	ch.Config.Raw = []byte(`{"endpoint":"` + apiUrl.String() + `","organizationId":1003,"apiTokenSecretRef":{"name":"yandex360-credentials","key":"token"}}`)
+	require.Error(t, solver.Present(ch))
+	require.Contains(t, <-recorder.Events, "Warning APIError Yandex 360 API error: status 401, code 16: Unauthorized")
+
+	// events are only recorded for known challenges
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "unknown")))
+	require.Empty(t, recorder.Events)
+}
+
+func TestSolver_ChallengeFor(t *testing.T) {
+	// a ClusterIssuer challenge lives in the namespace of the Certificate
+	challenge := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "challenge", Namespace: "team-a"},
+		Spec:       cmacme.ChallengeSpec{Key: "key", DNSName: "example1.com"},
+	}
+	other := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "team-b"},
+		Spec:       cmacme.ChallengeSpec{Key: "key", DNSName: "example2.com"},
+	}
+	solver, apiUrl := newTestSolver(t, challenge, other)
+
+	found := solver.challengeFor(context.TODO(), challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key"))
+	require.NotNil(t, found)
+	require.Equal(t, "team-a", found.Namespace)
+
+	require.Nil(t, solver.challengeFor(context.TODO(), challengeRequest(apiUrl, "_acme-challenge.example1.com.", "unknown")))
+}
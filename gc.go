@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// acmeChallengeLabel is the leftmost label of every DNS01 challenge record.
+const acmeChallengeLabel = "_acme-challenge"
+
+// gcSettings configures the garbage collector of orphaned challenge records.
+type gcSettings struct {
+	// interval between two sweeps, zero disables the garbage collector
+	interval time.Duration
+	// minAge is the minimal age of an orphaned record before it is deleted
+	minAge time.Duration
+	// dryRun only logs the records that would be deleted
+	dryRun bool
+
+	leaderElection bool
+	leaseName      string
+	leaseNamespace string
+	identity       string
+}
+
+// gcSettingsFromEnv reads the garbage collector settings from the environment:
+//
+//	GC_INTERVAL         interval between sweeps, e.g. "1h"; unset disables GC
+//	GC_MIN_AGE          minimal age of an orphaned record, default "24h"
+//	GC_DRY_RUN          only log deletions, default "false"
+//	GC_LEADER_ELECTION  sweep on the elected replica only, default "true"
+//	GC_LEASE_NAME       name of the Lease used for leader election
+//	POD_NAMESPACE       namespace of the Lease
+//	POD_NAME            identity of this replica, defaults to the hostname
+func gcSettingsFromEnv() (gcSettings, error) {
+	var env envErrors
+	hostname, _ := os.Hostname()
+	settings := gcSettings{
+		interval:       env.duration("GC_INTERVAL", "0"),
+		minAge:         env.duration("GC_MIN_AGE", "24h"),
+		dryRun:         env.boolean("GC_DRY_RUN", "false"),
+		leaderElection: env.boolean("GC_LEADER_ELECTION", "true"),
+		leaseName:      getEnv("GC_LEASE_NAME", "cert-manager-webhook-yandex360-gc"),
+		leaseNamespace: getEnv("POD_NAMESPACE", "cert-manager"),
+		identity:       getEnv("POD_NAME", hostname),
+	}
+	return settings, env.err()
+}
+
+// garbageCollector periodically deletes _acme-challenge TXT records that are
+// not referenced by any Challenge resource, e.g. the leftovers of a failed
+// CleanUp. Only records in the ownership registry are deleted, their age is
+// taken from the registry as Yandex 360 does not report when a record was
+// created.
+type garbageCollector struct {
+	gcSettings
+	solver *yandex360DNSSolver
+	now    func() time.Time
+}
+
+func newGarbageCollector(solver *yandex360DNSSolver, settings gcSettings) *garbageCollector {
+	return &garbageCollector{
+		gcSettings: settings,
+		solver:     solver,
+		now:        time.Now,
+	}
+}
+
+// Run sweeps every interval until stopCh is closed. With leader election
+// enabled only the replica holding the lease sweeps.
+func (g *garbageCollector) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	logger := g.solver.namedLogger("gc")
+	ctx = klog.NewContext(ctx, logger)
+	logger.Info("Starting garbage collector", "interval", g.interval, "minAge", g.minAge, "dryRun", g.dryRun, "leaderElection", g.leaderElection)
+
+	if !g.leaderElection {
+		g.loop(ctx)
+		return
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: g.leaseName, Namespace: g.leaseNamespace},
+		Client:     g.solver.k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: g.identity},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            g.leaseName,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: g.loop,
+				OnStoppedLeading: func() {
+					logger.Info("Stopped leading", "identity", g.identity)
+				},
+			},
+		})
+	}
+}
+
+func (g *garbageCollector) loop(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := g.sweep(ctx); err != nil {
+			klog.FromContext(ctx).Error(err, "Sweep failed")
+		}
+	}, g.interval)
+}
+
+// sweep lists the domains of every organization used by an issuer of this
+// solver and deletes the owned challenge records that are orphaned and older
+// than minAge. Registry entries of records that no longer exist are removed.
+func (g *garbageCollector) sweep(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "gc.sweep")
+	defer func() { endSpan(span, err) }()
+	logger := klog.FromContext(ctx)
+
+	issuers, err := g.solver.discoverSolverIssuers(ctx)
+	if err != nil {
+		return err
+	}
+
+	activeKeys, err := g.activeChallengeKeys()
+	if err != nil {
+		return err
+	}
+
+	owned, err := g.solver.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := g.now()
+	visited := map[string]bool{}
+
+	for _, issuer := range issuers {
+		cfg := issuer.config
+		org := fmt.Sprintf("%s|%d|%s/%s", cfg.Endpoint, cfg.OrganizationId, issuer.namespace, cfg.APITokenSecretRef.Name)
+		if visited[org] {
+			continue
+		}
+		visited[org] = true
+
+		logger := klog.LoggerWithValues(logger, "issuer", issuer.String(), "organizationId", cfg.OrganizationId)
+		ctx := klog.NewContext(ctx, logger)
+
+		apiSettings, err := g.solver.getApiSettings(ctx, cfg, issuer.namespace, "")
+		if err != nil {
+			logger.Error(err, "Failed to get API settings")
+			continue
+		}
+
+		domains, err := g.solver.apiClient.GetDomains(ctx, apiSettings)
+		if err != nil {
+			logger.Error(err, "Failed to list domains")
+			continue
+		}
+
+		for _, domain := range domains {
+			domainSettings := *apiSettings
+			domainSettings.Domain = domain.Name
+			ctx := klog.NewContext(ctx, klog.LoggerWithValues(logger, "domain", domain.Name))
+
+			if err := g.sweepDomain(ctx, &domainSettings, activeKeys, owned, now); err != nil {
+				klog.FromContext(ctx).Error(err, "Failed to sweep domain")
+			}
+		}
+	}
+
+	return nil
+}
+
+// sweepDomain deletes the orphaned challenge records of the domain of
+// apiSettings, holding the lock of the domain.
+func (g *garbageCollector) sweepDomain(ctx context.Context, apiSettings *yandex360api.ApiSettings, activeKeys map[string]bool, owned map[string]*ownedRecord, now time.Time) error {
+	logger := klog.FromContext(ctx)
+
+	lockedCtx, unlock, err := g.solver.lockDomain(ctx, apiSettings)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ctx = lockedCtx
+
+	// the registry is pruned and records are deleted based on this listing, a
+	// cached one may miss the changes made outside of the webhook
+	g.solver.apiClient.InvalidateCache(apiSettings)
+	records, err := g.solver.apiClient.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return err
+	}
+
+	g.pruneRegistry(ctx, apiSettings, records, owned)
+
+	for _, record := range orphanedChallengeRecords(records, activeKeys) {
+		ownedRecord := owned[ownedRecordKey(apiSettings, record.RecordID)]
+		if ownedRecord == nil {
+			logger.V(2).Info("Skipping record not created by the webhook", "recordId", record.RecordID, "name", record.Name)
+			continue
+		}
+		if now.Sub(ownedRecord.CreatedAt) < g.minAge {
+			continue
+		}
+
+		if g.dryRun {
+			logger.Info("Dry-run: would delete orphaned record", "recordId", record.RecordID, "name", record.Name)
+			continue
+		}
+
+		if err := g.solver.apiClient.DeleteDnsRecord(ctx, apiSettings, record.RecordID); err != nil {
+			logger.Error(err, "Failed to delete orphaned record", "recordId", record.RecordID)
+			continue
+		}
+		logger.Info("Deleted orphaned record", "recordId", record.RecordID, "name", record.Name)
+
+		if err := g.solver.registry.Remove(ctx, apiSettings, record.RecordID); err != nil {
+			logger.Error(err, "Failed to remove record from the ownership registry", "recordId", record.RecordID)
+		}
+	}
+	return nil
+}
+
+// pruneRegistry removes the registry entries of the domain whose records are
+// no longer listed, e.g. because they were deleted in the web UI.
+func (g *garbageCollector) pruneRegistry(ctx context.Context, apiSettings *yandex360api.ApiSettings, records []yandex360api.DnsRecord, owned map[string]*ownedRecord) {
+	existing := make(map[int]bool, len(records))
+	for _, r := range records {
+		existing[r.RecordID] = true
+	}
+
+	for _, o := range owned {
+		if o.Host != apiSettings.ApiUrl.Host || o.OrganizationId != apiSettings.OrganizationId || o.Domain != apiSettings.Domain || existing[o.RecordID] {
+			continue
+		}
+		if g.dryRun {
+			klog.FromContext(ctx).Info("Dry-run: would forget deleted record", "recordId", o.RecordID)
+			continue
+		}
+		if err := g.solver.registry.Remove(ctx, apiSettings, o.RecordID); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to remove record from the ownership registry", "recordId", o.RecordID)
+		}
+	}
+}
+
+// activeChallengeKeys returns the keys of all Challenge resources in the
+// cluster, i.e. the TXT values that must not be deleted. They are read from
+// the Challenge informer, which must have synced: a partial list would let the
+// sweep delete the records of active challenges.
+func (g *garbageCollector) activeChallengeKeys() (map[string]bool, error) {
+	challenges := g.solver.challenges
+	if challenges == nil || !challenges.HasSynced() {
+		return nil, errors.New("the Challenges are not synced yet")
+	}
+
+	objs := challenges.GetIndexer().List()
+	keys := make(map[string]bool, len(objs))
+	for _, obj := range objs {
+		if ch, ok := obj.(*cmacme.Challenge); ok {
+			keys[ch.Spec.Key] = true
+		}
+	}
+	return keys, nil
+}
+
+// orphanedChallengeRecords returns the _acme-challenge TXT records whose value
+// is not one of activeKeys.
+func orphanedChallengeRecords(records []yandex360api.DnsRecord, activeKeys map[string]bool) []yandex360api.DnsRecord {
+	var orphaned []yandex360api.DnsRecord
+	for _, r := range records {
+		if r.Type != yandex360api.TXTKey {
+			continue
+		}
+		if r.Name != acmeChallengeLabel && !strings.HasPrefix(r.Name, acmeChallengeLabel+".") {
+			continue
+		}
+		if activeKeys[strings.Trim(r.Text, `"`)] {
+			continue
+		}
+		orphaned = append(orphaned, r)
+	}
+	return orphaned
+}
+
+// envErrors collects the invalid values of environment variables, so a
+// settings reader reports all of them instead of panicking at the first one.
+type envErrors []error
+
+// duration returns the duration in the variable name, defaultValue if unset.
+func (e *envErrors) duration(name string, defaultValue string) time.Duration {
+	d, err := time.ParseDuration(getEnv(name, defaultValue))
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s must be a duration: %w", name, err))
+	}
+	return d
+}
+
+// boolean returns the boolean in the variable name, defaultValue if unset.
+func (e *envErrors) boolean(name string, defaultValue string) bool {
+	b, err := strconv.ParseBool(getEnv(name, defaultValue))
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s must be a boolean: %w", name, err))
+	}
+	return b
+}
+
+// integer returns the integer in the variable name, defaultValue if unset.
+func (e *envErrors) integer(name string, defaultValue string) int {
+	i, err := strconv.Atoi(getEnv(name, defaultValue))
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s must be an integer: %w", name, err))
+	}
+	return i
+}
+
+// err returns the collected errors, nil if there are none.
+func (e envErrors) err() error {
+	return errors.Join(e...)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmfake "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
+	cminformers "github.com/cert-manager/cert-manager/pkg/client/informers/externalversions"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+const mockToken = "mockTestKey="
+
+// newTestSolver returns a solver talking to a fresh API mock, with an Issuer
+// in namespace "default" using organization 1001 of the mock.
+func newTestSolver(t *testing.T, objects ...*cmacme.Challenge) (*yandex360DNSSolver, *url.URL) {
+	t.Helper()
+
+	server := httptest.NewServer(yandex360api.NewYandex360ApiMock(yandex360api.Yandex360ApiMock_TestData).Handler())
+	t.Cleanup(server.Close)
+	apiUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	GroupName = "acme.example.com"
+
+	issuer := &cmapi.Issuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "yandex360", Namespace: "default"},
+		Spec: cmapi.IssuerSpec{IssuerConfig: cmapi.IssuerConfig{ACME: &cmacme.ACMEIssuer{
+			Solvers: []cmacme.ACMEChallengeSolver{{
+				DNS01: &cmacme.ACMEChallengeSolverDNS01{Webhook: &cmacme.ACMEIssuerDNS01ProviderWebhook{
+					GroupName:  GroupName,
+					SolverName: "yandex360-dns-solver",
+					Config:     &extapi.JSON{Raw: []byte(`{"endpoint":"` + server.URL + `","organizationId":1001,"apiTokenSecretRef":{"name":"yandex360-credentials","key":"token"}}`)},
+				}},
+			}},
+		}}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "yandex360-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte(mockToken)},
+	}
+
+	cmObjects := []runtime.Object{issuer}
+	for _, ch := range objects {
+		cmObjects = append(cmObjects, ch)
+	}
+
+	solver := New().(*yandex360DNSSolver)
+	// the mock has no quota
+	solver.apiClient = yandex360api.NewApiClient()
+	solver.k8sClient = fake.NewSimpleClientset(secret)
+	solver.cmClient = cmfake.NewSimpleClientset(cmObjects...)
+	solver.registry = newRecordRegistry(solver.k8sClient)
+	solver.tokens = newSecretTokens(solver.k8sClient, nil, solver.apiClient, oauthSettings{refreshBefore: time.Hour})
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	require.NoError(t, solver.startChallengeInformer(stopCh))
+	require.True(t, cache.WaitForCacheSync(stopCh, solver.challenges.HasSynced))
+	return solver, apiUrl
+}
+
+// challengeRequest returns a request for the fqdn in zone example1.com. served
+// by the solver returned by newTestSolver.
+func challengeRequest(apiUrl *url.URL, fqdn string, key string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		ResolvedZone:      "example1.com.",
+		ResolvedFQDN:      fqdn,
+		DNSName:           "example1.com",
+		Key:               key,
+		Config:            &extapi.JSON{Raw: []byte(`{"endpoint":"` + apiUrl.String() + `","organizationId":1001,"apiTokenSecretRef":{"name":"yandex360-credentials","key":"token"}}`)},
+	}
+}
+
+func TestGarbageCollector_Sweep(t *testing.T) {
+	active := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "active", Namespace: "default"},
+		Spec:       cmacme.ChallengeSpec{Key: "active-key", DNSName: "example1.com"},
+	}
+	solver, apiUrl := newTestSolver(t, active)
+
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "active-key")))
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "stale-key")))
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "other.example1.com.", "stale-key")))
+	// created by hand, must never be deleted
+	_, err := solver.apiClient.AddTxtRecord(context.TODO(), settings, "_acme-challenge.manual", "manual-key", 300)
+	require.NoError(t, err)
+
+	now := time.Now()
+	gc := newGarbageCollector(solver, gcSettings{interval: time.Hour, minAge: time.Hour})
+	gc.now = func() time.Time { return now }
+
+	hasRecord := func(name string) bool {
+		records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+		require.NoError(t, err)
+		for _, r := range records {
+			if r.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	// too young
+	require.NoError(t, gc.sweep(context.TODO()))
+	require.True(t, hasRecord("_acme-challenge.www"))
+
+	// dry-run never deletes
+	now = now.Add(2 * time.Hour)
+	gc.dryRun = true
+	require.NoError(t, gc.sweep(context.TODO()))
+	require.True(t, hasRecord("_acme-challenge.www"))
+
+	gc.dryRun = false
+	require.NoError(t, gc.sweep(context.TODO()))
+	require.False(t, hasRecord("_acme-challenge.www"))
+	require.True(t, hasRecord("_acme-challenge"))
+	require.True(t, hasRecord("other"))
+	require.True(t, hasRecord("_acme-challenge.manual"))
+
+	owned, err := solver.registry.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, owned, 2)
+}
+
+func TestGarbageCollector_SweepBypassesRecordCache(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	solver.apiClient = yandex360api.NewApiClient(yandex360api.WithRecordCache(time.Hour))
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")))
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	created := challengeRecords(records, "_acme-challenge", "key")
+	require.Len(t, created, 1)
+
+	// deleted by someone else, the cache of the solver still has the record
+	require.NoError(t, yandex360api.NewApiClient().DeleteDnsRecord(context.TODO(), settings, created[0].RecordID))
+
+	gc := newGarbageCollector(solver, gcSettings{interval: time.Hour, minAge: time.Hour})
+	require.NoError(t, gc.sweep(context.TODO()))
+
+	owned, err := solver.registry.List(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, owned)
+}
+
+func TestGarbageCollector_SweepReadsChallengesFromInformer(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")))
+	gc := newGarbageCollector(solver, gcSettings{interval: time.Hour})
+	gc.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	cmClient := solver.cmClient.(*cmfake.Clientset)
+	cmClient.ClearActions()
+	require.NoError(t, gc.sweep(context.TODO()))
+	for _, action := range cmClient.Actions() {
+		require.False(t, action.Matches("list", "challenges"), "the Challenges are listed from the API")
+	}
+
+	// an informer not synced yet would report every record as orphaned
+	solver.challenges = cminformers.NewSharedInformerFactory(solver.cmClient, 0).Acme().V1().Challenges().Informer()
+	require.ErrorContains(t, gc.sweep(context.TODO()), "not synced")
+}
+
+func TestOrphanedChallengeRecords(t *testing.T) {
+	records := []yandex360api.DnsRecord{
+		{RecordID: 1, Name: "_acme-challenge", Type: "TXT", Text: `"active"`},
+		{RecordID: 2, Name: "_acme-challenge", Type: "TXT", Text: "stale"},
+		{RecordID: 3, Name: "_acme-challenge.sub", Type: "TXT", Text: "stale"},
+		{RecordID: 4, Name: "_acme-challenge-not", Type: "TXT", Text: "stale"},
+		{RecordID: 5, Name: "_acme-challenge", Type: "CNAME", Target: "elsewhere"},
+	}
+
+	orphaned := orphanedChallengeRecords(records, map[string]bool{"active": true})
+	require.Len(t, orphaned, 2)
+	require.Equal(t, 2, orphaned[0].RecordID)
+	require.Equal(t, 3, orphaned[1].RecordID)
+}
+
+func TestDiscoverSolverIssuers_SkipsInvalidConfig(t *testing.T) {
+	solver, _ := newTestSolver(t)
+
+	broken := &cmapi.ClusterIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken"},
+		Spec: cmapi.IssuerSpec{IssuerConfig: cmapi.IssuerConfig{ACME: &cmacme.ACMEIssuer{
+			Solvers: []cmacme.ACMEChallengeSolver{{
+				DNS01: &cmacme.ACMEChallengeSolverDNS01{Webhook: &cmacme.ACMEIssuerDNS01ProviderWebhook{
+					GroupName:  GroupName,
+					SolverName: "yandex360-dns-solver",
+					Config:     &extapi.JSON{Raw: []byte(`{"organizationId":"not a number"}`)},
+				}},
+			}},
+		}}},
+	}
+	_, err := solver.cmClient.CertmanagerV1().ClusterIssuers().Create(context.TODO(), broken, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	issuers, err := solver.discoverSolverIssuers(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, issuers, 1)
+	require.Equal(t, "Issuer/default/yandex360", issuers[0].String())
+}
+
+func TestNew_ReportsInvalidEnvFromInitialize(t *testing.T) {
+	t.Setenv("GC_INTERVAL", "hourly")
+	t.Setenv("SELF_TEST", "maybe")
+
+	var solver *yandex360DNSSolver
+	require.NotPanics(t, func() { solver = New().(*yandex360DNSSolver) })
+
+	err := solver.Initialize(nil, nil)
+	require.ErrorContains(t, err, "GC_INTERVAL must be a duration")
+	require.ErrorContains(t, err, "SELF_TEST must be a boolean")
+}
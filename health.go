@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// DebugAddr is the address /debug/status is served on, empty disables it. It
+// listens on localhost only by default, the status is not authenticated and
+// names the organizations and domains of the issuers; use kubectl
+// port-forward to read it.
+var DebugAddr = getEnv("DEBUG_ADDR", "127.0.0.1:9403")
+
+// healthSettings configures when Yandex 360 is reported unavailable. It does
+// not affect the readiness of the webhook, an outage of Yandex 360 must not
+// take the APIService down.
+type healthSettings struct {
+	// failureThreshold is the number of consecutive requests finding Yandex
+	// 360 unavailable after which it is reported unavailable, zero never
+	// reports it
+	failureThreshold int
+	// failureWindow is how long Yandex 360 is reported unavailable after the
+	// last of these failures
+	failureWindow time.Duration
+}
+
+// healthSettingsFromEnv reads the health settings from the environment:
+//
+//	HEALTH_FAILURE_THRESHOLD  consecutive unavailable responses reporting Yandex 360 unavailable, default "5"; "0" disables
+//	HEALTH_FAILURE_WINDOW     time reported unavailable after the last of them, default "1m"
+func healthSettingsFromEnv() (healthSettings, error) {
+	var env envErrors
+	settings := healthSettings{
+		failureThreshold: env.integer("HEALTH_FAILURE_THRESHOLD", "5"),
+		failureWindow:    env.duration("HEALTH_FAILURE_WINDOW", "1m"),
+	}
+	return settings, env.err()
+}
+
+// outcomeStatus is the last outcome of the requests of an endpoint or scope.
+type outcomeStatus struct {
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+}
+
+func (o *outcomeStatus) record(result yandex360api.RequestResult, now time.Time) {
+	if !result.Failed() {
+		o.LastSuccess = &now
+		o.ConsecutiveFailures = 0
+		return
+	}
+	o.LastFailure = &now
+	o.ConsecutiveFailures++
+	if result.Err != nil {
+		o.LastError = yandex360api.RedactString(result.Err.Error())
+	} else {
+		o.LastError = fmt.Sprintf("status %d %s", result.StatusCode, http.StatusText(result.StatusCode))
+	}
+}
+
+// endpointStatus is the health of a Yandex 360 API endpoint.
+type endpointStatus struct {
+	Endpoint string `json:"endpoint"`
+	Requests int    `json:"requests"`
+	outcomeStatus
+}
+
+// scopeStatus is the health of the requests made for a domain, or for an
+// organization if the domain is empty.
+type scopeStatus struct {
+	OrganizationId int    `json:"organizationId"`
+	Domain         string `json:"domain,omitempty"`
+	outcomeStatus
+}
+
+// inFlightChallenge is a Present or CleanUp being handled.
+type inFlightChallenge struct {
+	Operation     string    `json:"operation"`
+	FQDN          string    `json:"fqdn"`
+	Namespace     string    `json:"namespace"`
+	CorrelationID string    `json:"correlationId"`
+	StartedAt     time.Time `json:"startedAt"`
+	Elapsed       string    `json:"elapsed"`
+}
+
+// healthStatus is the document served at /debug/status.
+type healthStatus struct {
+	// UpstreamAvailable is false while the API requests find Yandex 360
+	// unavailable, for the reason UpstreamReason
+	UpstreamAvailable bool                         `json:"upstreamAvailable"`
+	UpstreamReason    string                       `json:"upstreamReason,omitempty"`
+	Endpoints         []endpointStatus             `json:"endpoints"`
+	Scopes            []scopeStatus                `json:"scopes"`
+	Circuits          []yandex360api.CircuitStatus `json:"circuits"`
+	InFlight          []inFlightChallenge          `json:"inFlight"`
+	// CleanupQueue are the failed CleanUps waiting for a retry
+	CleanupQueue []queuedCleanupStatus `json:"cleanupQueue"`
+}
+
+type scopeKey struct {
+	organizationId int
+	domain         string
+}
+
+// healthTracker tracks the outcome of the Yandex 360 API requests and the
+// challenges in flight, for /debug/status and the
+// yandex360_solver_upstream_available metric.
+type healthTracker struct {
+	healthSettings
+	now func() time.Time
+	// circuits returns the state of the circuit breakers of the API client
+	circuits func() []yandex360api.CircuitStatus
+	// cleanups returns the queued cleanups
+	cleanups func() []queuedCleanupStatus
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointStatus
+	scopes    map[scopeKey]*scopeStatus
+	// unavailable counts the consecutive requests finding Yandex 360
+	// unavailable, the last one at lastUnavailable
+	unavailable     int
+	lastUnavailable time.Time
+	inFlight        map[int]*inFlightChallenge
+	nextID          int
+}
+
+func newHealthTracker(settings healthSettings) *healthTracker {
+	return &healthTracker{
+		healthSettings: settings,
+		now:            time.Now,
+		endpoints:      map[string]*endpointStatus{},
+		scopes:         map[scopeKey]*scopeStatus{},
+		inFlight:       map[int]*inFlightChallenge{},
+	}
+}
+
+// observe records the result of a request, it is the yandex360api.Observer
+// of the API client.
+func (h *healthTracker) observe(result yandex360api.RequestResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+
+	endpoint, ok := h.endpoints[result.Endpoint]
+	if !ok {
+		endpoint = &endpointStatus{Endpoint: result.Endpoint}
+		h.endpoints[result.Endpoint] = endpoint
+	}
+	endpoint.Requests++
+	endpoint.record(result, now)
+
+	if result.OrganizationId != 0 {
+		key := scopeKey{organizationId: result.OrganizationId, domain: result.Domain}
+		scope, ok := h.scopes[key]
+		if !ok {
+			scope = &scopeStatus{OrganizationId: result.OrganizationId, Domain: result.Domain}
+			h.scopes[key] = scope
+		}
+		scope.record(result, now)
+	}
+
+	// an error response of one organization says nothing about the others
+	if result.Unavailable() {
+		h.unavailable++
+		h.lastUnavailable = now
+	} else {
+		h.unavailable = 0
+	}
+}
+
+// setCircuits reports the circuit breakers of circuits on the status page.
+func (h *healthTracker) setCircuits(circuits func() []yandex360api.CircuitStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.circuits = circuits
+}
+
+// setCleanupQueue reports the cleanups of cleanups on the status page.
+func (h *healthTracker) setCleanupQueue(cleanups func() []queuedCleanupStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cleanups = cleanups
+}
+
+// track records the challenge of ch as in flight until the returned function
+// is called.
+func (h *healthTracker) track(ctx context.Context, operation string, ch *v1alpha1.ChallengeRequest) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextID
+	h.nextID++
+	h.inFlight[id] = &inFlightChallenge{
+		Operation:     operation,
+		FQDN:          ch.ResolvedFQDN,
+		Namespace:     ch.ResourceNamespace,
+		CorrelationID: correlationIDFrom(ctx),
+		StartedAt:     h.now(),
+	}
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.inFlight, id)
+	}
+}
+
+// available reports whether Yandex 360 is available, with the reason if it is
+// not.
+func (h *healthTracker) available() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.availableLocked()
+}
+
+func (h *healthTracker) availableLocked() (bool, string) {
+	if h.failureThreshold <= 0 || h.unavailable < h.failureThreshold {
+		return true, ""
+	}
+	if h.now().Sub(h.lastUnavailable) >= h.failureWindow {
+		return true, ""
+	}
+	return false, fmt.Sprintf("the last %d Yandex 360 API requests found it unavailable, the last at %s", h.unavailable, h.lastUnavailable.UTC().Format(time.RFC3339))
+}
+
+// status returns the current health, sorted for stable output.
+func (h *healthTracker) status() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+
+	status := healthStatus{Endpoints: []endpointStatus{}, Scopes: []scopeStatus{}, Circuits: []yandex360api.CircuitStatus{}, InFlight: []inFlightChallenge{}, CleanupQueue: []queuedCleanupStatus{}}
+	if h.circuits != nil {
+		status.Circuits = append(status.Circuits, h.circuits()...)
+	}
+	if h.cleanups != nil {
+		status.CleanupQueue = append(status.CleanupQueue, h.cleanups()...)
+	}
+	status.UpstreamAvailable, status.UpstreamReason = h.availableLocked()
+	for _, e := range h.endpoints {
+		status.Endpoints = append(status.Endpoints, *e)
+	}
+	sort.Slice(status.Endpoints, func(i, j int) bool { return status.Endpoints[i].Endpoint < status.Endpoints[j].Endpoint })
+	for _, s := range h.scopes {
+		status.Scopes = append(status.Scopes, *s)
+	}
+	sort.Slice(status.Scopes, func(i, j int) bool {
+		if status.Scopes[i].OrganizationId != status.Scopes[j].OrganizationId {
+			return status.Scopes[i].OrganizationId < status.Scopes[j].OrganizationId
+		}
+		return status.Scopes[i].Domain < status.Scopes[j].Domain
+	})
+	for _, c := range h.inFlight {
+		c := *c
+		c.Elapsed = now.Sub(c.StartedAt).Round(time.Millisecond).String()
+		status.InFlight = append(status.InFlight, c)
+	}
+	sort.Slice(status.InFlight, func(i, j int) bool { return status.InFlight[i].StartedAt.Before(status.InFlight[j].StartedAt) })
+	return status
+}
+
+// upstreamAvailable returns 1 while Yandex 360 is available and 0 otherwise,
+// for a GaugeFunc.
+func (h *healthTracker) upstreamAvailable() float64 {
+	if ok, _ := h.available(); !ok {
+		return 0
+	}
+	return 1
+}
+
+// serveStatus responds with the healthStatus as JSON.
+func (h *healthTracker) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(h.status())
+}
+
+// serveDebug serves /debug/status of health on addr until the process exits.
+func serveDebug(addr string, health *healthTracker) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/status", health.serveStatus)
+
+	klog.InfoS("Serving debug status", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.ErrorS(err, "Debug server failed", "addr", addr)
+	}
+}
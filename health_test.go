@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"k8s.io/klog/v2"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestHealthTracker_Available(t *testing.T) {
+	health := newHealthTracker(healthSettings{failureThreshold: 3, failureWindow: time.Minute})
+	now := time.Now()
+	health.now = func() time.Time { return now }
+	available := func() bool {
+		ok, _ := health.available()
+		return ok
+	}
+
+	// a bad token of one organization does not make Yandex 360 unavailable
+	for i := 0; i < 5; i++ {
+		health.observe(yandex360api.RequestResult{Endpoint: "dns.list", Method: "GET", OrganizationId: 1001, Domain: "example1.com", StatusCode: http.StatusUnauthorized})
+	}
+	require.True(t, available())
+	require.Equal(t, float64(1), health.upstreamAvailable())
+
+	// Yandex 360 being unreachable does
+	for i := 0; i < 3; i++ {
+		health.observe(yandex360api.RequestResult{Endpoint: "dns.list", Method: "GET", OrganizationId: 1002, Domain: "example3.com", Err: errors.New("connection refused")})
+	}
+	ok, reason := health.available()
+	require.False(t, ok)
+	require.Contains(t, reason, "the last 3 Yandex 360 API requests found it unavailable")
+	require.Equal(t, float64(0), health.upstreamAvailable())
+	status := health.status()
+	require.False(t, status.UpstreamAvailable)
+	require.Equal(t, reason, status.UpstreamReason)
+
+	// until the window has passed
+	now = now.Add(time.Minute)
+	require.True(t, available())
+
+	// or a request succeeds
+	health.observe(yandex360api.RequestResult{Endpoint: "dns.list", Method: "GET", OrganizationId: 1002, Domain: "example3.com", StatusCode: http.StatusServiceUnavailable})
+	require.False(t, available())
+	health.observe(yandex360api.RequestResult{Endpoint: "dns.list", Method: "GET", OrganizationId: 1002, Domain: "example3.com", StatusCode: http.StatusOK})
+	require.True(t, available())
+}
+
+func TestHealthTracker_Status(t *testing.T) {
+	health := newHealthTracker(healthSettings{failureThreshold: 3, failureWindow: time.Minute})
+	health.observe(yandex360api.RequestResult{Endpoint: "dns.list", Method: "GET", OrganizationId: 1001, Domain: "example1.com", StatusCode: http.StatusOK})
+	health.observe(yandex360api.RequestResult{Endpoint: "domains.list", Method: "GET", OrganizationId: 1002, StatusCode: http.StatusUnauthorized})
+	health.setCircuits(func() []yandex360api.CircuitStatus {
+		return []yandex360api.CircuitStatus{{Host: "api360.yandex.net", OrganizationId: 1002, State: yandex360api.CircuitOpen, ConsecutiveFailures: 5}}
+	})
+
+	ctx, _ := challengeContext(context.TODO(), klog.Background(), &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example1.com.", ResourceNamespace: "default", Key: "key"})
+	done := health.track(ctx, "present", &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example1.com.", ResourceNamespace: "default", Key: "key"})
+
+	rec := httptest.NewRecorder()
+	health.serveStatus(rec, httptest.NewRequest("GET", "/debug/status", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var status healthStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+
+	require.True(t, status.UpstreamAvailable)
+	require.Len(t, status.Endpoints, 2)
+	require.Equal(t, "dns.list", status.Endpoints[0].Endpoint)
+	require.NotNil(t, status.Endpoints[0].LastSuccess)
+	require.Equal(t, "status 401 Unauthorized", status.Endpoints[1].LastError)
+	require.Equal(t, []scopeStatus{
+		{OrganizationId: 1001, Domain: "example1.com", outcomeStatus: outcomeStatus{LastSuccess: status.Scopes[0].LastSuccess}},
+		{OrganizationId: 1002, outcomeStatus: outcomeStatus{LastFailure: status.Scopes[1].LastFailure, LastError: "status 401 Unauthorized", ConsecutiveFailures: 1}},
+	}, status.Scopes)
+	require.Equal(t, []yandex360api.CircuitStatus{{Host: "api360.yandex.net", OrganizationId: 1002, State: yandex360api.CircuitOpen, ConsecutiveFailures: 5}}, status.Circuits)
+	require.Len(t, status.InFlight, 1)
+	require.Equal(t, "present", status.InFlight[0].Operation)
+	require.Equal(t, correlationIDFrom(ctx), status.InFlight[0].CorrelationID)
+
+	done()
+	require.Empty(t, health.status().InFlight)
+}
+
+func TestHealthSettingsFromEnv_Invalid(t *testing.T) {
+	t.Setenv("HEALTH_FAILURE_THRESHOLD", "five")
+
+	_, err := healthSettingsFromEnv()
+	require.ErrorContains(t, err, "HEALTH_FAILURE_THRESHOLD must be an integer")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// ClusterResourceNamespace is the namespace cert-manager resolves secrets of
+// ClusterIssuers in. It must match the --cluster-resource-namespace flag of
+// the cert-manager controller.
+var ClusterResourceNamespace = getEnv("CLUSTER_RESOURCE_NAMESPACE", "cert-manager")
+
+// solverIssuer is an Issuer or ClusterIssuer with a solver that uses this
+// webhook.
+type solverIssuer struct {
+	issuer cmapi.GenericIssuer
+	// namespace the secrets referenced by config are resolved in
+	namespace string
+	config    yandex360DNSProviderConfig
+}
+
+// String returns a human readable reference to the issuer, e.g.
+// "ClusterIssuer/letsencrypt" or "Issuer/default/letsencrypt".
+func (s solverIssuer) String() string {
+	meta := s.issuer.GetObjectMeta()
+	if meta.Namespace == "" {
+		return "ClusterIssuer/" + meta.Name
+	}
+	return "Issuer/" + meta.Namespace + "/" + meta.Name
+}
+
+// discoverSolverIssuers lists all Issuers and ClusterIssuers in the cluster and
+// returns every ACME DNS01 solver referencing this webhook. Solvers with an
+// invalid config are logged and skipped.
+func (y *yandex360DNSSolver) discoverSolverIssuers(ctx context.Context) ([]solverIssuer, error) {
+	var issuers []cmapi.GenericIssuer
+
+	clusterIssuers, err := y.cmClient.CertmanagerV1().ClusterIssuers().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ClusterIssuers: %v", err)
+	}
+	for i := range clusterIssuers.Items {
+		issuers = append(issuers, &clusterIssuers.Items[i])
+	}
+
+	namespacedIssuers, err := y.cmClient.CertmanagerV1().Issuers(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Issuers: %v", err)
+	}
+	for i := range namespacedIssuers.Items {
+		issuers = append(issuers, &namespacedIssuers.Items[i])
+	}
+
+	var result []solverIssuer
+	for _, issuer := range issuers {
+		acme := issuer.GetSpec().ACME
+		if acme == nil {
+			continue
+		}
+
+		namespace := issuer.GetObjectMeta().Namespace
+		if namespace == "" {
+			namespace = ClusterResourceNamespace
+		}
+
+		for _, solver := range acme.Solvers {
+			if solver.DNS01 == nil || solver.DNS01.Webhook == nil {
+				continue
+			}
+			webhook := solver.DNS01.Webhook
+			if webhook.GroupName != GroupName || webhook.SolverName != y.name {
+				continue
+			}
+
+			cfg, err := loadConfig(webhook.Config)
+			if err == nil {
+				cfg, err = y.resolveConfig(ctx, cfg, namespace)
+			}
+			if err != nil {
+				// a broken issuer must not hide the records of the others
+				klog.FromContext(ctx).Error(err, "Skipping issuer with invalid solver config", "issuer", solverIssuer{issuer: issuer}.String())
+				continue
+			}
+			result = append(result, solverIssuer{issuer: issuer, namespace: namespace, config: cfg})
+		}
+	}
+
+	return result, nil
+}
+
+func getEnv(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
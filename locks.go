@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// domainAnnotation names the domain a lock Lease is held for.
+const domainAnnotation = "yandex360.acme.cert-manager.io/domain"
+
+// lockSettings configures the locking of the domains across replicas.
+type lockSettings struct {
+	// lease locks every domain with a Lease while it is modified
+	lease         bool
+	leasePrefix   string
+	leaseDuration time.Duration
+	namespace     string
+	identity      string
+}
+
+// lockSettingsFromEnv reads the lock settings from the environment:
+//
+//	DOMAIN_LOCK_LEASE           lock domains across replicas with a Lease, default "false"
+//	DOMAIN_LOCK_LEASE_PREFIX    name prefix of the Leases
+//	DOMAIN_LOCK_LEASE_DURATION  lifetime of a Lease not renewed by its holder, default "30s"
+//	POD_NAMESPACE               namespace of the Leases
+//	POD_NAME                    identity of this replica, defaults to the hostname
+func lockSettingsFromEnv() (lockSettings, error) {
+	var env envErrors
+	hostname, _ := os.Hostname()
+	leaseDuration := env.duration("DOMAIN_LOCK_LEASE_DURATION", "30s")
+	// Leases have a resolution of seconds, a shorter lease would expire at once
+	if len(env) == 0 && leaseDuration < time.Second {
+		env = append(env, fmt.Errorf("DOMAIN_LOCK_LEASE_DURATION must be at least 1s, got %s", leaseDuration))
+	}
+
+	settings := lockSettings{
+		lease:         env.boolean("DOMAIN_LOCK_LEASE", "false"),
+		leasePrefix:   getEnv("DOMAIN_LOCK_LEASE_PREFIX", "cert-manager-webhook-yandex360-lock"),
+		leaseDuration: leaseDuration,
+		namespace:     getEnv("POD_NAMESPACE", "cert-manager"),
+		identity:      getEnv("POD_NAME", hostname),
+	}
+	return settings, env.err()
+}
+
+// domainLocker serializes the modifications of a domain, so a Present or
+// CleanUp never acts on a record list another one is about to change. Within
+// the process a mutex per domain is used, across replicas an optional Lease.
+type domainLocker struct {
+	lease *leaseLocker
+
+	mu    sync.Mutex
+	locks map[string]*domainLock
+}
+
+type domainLock struct {
+	// ch holds a value while the lock is held
+	ch chan struct{}
+	// refs counts the holder and the waiters
+	refs int
+}
+
+func newDomainLocker() *domainLocker {
+	return &domainLocker{locks: map[string]*domainLock{}}
+}
+
+// Lock locks the domain of apiSettings until the returned function is called,
+// or returns the error of ctx if it is done first. The returned context is
+// derived from ctx and canceled with errLockLost if the Lease is lost before.
+func (l *domainLocker) Lock(ctx context.Context, apiSettings *yandex360api.ApiSettings) (context.Context, func(), error) {
+	key := domainLockKey(apiSettings)
+	start := time.Now()
+
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &domainLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+
+	select {
+	case lock.ch <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, nil, fmt.Errorf("waiting for the lock of %s: %w", apiSettings.Domain, ctx.Err())
+	}
+	solverLockWait.WithLabelValues("local").Observe(time.Since(start).Seconds())
+
+	unlock := func() {
+		<-lock.ch
+		release()
+	}
+
+	if l.lease == nil {
+		return ctx, unlock, nil
+	}
+
+	start = time.Now()
+	ctx, unlockLease, err := l.lease.Lock(ctx, key, apiSettings.Domain)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	solverLockWait.WithLabelValues("lease").Observe(time.Since(start).Seconds())
+
+	return ctx, func() {
+		unlockLease()
+		unlock()
+	}, nil
+}
+
+func domainLockKey(apiSettings *yandex360api.ApiSettings) string {
+	return apiSettings.ApiUrl.Host + "/" + strconv.Itoa(apiSettings.OrganizationId) + "/" + apiSettings.Domain
+}
+
+// leaseLocker locks domains across replicas with one Lease per domain. The
+// Lease is created to acquire and deleted to release the lock, its holder
+// renews it while the lock is held. A Lease not renewed for its duration,
+// e.g. of a crashed replica, is taken over.
+type leaseLocker struct {
+	client kubernetes.Interface
+	lockSettings
+	// retryPeriod between two attempts to acquire a held Lease
+	retryPeriod time.Duration
+}
+
+func newLeaseLocker(client kubernetes.Interface, settings lockSettings) *leaseLocker {
+	return &leaseLocker{client: client, lockSettings: settings, retryPeriod: time.Second}
+}
+
+// errLockLost is the cause of the cancellation of the context of a domain lock
+// whose Lease could not be renewed, another replica may hold the lock now.
+var errLockLost = errors.New("domain lock lost")
+
+// Lock acquires the Lease of key, waiting for its holder to release it. The
+// returned context is canceled with errLockLost once the Lease is lost.
+func (l *leaseLocker) Lock(ctx context.Context, key string, domain string) (context.Context, func(), error) {
+	sum := sha256.Sum256([]byte(key))
+	name := l.leasePrefix + "-" + hex.EncodeToString(sum[:8])
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	logger := klog.FromContext(ctx).WithValues("lease", klog.KRef(l.namespace, name))
+
+	for {
+		now := metav1.NewMicroTime(time.Now())
+		lease, err := leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   l.namespace,
+				Annotations: map[string]string{domainAnnotation: domain},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(l.identity),
+				LeaseDurationSeconds: ptr.To(int32(l.leaseDuration.Seconds())),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if err == nil {
+			logger.V(4).Info("Acquired domain lock")
+			ctx, unlock := l.hold(ctx, lease, logger)
+			return ctx, unlock, nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return nil, nil, fmt.Errorf("failed to acquire lock lease %s/%s: %w", l.namespace, name, err)
+		}
+
+		held, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err == nil && leaseExpired(held, time.Now()) {
+			logger.Info("Taking over expired domain lock", "holder", ptr.Deref(held.Spec.HolderIdentity, ""))
+			err = leases.Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &held.UID, ResourceVersion: &held.ResourceVersion}})
+			if err == nil || apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+				continue
+			}
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			logger.Error(err, "Failed to check the domain lock")
+		}
+
+		logger.V(4).Info("Waiting for domain lock")
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for lock lease %s/%s: %w", l.namespace, name, ctx.Err())
+		case <-time.After(l.retryPeriod):
+		}
+	}
+}
+
+// hold renews lease until the returned function is called, which deletes it.
+// The returned context is canceled with errLockLost and the renewals stop once
+// the Lease is modified or deleted by another replica, or expires because the
+// renewals failed.
+func (l *leaseLocker) hold(ctx context.Context, lease *coordinationv1.Lease, logger klog.Logger) (context.Context, func()) {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	heldCtx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	// lost is only accessed by the renewing goroutine until done is closed
+	lost := false
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.leaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			renewed := lease.DeepCopy()
+			renewed.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+			updated, err := leases.Update(context.WithoutCancel(ctx), renewed, metav1.UpdateOptions{})
+			if err == nil {
+				lease = updated
+				continue
+			}
+			if apierrors.IsConflict(err) || apierrors.IsNotFound(err) || leaseExpired(lease, time.Now()) {
+				logger.Error(err, "Lost domain lock")
+				lost = true
+				cancel(fmt.Errorf("%w: %w", errLockLost, err))
+				return
+			}
+			logger.Error(err, "Failed to renew domain lock")
+		}
+	}()
+
+	return heldCtx, func() {
+		close(stop)
+		<-done
+		cancel(nil)
+		if lost {
+			// the Lease is not ours anymore
+			return
+		}
+		err := leases.Delete(context.WithoutCancel(ctx), lease.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &lease.UID}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			logger.Error(err, "Failed to release domain lock")
+			return
+		}
+		logger.V(4).Info("Released domain lock")
+	}
+}
+
+// leaseExpired reports whether the holder of lease did not renew it in time.
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestDomainLocker(t *testing.T) {
+	apiUrl, err := url.Parse("https://api360.example")
+	require.NoError(t, err)
+	example1 := &yandex360api.ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com"}
+	example2 := &yandex360api.ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example2.com"}
+	locker := newDomainLocker()
+
+	_, unlock, err := locker.Lock(context.TODO(), example1)
+	require.NoError(t, err)
+
+	// other domains are not locked
+	_, unlock2, err := locker.Lock(context.TODO(), example2)
+	require.NoError(t, err)
+	unlock2()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = locker.Lock(ctx, example1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	locked := make(chan struct{})
+	go func() {
+		_, unlock, err := locker.Lock(context.TODO(), example1)
+		require.NoError(t, err)
+		close(locked)
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("locked a held domain")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	require.Eventually(t, func() bool {
+		locker.mu.Lock()
+		defer locker.mu.Unlock()
+		return len(locker.locks) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestLeaseLocker(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	settings := lockSettings{lease: true, leasePrefix: "lock", leaseDuration: 30 * time.Second, namespace: "default"}
+
+	replica1 := newLeaseLocker(client, settings)
+	replica1.identity = "replica-1"
+	replica1.retryPeriod = 5 * time.Millisecond
+	replica2 := newLeaseLocker(client, settings)
+	replica2.identity = "replica-2"
+	replica2.retryPeriod = 5 * time.Millisecond
+
+	_, unlock, err := replica1.Lock(context.TODO(), "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+
+	leases, err := client.CoordinationV1().Leases("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, leases.Items, 1)
+	require.Equal(t, "replica-1", *leases.Items[0].Spec.HolderIdentity)
+	require.Equal(t, "example1.com", leases.Items[0].Annotations[domainAnnotation])
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = replica2.Lock(ctx, "host/1001/example1.com", "example1.com")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	unlock()
+	_, unlock, err = replica2.Lock(context.TODO(), "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+	unlock()
+
+	leases, err = client.CoordinationV1().Leases("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, leases.Items)
+}
+
+func TestLeaseLocker_TakesOverExpiredLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	locker := newLeaseLocker(client, lockSettings{lease: true, leasePrefix: "lock", leaseDuration: 30 * time.Second, namespace: "default", identity: "replica-2"})
+	locker.retryPeriod = 5 * time.Millisecond
+
+	// left behind by a crashed replica
+	_, unlockCrashed, err := newLeaseLocker(client, lockSettings{lease: true, leasePrefix: "lock", leaseDuration: 30 * time.Second, namespace: "default", identity: "replica-1"}).Lock(context.TODO(), "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+	_ = unlockCrashed
+	leases, err := client.CoordinationV1().Leases("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	expired := leases.Items[0]
+	expired.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now().Add(-time.Minute)))
+	_, err = client.CoordinationV1().Leases("default").Update(context.TODO(), &expired, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	_, unlock, err := locker.Lock(ctx, "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+	defer unlock()
+
+	lease, err := client.CoordinationV1().Leases("default").Get(context.TODO(), expired.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "replica-2", *lease.Spec.HolderIdentity)
+}
+
+func TestLeaseLocker_CancelsLostLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	settings := lockSettings{lease: true, leasePrefix: "lock", leaseDuration: 30 * time.Millisecond, namespace: "default"}
+	replica1 := newLeaseLocker(client, settings)
+	replica1.identity = "replica-1"
+	replica2 := newLeaseLocker(client, settings)
+	replica2.identity = "replica-2"
+
+	ctx, unlock, err := replica1.Lock(context.TODO(), "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+
+	// taken over by another replica while replica-1 was partitioned
+	leases, err := client.CoordinationV1().Leases("default").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.NoError(t, client.CoordinationV1().Leases("default").Delete(context.TODO(), leases.Items[0].Name, metav1.DeleteOptions{}))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the context of the lost lock was not canceled")
+	}
+	require.ErrorIs(t, context.Cause(ctx), errLockLost)
+
+	_, unlock2, err := replica2.Lock(context.TODO(), "host/1001/example1.com", "example1.com")
+	require.NoError(t, err)
+	defer unlock2()
+	// stops renewing and leaves the Lease of replica-2 alone
+	unlock()
+	lease, err := client.CoordinationV1().Leases("default").Get(context.TODO(), leases.Items[0].Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "replica-2", *lease.Spec.HolderIdentity)
+}
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+	lease := &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{
+		RenewTime:            ptr.To(metav1.NewMicroTime(now)),
+		LeaseDurationSeconds: ptr.To(int32(30)),
+	}}
+	require.False(t, leaseExpired(lease, now.Add(29*time.Second)))
+	require.True(t, leaseExpired(lease, now.Add(31*time.Second)))
+	require.True(t, leaseExpired(&coordinationv1.Lease{}, now))
+}
+
+// TestSolver_ConcurrentPresentCleanUp_Stress presents and cleans up many
+// challenges of one domain at once, within one replica and across replicas
+// sharing the API mock and the cluster. Every challenge must get exactly one
+// record and no record may be left behind.
+func TestSolver_ConcurrentPresentCleanUp_Stress(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		replicas int
+		lease    bool
+	}{
+		{"single replica", 1, false},
+		{"replicas with lease", 3, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			solver, apiUrl := newTestSolver(t)
+
+			replicas := make([]*yandex360DNSSolver, tc.replicas)
+			for i := range replicas {
+				replicas[i] = &yandex360DNSSolver{
+					name: solver.name,
+					// a long lived cache makes stale listings likely
+					apiClient:   yandex360api.NewApiClient(yandex360api.WithRecordCache(time.Minute)),
+					k8sClient:   solver.k8sClient,
+					cmClient:    solver.cmClient,
+					registry:    solver.registry,
+					tokens:      solver.tokens,
+					locks:       newDomainLocker(),
+					propagation: newPropagationWatcher(propagationSettings{}),
+					health:      newHealthTracker(healthSettings{}),
+					settings:    solver.settings,
+					operations:  solver.operations,
+				}
+				if tc.lease {
+					replicas[i].locks.lease = newLeaseLocker(solver.k8sClient, lockSettings{lease: true, leasePrefix: "lock", leaseDuration: 30 * time.Second, namespace: "default", identity: fmt.Sprintf("replica-%d", i)})
+					replicas[i].locks.lease.retryPeriod = time.Millisecond
+				}
+			}
+
+			const challenges = 30
+			requests := make([]*challengeRequestFixture, challenges)
+			for i := range requests {
+				requests[i] = &challengeRequestFixture{
+					replica: replicas[i%len(replicas)],
+					// half of the challenges share the record name
+					fqdn: fmt.Sprintf("_acme-challenge.host%d.example1.com.", i%2),
+					key:  fmt.Sprintf("stress-key-%d", i),
+				}
+			}
+
+			var failures atomic.Int64
+			run := func(op func(r *challengeRequestFixture) error) {
+				var wg sync.WaitGroup
+				for _, r := range requests {
+					wg.Add(1)
+					go func(r *challengeRequestFixture) {
+						defer wg.Done()
+						if err := op(r); err != nil {
+							failures.Add(1)
+							t.Error(err)
+						}
+					}(r)
+				}
+				wg.Wait()
+			}
+
+			run(func(r *challengeRequestFixture) error {
+				return r.replica.Present(challengeRequest(apiUrl, r.fqdn, r.key))
+			})
+			// presenting again must not create duplicates
+			run(func(r *challengeRequestFixture) error {
+				return r.replica.Present(challengeRequest(apiUrl, r.fqdn, r.key))
+			})
+			require.Zero(t, failures.Load())
+
+			settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: mockToken}
+			records, err := yandex360api.NewApiClient().GetDnsRecords(context.TODO(), settings)
+			require.NoError(t, err)
+			for _, r := range requests {
+				name := r.fqdn[:len(r.fqdn)-len(".example1.com.")]
+				require.Len(t, challengeRecords(records, name, r.key), 1, r.key)
+			}
+			owned, err := solver.registry.List(context.TODO())
+			require.NoError(t, err)
+			require.Len(t, owned, challenges)
+
+			run(func(r *challengeRequestFixture) error {
+				return r.replica.CleanUp(challengeRequest(apiUrl, r.fqdn, r.key))
+			})
+			require.Zero(t, failures.Load())
+
+			records, err = yandex360api.NewApiClient().GetDnsRecords(context.TODO(), settings)
+			require.NoError(t, err)
+			require.Empty(t, orphanedChallengeRecords(records, nil))
+			require.Len(t, records, 3)
+			owned, err = solver.registry.List(context.TODO())
+			require.NoError(t, err)
+			require.Empty(t, owned)
+		})
+	}
+}
+
+type challengeRequestFixture struct {
+	replica *yandex360DNSSolver
+	fqdn    string
+	key     string
+}
+
+func TestLockSettingsFromEnv(t *testing.T) {
+	t.Setenv("DOMAIN_LOCK_LEASE", "true")
+	t.Setenv("DOMAIN_LOCK_LEASE_DURATION", "15s")
+	settings, err := lockSettingsFromEnv()
+	require.NoError(t, err)
+	require.True(t, settings.lease)
+	require.Equal(t, 15*time.Second, settings.leaseDuration)
+
+	for _, invalid := range []string{"0", "500ms", "-1s"} {
+		t.Setenv("DOMAIN_LOCK_LEASE_DURATION", invalid)
+		_, err := lockSettingsFromEnv()
+		require.ErrorContains(t, err, "DOMAIN_LOCK_LEASE_DURATION must be at least 1s", invalid)
+	}
+}
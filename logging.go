@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+type correlationIDKey struct{}
+
+// namedLogger returns the logger of the solver named name, based on the
+// logger of the solver or the klog logger if it has none. Credentials are
+// redacted from everything logged with it, including the errors of the API
+// client.
+func (y *yandex360DNSSolver) namedLogger(name string) klog.Logger {
+	logger := y.logger
+	if logger.GetSink() == nil {
+		logger = klog.Background()
+	}
+	return klog.LoggerWithName(yandex360api.RedactLogger(logger), name)
+}
+
+// challengeContext returns a context carrying logger for the operation on ch.
+// Every line logged for the request, including the ones of the API client,
+// carries the same correlationId, which is also added to the span.
+func challengeContext(ctx context.Context, logger klog.Logger, ch *v1alpha1.ChallengeRequest) (context.Context, klog.Logger) {
+	id := correlationID(ch)
+	logger = klog.LoggerWithValues(logger, "correlationId", id)
+	if ch != nil {
+		logger = klog.LoggerWithValues(logger, "fqdn", ch.ResolvedFQDN, "zone", ch.ResolvedZone, "namespace", ch.ResourceNamespace)
+	}
+
+	ctx = context.WithValue(ctx, correlationIDKey{}, id)
+	return klog.NewContext(ctx, logger), logger
+}
+
+// correlationID identifies the challenge of ch. cert-manager does not set the
+// UID of the request, so the id is derived from the key and the FQDN, which are
+// the same for Present and CleanUp of one challenge. A random id is used if
+// there is no request.
+func correlationID(ch *v1alpha1.ChallengeRequest) string {
+	if ch != nil && (ch.Key != "" || ch.ResolvedFQDN != "") {
+		sum := sha256.Sum256([]byte(ch.ResolvedFQDN + "\n" + ch.Key))
+		return hex.EncodeToString(sum[:8])
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// correlationIDFrom returns the correlation id stored by challengeContext.
+func correlationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withApiSettings adds the organization and the domain of apiSettings to the
+// logger of ctx.
+func withApiSettings(ctx context.Context, logger klog.Logger, apiSettings *yandex360api.ApiSettings) (context.Context, klog.Logger) {
+	logger = klog.LoggerWithValues(logger, "organizationId", apiSettings.OrganizationId, "domain", apiSettings.Domain)
+	return klog.NewContext(ctx, logger), logger
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/textlogger"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+func TestChallengeContext(t *testing.T) {
+	ch := &v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example1.com.", ResourceNamespace: "default"}
+
+	ctx, logger := challengeContext(context.TODO(), klog.Background(), ch)
+	id := correlationIDFrom(ctx)
+	require.Len(t, id, 16)
+	require.Equal(t, logger, klog.FromContext(ctx))
+
+	// CleanUp of the same challenge logs the same id
+	ctx, _ = challengeContext(context.TODO(), klog.Background(), ch.DeepCopy())
+	require.Equal(t, id, correlationIDFrom(ctx))
+
+	// another challenge of the same name does not
+	ctx, _ = challengeContext(context.TODO(), klog.Background(), &v1alpha1.ChallengeRequest{Key: "other", ResolvedFQDN: ch.ResolvedFQDN})
+	require.NotEqual(t, id, correlationIDFrom(ctx))
+
+	ctx, _ = challengeContext(context.TODO(), klog.Background(), &v1alpha1.ChallengeRequest{})
+	require.Len(t, correlationIDFrom(ctx), 16)
+}
+
+func TestSolver_LogsCorrelationIDWithoutToken(t *testing.T) {
+	var buf bytes.Buffer
+	solver, apiUrl := newTestSolver(t)
+	solver.logger = textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(10), textlogger.Output(&buf)))
+	ch := challengeRequest(apiUrl, "_acme-challenge.example1.com.", "logged-key")
+
+	require.NoError(t, solver.Present(ch))
+	require.NoError(t, solver.CleanUp(ch))
+
+	require.Contains(t, buf.String(), `correlationId="`+correlationID(ch)+`"`)
+	require.Contains(t, buf.String(), "Yandex 360 API request")
+	require.NotContains(t, buf.String(), mockToken)
+
+	// errors are redacted whatever the call site
+	solver.namedLogger("test").Error(fmt.Errorf("failed to GetDnsRecords: %w", &url.Error{Op: "Get", URL: apiUrl.String() + "?access_token=" + mockToken, Err: errors.New("EOF")}), "Failed")
+	require.Contains(t, buf.String(), "access_token=[REDACTED]")
+	require.NotContains(t, buf.String(), mockToken)
+}
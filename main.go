@@ -3,21 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/logs"
 	"k8s.io/klog/v2"
 
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
-	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd/server"
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
 	certmgrapiv1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	cmclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
 
 	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
 )
@@ -34,9 +45,51 @@ func main() {
 	// You can register multiple DNS provider implementations with a single
 	// webhook, where the Name() method will be used to disambiguate between
 	// the different implementations.
-	cmd.RunWebhookServer(GroupName,
-		New(),
-	)
+	solver := New().(*yandex360DNSSolver)
+	if MetricsAddr != "" {
+		go serveMetrics(MetricsAddr, solver.health)
+	}
+	if DebugAddr != "" {
+		go serveDebug(DebugAddr, solver.health)
+	}
+
+	stopCh := setupSignalHandler()
+	shutdownTracing := setupTracing(context.Background())
+
+	logs.InitLogs()
+	command := server.NewCommandStartWebhookServer(os.Stdout, os.Stderr, stopCh, GroupName, solver)
+	solver.apiConfig.AddFlags(command.Flags())
+	solver.settings.AddFlags(command.Flags())
+	command.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		// once the flags are parsed, so the file of --config is read
+		applyLoggingConfig(solver.settings.path, cmd.Flags())
+		return nil
+	}
+	err := command.Execute()
+	// the server is stopped, let the challenges it accepted finish
+	solver.operations.drain()
+
+	shutdownTracing()
+	logs.FlushLogs()
+	if err != nil {
+		klog.ErrorS(err, "Failed to run the webhook server")
+		os.Exit(1)
+	}
+}
+
+// setupSignalHandler returns a channel closed on SIGINT or SIGTERM, like
+// cmd.RunWebhookServer does. A second signal exits immediately.
+func setupSignalHandler() <-chan struct{} {
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopCh)
+		<-signals
+		os.Exit(1)
+	}()
+	return stopCh
 }
 
 // yandex360DNSProviderSolver implements the provider-specific logic needed to
@@ -44,9 +97,45 @@ func main() {
 // To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
 // interface.
 type yandex360DNSSolver struct {
-	name      string
+	name string
+	// logger is the base of the loggers of the solver, the klog logger if
+	// unset
+	logger    klog.Logger
 	apiClient *yandex360api.ApiClient
-	k8sClient *kubernetes.Clientset
+	// apiConfig configures apiClient, which is created by Initialize after
+	// the flags are parsed
+	apiConfig *apiClientConfig
+	// settings are the webhook wide defaults, reloaded from the config file
+	settings  *webhookSettings
+	k8sClient kubernetes.Interface
+	cmClient  cmclient.Interface
+	registry  *recordRegistry
+	cleanups  *cleanupQueue
+	policy    *policyEnforcer
+	tokens    *secretTokens
+	recorder  record.EventRecorder
+	// challenges caches the Challenge resources, indexed by challengeKeyIndex
+	challenges cache.SharedIndexInformer
+	gc         *garbageCollector
+	// tokenMonitor warns about expiring API tokens
+	tokenMonitor     *tokenMonitor
+	selfTestSettings selfTestSettings
+	locks            *domainLocker
+	// health tracks the API requests and challenges for /debug/status
+	health *healthTracker
+	// operations drains the running Present and CleanUp calls on shutdown
+	operations *operationTracker
+
+	lockSettings lockSettings
+
+	propagation *propagationWatcher
+
+	// solverConfigs resolves the configRef of the issuers
+	solverConfigs *solverConfigs
+
+	// envErr reports the invalid settings read from the environment by New,
+	// returned by Initialize
+	envErr error
 }
 
 // customDNSProviderConfig is a structure that is used to decode into when
@@ -68,6 +157,12 @@ type yandex360DNSProviderConfig struct {
 	OrganizationId    int                            `json:"organizationId"`
 	APITokenSecretRef certmgrapiv1.SecretKeySelector `json:"apiTokenSecretRef"`
 	TTL               int                            `json:"ttl"`
+	// OAuthTokenURL is the token endpoint refreshing the OAuth tokens of
+	// secrets with a refresh_token, OAUTH_TOKEN_URL if empty
+	OAuthTokenURL string `json:"oauthTokenURL,omitempty"`
+	// ConfigRef references a Yandex360SolverConfig or
+	// ClusterYandex360SolverConfig the fields above are merged into
+	ConfigRef *solverConfigRef `json:"configRef,omitempty"`
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -85,25 +180,75 @@ func (y *yandex360DNSSolver) Name() string {
 // This method should tolerate being called multiple times with the same value.
 // cert-manager itself will later perform a self check to ensure that the
 // solver has correctly configured the DNS provider.
-func (y *yandex360DNSSolver) Present(ch *v1alpha1.ChallengeRequest) error {
-	var chString string
-	if ch != nil {
-		chString = fmt.Sprintf("rn: %s, rz: %s, rfqdn: %s, dnsn: %s", ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN, ch.DNSName)
+func (y *yandex360DNSSolver) Present(ch *v1alpha1.ChallengeRequest) (err error) {
+	defer observeOperation("present", time.Now(), &err)
+	ctx, logger := challengeContext(context.Background(), y.namedLogger("present"), ch)
+	defer func() {
+		if err != nil {
+			logger.Error(err, "Failed to present challenge record")
+		}
+	}()
+	ctx, done, err := y.operations.begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer done()
+	defer y.health.track(ctx, "present", ch)()
+	ctx, span := startSolverSpan(ctx, "Present", ch)
+	defer func() { endSpan(span, err) }()
 
-	klog.Infof("solver.present: ch.: %s", chString)
+	logger.V(2).Info("Presenting challenge record")
 
-	apiSettings, err := y.getApiSettingsForChallengeRequest(ch)
+	apiSettings, err := y.getApiSettingsForChallengeRequest(ctx, ch)
 	if err != nil {
 		return err
 	}
-	klog.Infof("solver.present: after getApiSettingsForChallengeRequest: api: %s, orgId:%d, ttl:%d, token len:%d ", apiSettings.ApiUrl, apiSettings.OrganizationId, apiSettings.TTL, len(apiSettings.Token))
+	traceApiSettings(span, apiSettings)
+	ctx, logger = withApiSettings(ctx, logger, apiSettings)
+
+	challenge := y.challengeFor(ctx, ch)
+	if err := y.checkPolicy(ctx, "present", challenge, "", ch, apiSettings); err != nil {
+		return err
+	}
 
+	lockedCtx, unlock, err := y.lockDomain(ctx, apiSettings)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ctx = lockedCtx
 	name := strings.TrimSuffix(ch.ResolvedFQDN, "."+apiSettings.Domain+".")
-	err = y.apiClient.AddTxtRecord(apiSettings, name, ch.Key, apiSettings.TTL)
+
+	records, err := y.apiClient.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		y.recordAPIError(challenge, err)
+		return err
+	}
+	if existing := challengeRecords(records, name, ch.Key); len(existing) > 0 {
+		logger.Info("Challenge record already present", "recordId", existing[0].RecordID)
+		y.recordEvent(challenge, corev1.EventTypeNormal, reasonRecordReused, "TXT record %s already present in %s, organization %d (id %d)", name, apiSettings.Domain, apiSettings.OrganizationId, existing[0].RecordID)
+		y.watchPropagation(ctx, challenge, ch)
+		return nil
+	}
+
+	record, err := y.apiClient.AddTxtRecord(ctx, apiSettings, name, ch.Key, apiSettings.TTL)
 	if err != nil {
+		y.recordAPIError(challenge, err)
 		return err
 	}
+
+	// registered even if the shutdown cancels ctx, the record exists now
+	err = y.registry.Add(context.WithoutCancel(ctx), apiSettings, *record)
+	if err != nil {
+		// a record missing from the registry would never be cleaned up
+		if delErr := y.apiClient.DeleteDnsRecord(ctx, apiSettings, record.RecordID); delErr != nil {
+			logger.Error(delErr, "Failed to delete unregistered record", "recordId", record.RecordID)
+		}
+		return err
+	}
+	logger.Info("Created challenge record", "recordId", record.RecordID)
+	y.recordEvent(challenge, corev1.EventTypeNormal, reasonRecordCreated, "Created TXT record %s in %s, organization %d (id %d)", name, apiSettings.Domain, apiSettings.OrganizationId, record.RecordID)
+	y.watchPropagation(ctx, challenge, ch)
 	return nil
 }
 
@@ -113,20 +258,134 @@ func (y *yandex360DNSSolver) Present(ch *v1alpha1.ChallengeRequest) error {
 // value provided on the ChallengeRequest should be cleaned up.
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
+// Records not created by the webhook are never deleted, even if they match.
 func (y *yandex360DNSSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
-	apiSettings, err := y.getApiSettingsForChallengeRequest(ch)
+	return y.cleanUp(ch, "")
+}
+
+// cleanUp runs the CleanUp of ch. challengeNamespace is the namespace of the
+// Challenge the policy is checked for if the Challenge is gone, which it
+// usually is when a queued cleanup is retried.
+func (y *yandex360DNSSolver) cleanUp(ch *v1alpha1.ChallengeRequest, challengeNamespace string) (err error) {
+	defer observeOperation("cleanup", time.Now(), &err)
+	ctx, logger := challengeContext(context.Background(), y.namedLogger("cleanup"), ch)
+	defer func() {
+		if err != nil {
+			logger.Error(err, "Failed to clean up challenge record")
+			// cert-manager may not call CleanUp again
+			y.enqueueCleanup(ctx, ch, challengeNamespace, err)
+		}
+	}()
+	ctx, done, err := y.operations.begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer done()
+	defer y.health.track(ctx, "cleanup", ch)()
+	ctx, span := startSolverSpan(ctx, "CleanUp", ch)
+	defer func() { endSpan(span, err) }()
 
-	name := strings.TrimSuffix(ch.ResolvedFQDN, "."+apiSettings.Domain+".")
-	err = y.apiClient.DeleteTxtRecordByName(apiSettings, name)
+	logger.V(2).Info("Cleaning up challenge record")
+
+	y.propagation.Stop(ch.ResolvedFQDN, ch.Key)
+
+	apiSettings, err := y.getApiSettingsForChallengeRequest(ctx, ch)
+	if err != nil {
+		return err
+	}
+	traceApiSettings(span, apiSettings)
+	ctx, logger = withApiSettings(ctx, logger, apiSettings)
+
+	challenge := y.challengeFor(ctx, ch)
+	if err := y.checkPolicy(ctx, "cleanup", challenge, challengeNamespace, ch, apiSettings); err != nil {
+		return err
+	}
+
+	lockedCtx, unlock, err := y.lockDomain(ctx, apiSettings)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	ctx = lockedCtx
+
+	// the registry decides what may be deleted, the listing only locates the
+	// record, so a cached one can at worst miss a record left to the GC
+	records, err := y.apiClient.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		y.recordAPIError(challenge, err)
+		return err
+	}
+
+	owned, err := y.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(ch.ResolvedFQDN, "."+apiSettings.Domain+".")
+	for _, r := range challengeRecords(records, name, ch.Key) {
+		if owned[ownedRecordKey(apiSettings, r.RecordID)] == nil {
+			logger.Info("Refusing to delete record not created by the webhook", "recordId", r.RecordID, "name", name)
+			continue
+		}
+
+		err = y.apiClient.DeleteDnsRecord(ctx, apiSettings, r.RecordID)
+		if err != nil {
+			y.recordAPIError(challenge, err)
+			return err
+		}
+		err = y.registry.Remove(ctx, apiSettings, r.RecordID)
+		if err != nil {
+			return err
+		}
+		logger.Info("Deleted challenge record", "recordId", r.RecordID)
+		y.recordEvent(challenge, corev1.EventTypeNormal, reasonRecordDeleted, "Deleted TXT record %s in %s, organization %d (id %d)", name, apiSettings.Domain, apiSettings.OrganizationId, r.RecordID)
+	}
 	return nil
 }
 
+// lockDomain locks the domain of apiSettings against concurrent modifications
+// until the returned function is called. The domain must only be modified with
+// the returned context, which is canceled if the lock is lost.
+func (y *yandex360DNSSolver) lockDomain(ctx context.Context, apiSettings *yandex360api.ApiSettings) (context.Context, func(), error) {
+	ctx, unlock, err := y.locks.Lock(ctx, apiSettings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if y.locks.lease != nil {
+		// another replica may have modified the domain since it was cached
+		y.apiClient.InvalidateCache(apiSettings)
+	}
+	return ctx, unlock, nil
+}
+
+// watchPropagation records a warning against challenge if the presented record
+// does not propagate in time.
+func (y *yandex360DNSSolver) watchPropagation(ctx context.Context, challenge *cmacme.Challenge, ch *v1alpha1.ChallengeRequest) {
+	logger := klog.FromContext(ctx)
+	fqdn := ch.ResolvedFQDN
+	y.propagation.Watch(fqdn, ch.Key, func(elapsed time.Duration, propagated bool) {
+		if propagated {
+			solverPropagationDuration.WithLabelValues("propagated").Observe(elapsed.Seconds())
+			logger.Info("Challenge record propagated", "elapsed", elapsed.Round(time.Second))
+			return
+		}
+		solverPropagationDuration.WithLabelValues("timeout").Observe(elapsed.Seconds())
+		logger.Info("Challenge record not propagated in time", "elapsed", elapsed.Round(time.Second), "nameservers", y.propagation.nameservers)
+		y.recordEvent(challenge, corev1.EventTypeWarning, reasonPropagationTimeout, "TXT record %s not visible on %s after %s", fqdn, strings.Join(y.propagation.nameservers, ", "), elapsed.Round(time.Second))
+	})
+}
+
+// challengeRecords returns the TXT records named name with value key.
+func challengeRecords(records []yandex360api.DnsRecord, name string, key string) []yandex360api.DnsRecord {
+	var matching []yandex360api.DnsRecord
+	for _, r := range records {
+		if r.Type == yandex360api.TXTKey && r.Name == name && strings.Trim(r.Text, `"`) == key {
+			matching = append(matching, r)
+		}
+	}
+	return matching
+}
+
 // Initialize will be called when the webhook first starts.
 // This method can be used to instantiate the webhook, i.e. initialising
 // connections or warming up caches.
@@ -137,6 +396,25 @@ func (y *yandex360DNSSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
 func (y *yandex360DNSSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	if y.envErr != nil {
+		return fmt.Errorf("invalid environment: %w", y.envErr)
+	}
+
+	if y.apiClient == nil {
+		opts, err := y.apiConfig.options()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, yandex360api.WithObserver(y.health.observe))
+		y.apiClient = yandex360api.NewApiClient(opts...)
+	}
+	y.health.setCircuits(y.apiClient.CircuitStates)
+	if err := y.reloadWebhookConfig(context.Background(), true); err != nil {
+		return err
+	}
+	if y.settings.path != "" && y.settings.reloadInterval > 0 {
+		go y.watchWebhookConfig(stopCh)
+	}
 
 	if y.k8sClient != nil {
 		return nil
@@ -147,7 +425,66 @@ func (y *yandex360DNSSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-
 		return err
 	}
 
+	cmcl, err := cmclient.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	dyncl, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
 	y.k8sClient = cl
+	y.cmClient = cmcl
+	y.solverConfigs = newSolverConfigs(dyncl, stopCh)
+	y.registry = newRecordRegistry(cl)
+	if y.cleanups, err = newCleanupQueue(cl); err != nil {
+		return err
+	}
+	y.health.setCleanupQueue(y.cleanups.status)
+	y.policy = newPolicyEnforcer(cl)
+	oauth, err := oauthSettingsFromEnv()
+	if err != nil {
+		return err
+	}
+	y.tokens = newSecretTokens(cl, stopCh, y.apiClient, oauth)
+	y.recorder = newEventRecorder(cl, stopCh)
+	if err := y.startChallengeInformer(stopCh); err != nil {
+		return err
+	}
+	if y.lockSettings.lease {
+		y.locks.lease = newLeaseLocker(cl, y.lockSettings)
+	}
+
+	go func() {
+		<-stopCh
+		y.operations.drain()
+		y.propagation.Close()
+	}()
+	go y.runCleanupQueue(stopCh)
+
+	if y.gc.interval > 0 {
+		go y.gc.Run(stopCh)
+	}
+	if y.tokenMonitor.interval > 0 {
+		go y.tokenMonitor.Run(stopCh)
+	}
+	if y.selfTestSettings.enabled {
+		// in the background, a failing check must not keep the webhook from serving
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), y.selfTestSettings.timeout)
+			defer cancel()
+			go func() {
+				select {
+				case <-stopCh:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			y.selfTest(ctx)
+		}()
+	}
 	return nil
 }
 
@@ -166,66 +503,86 @@ func loadConfig(cfgJSON *extapi.JSON) (yandex360DNSProviderConfig, error) {
 	return cfg, nil
 }
 
-func (y *yandex360DNSSolver) getApiSettingsForChallengeRequest(ch *v1alpha1.ChallengeRequest) (*yandex360api.ApiSettings, error) {
-	var chString string
-	if ch != nil {
-		chString = fmt.Sprintf("rn: %s, rz: %s, rfqdn: %s, dnsn: %s", ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN, ch.DNSName)
-	}
-
-	klog.Infof("solver.getApiSettingsForChallengeRequest ch.: %s", chString)
-
+func (y *yandex360DNSSolver) getApiSettingsForChallengeRequest(ctx context.Context, ch *v1alpha1.ChallengeRequest) (*yandex360api.ApiSettings, error) {
 	cfg, err := loadConfig(ch.Config)
 	if err != nil {
 		return nil, err
 	}
-
-	apiUrl, err := url.Parse(cfg.Endpoint)
-
+	cfg, err = y.resolveConfig(ctx, cfg, ch.ResourceNamespace)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := y.secret(cfg.APITokenSecretRef, ch.ResourceNamespace)
-	if err != nil {
-		return nil, err
+	if !y.settings.defaults().allowsDomain(ch.ResolvedFQDN) {
+		return nil, fmt.Errorf("%s is not in the allowed domains of the webhook config", ch.ResolvedFQDN)
 	}
 
 	domain := getDomainFromZone(ch.ResolvedZone)
 
-	ttl := 300
-	if cfg.TTL > 0 {
-		ttl = cfg.TTL
+	apiSettings, err := y.getApiSettings(ctx, cfg, ch.ResourceNamespace, domain)
+	if err != nil {
+		return nil, err
 	}
-
-	apiSettings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: token, OrganizationId: cfg.OrganizationId, Domain: domain, TTL: ttl}
-	klog.Infof("solver.getApiSettingsForChallengeRequest ch.: %s, api:%s, token len:%d, orgId:%d, domain:%s, ttl:%d ", chString, apiUrl, len(token), cfg.OrganizationId, domain, ttl)
+	klog.FromContext(ctx).V(4).Info("Resolved API settings", "settings", apiSettings)
 	return apiSettings, nil
 }
 
-func (s *yandex360DNSSolver) secret(ref certmgrapiv1.SecretKeySelector, namespace string) (string, error) {
-	klog.Infof("solver.secret name:%s", ref.Name)
-	if ref.Name == "" {
-		return "", nil
+// getApiSettings resolves the solver config into settings for the given
+// domain, reading the token from the secret in namespace.
+func (y *yandex360DNSSolver) getApiSettings(ctx context.Context, cfg yandex360DNSProviderConfig, namespace string, domain string) (*yandex360api.ApiSettings, error) {
+	defaults := y.settings.defaults()
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaults.endpoint
 	}
+	apiUrl, err := url.Parse(endpoint)
 
-	secret, err := s.k8sClient.CoreV1().Secrets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
 	if err != nil {
-		klog.Errorf("solver.secret: calling k8s: %v", err)
-		return "", err
+		return nil, err
 	}
 
-	bytes, ok := secret.Data[ref.Key]
-	if !ok {
-		return "", fmt.Errorf("key not found %q in secret '%s/%s'", ref.Key, namespace, ref.Name)
+	var tokens yandex360api.TokenSource
+	if cfg.APITokenSecretRef.Name != "" {
+		tokens = y.tokens.Source(namespace, cfg.APITokenSecretRef, cfg.OAuthTokenURL)
 	}
-	return strings.TrimSuffix(string(bytes), "\n"), nil
+
+	ttl := defaults.ttl
+	if cfg.TTL > 0 {
+		ttl = cfg.TTL
+	}
+
+	return &yandex360api.ApiSettings{ApiUrl: apiUrl, TokenSource: tokens, OrganizationId: cfg.OrganizationId, Domain: domain, TTL: ttl}, nil
 }
 
+// New returns the solver configured from the environment. Invalid settings
+// do not panic, they are returned by Initialize.
 func New() webhook.Solver {
+	apiConfig, apiErr := apiClientConfigFromEnv()
+	lockSettings, lockErr := lockSettingsFromEnv()
+	health, healthErr := healthSettingsFromEnv()
+	operations, shutdownErr := newOperationTracker()
+	propagation, propagationErr := propagationSettingsFromEnv()
+	settings, webhookErr := webhookSettingsFromEnv(apiConfig)
+	gc, gcErr := gcSettingsFromEnv()
+	tokenMonitor, tokenMonitorErr := tokenMonitorSettingsFromEnv()
+	selfTest, selfTestErr := selfTestSettingsFromEnv()
+
 	e := &yandex360DNSSolver{
-		name:      "yandex360-dns-solver",
-		apiClient: yandex360api.NewApiClient(),
+		name:         "yandex360-dns-solver",
+		apiConfig:    apiConfig,
+		settings:     settings,
+		locks:        newDomainLocker(),
+		lockSettings: lockSettings,
+		health:       newHealthTracker(health),
+		operations:   operations,
+
+		propagation:      newPropagationWatcher(propagation),
+		selfTestSettings: selfTest,
+
+		envErr: errors.Join(apiErr, lockErr, healthErr, shutdownErr, propagationErr, webhookErr, gcErr, tokenMonitorErr, selfTestErr),
 	}
+	e.gc = newGarbageCollector(e, gc)
+	e.tokenMonitor = newTokenMonitor(e, tokenMonitor)
 	return e
 }
 
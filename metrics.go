@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// MetricsAddr is the address metrics are served on, empty disables them.
+var MetricsAddr = getEnv("METRICS_ADDR", ":9402")
+
+var (
+	solverOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "operations_total",
+		Help:      "Number of Present and CleanUp calls by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	solverOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of Present and CleanUp calls by operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+
+	solverPropagationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "propagation_duration_seconds",
+		Help:      "Time until a presented record was visible on the propagation check nameservers, by outcome (propagated or timeout).",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"outcome"})
+
+	solverLockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "lock_wait_seconds",
+		Help:      "Time spent waiting for the lock of a domain, by kind (local or lease).",
+		Buckets:   []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"kind"})
+
+	solverTokenRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "token_refreshes_total",
+		Help:      "Number of OAuth access token refreshes by outcome (success or error).",
+	}, []string{"outcome"})
+
+	solverTokenExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "token_expiry_days",
+		Help:      "Days until the API token of a Secret referenced by an issuer expires, negative once it has expired.",
+	}, []string{"namespace", "secret"})
+
+	solverSelfTestSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "self_test_success",
+		Help:      "Whether the credentials of an issuer passed the self-test on startup (1) or not (0).",
+	}, []string{"issuer"})
+
+	solverPolicyDenialsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "policy_denials_total",
+		Help:      "Number of Present and CleanUp calls denied by the namespace policy, by operation.",
+	}, []string{"operation"})
+
+	solverConfigReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "config_reloads_total",
+		Help:      "Number of loads of the webhook config file by outcome (success or error).",
+	}, []string{"outcome"})
+
+	solverCleanupQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "cleanup_queue_length",
+		Help:      "Number of failed CleanUps queued for a retry.",
+	})
+
+	solverCleanupRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "cleanup_retries_total",
+		Help:      "Number of retries of queued CleanUps by outcome (success, error or expired).",
+	}, []string{"outcome"})
+)
+
+// metricsRegistry holds the metrics of the webhook and the API client.
+var metricsRegistry = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		solverOperationsTotal,
+		solverOperationDuration,
+		solverPropagationDuration,
+		solverLockWait,
+		solverTokenRefreshesTotal,
+		solverTokenExpiryDays,
+		solverSelfTestSuccess,
+		solverPolicyDenialsTotal,
+		solverConfigReloadsTotal,
+		solverCleanupQueueLength,
+		solverCleanupRetriesTotal,
+	)
+	yandex360api.RegisterMetrics(registry)
+	return registry
+}
+
+// serveMetrics serves the metrics, including the availability of Yandex 360
+// tracked by health, on addr until the process exits.
+func serveMetrics(addr string, health *healthTracker) {
+	metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "solver",
+		Name:      "upstream_available",
+		Help:      "Whether the recent Yandex 360 API requests found it available (1) or not (0).",
+	}, health.upstreamAvailable))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	klog.InfoS("Serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.ErrorS(err, "Metrics server failed", "addr", addr)
+	}
+}
+
+// observeOperation records the outcome of a solver operation started at start,
+// to be deferred with a pointer to the named error result.
+func observeOperation(operation string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "error"
+	}
+	solverOperationsTotal.WithLabelValues(operation, outcome).Inc()
+	solverOperationDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
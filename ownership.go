@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// ownedRecord is a DNS record created by this webhook.
+type ownedRecord struct {
+	Host           string    `json:"host"`
+	OrganizationId int       `json:"organizationId"`
+	Domain         string    `json:"domain"`
+	RecordID       int       `json:"recordId"`
+	Name           string    `json:"name"`
+	Text           string    `json:"text"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// recordRegistry keeps track of the records created by this webhook in a
+// ConfigMap, one entry per record. Records missing from the registry were not
+// created by the webhook and are never deleted by it.
+type recordRegistry struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// newRecordRegistry returns the registry stored in the ConfigMap named
+// OWNERSHIP_CONFIGMAP in POD_NAMESPACE.
+func newRecordRegistry(client kubernetes.Interface) *recordRegistry {
+	return &recordRegistry{
+		client:    client,
+		namespace: getEnv("POD_NAMESPACE", "cert-manager"),
+		name:      getEnv("OWNERSHIP_CONFIGMAP", "cert-manager-webhook-yandex360-ownership"),
+	}
+}
+
+// Add marks record as created by this webhook.
+func (r *recordRegistry) Add(ctx context.Context, apiSettings *yandex360api.ApiSettings, record yandex360api.DnsRecord) error {
+	owned := ownedRecord{
+		Host:           apiSettings.ApiUrl.Host,
+		OrganizationId: apiSettings.OrganizationId,
+		Domain:         apiSettings.Domain,
+		RecordID:       record.RecordID,
+		Name:           record.Name,
+		Text:           record.Text,
+		CreatedAt:      time.Now().UTC(),
+	}
+	value, err := json.Marshal(owned)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, func(cm *corev1.ConfigMap) {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[ownedRecordKey(apiSettings, record.RecordID)] = string(value)
+	})
+}
+
+// List returns all owned records keyed by their registry key.
+func (r *recordRegistry) List(ctx context.Context) (map[string]*ownedRecord, error) {
+	cm, err := r.client.CoreV1().ConfigMaps(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]*ownedRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ownership registry %s/%s: %v", r.namespace, r.name, err)
+	}
+
+	records := make(map[string]*ownedRecord, len(cm.Data))
+	for key, value := range cm.Data {
+		var owned ownedRecord
+		if err := json.Unmarshal([]byte(value), &owned); err != nil {
+			return nil, fmt.Errorf("ownership registry %s/%s: invalid entry %q: %v", r.namespace, r.name, key, err)
+		}
+		records[key] = &owned
+	}
+	return records, nil
+}
+
+// Remove forgets the record, e.g. after it has been deleted.
+func (r *recordRegistry) Remove(ctx context.Context, apiSettings *yandex360api.ApiSettings, recordId int) error {
+	key := ownedRecordKey(apiSettings, recordId)
+	return r.update(ctx, func(cm *corev1.ConfigMap) {
+		delete(cm.Data, key)
+	})
+}
+
+// update applies mutate to the registry ConfigMap.
+func (r *recordRegistry) update(ctx context.Context, mutate func(cm *corev1.ConfigMap)) error {
+	if err := updateConfigMap(ctx, r.client, r.namespace, r.name, mutate); err != nil {
+		return fmt.Errorf("failed to update ownership registry %s/%s: %v", r.namespace, r.name, err)
+	}
+	return nil
+}
+
+// updateConfigMap applies mutate to the ConfigMap, creating it if needed and
+// retrying on conflicting concurrent updates.
+func updateConfigMap(ctx context.Context, client kubernetes.Interface, namespace string, name string, mutate func(cm *corev1.ConfigMap)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+			mutate(cm)
+			_, err = client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently, retry as an update
+				return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		mutate(cm)
+		_, err = client.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// ownedRecordKey returns the registry key of the record. ConfigMap keys may
+// only contain alphanumerics, '-', '_' and '.'.
+func ownedRecordKey(apiSettings *yandex360api.ApiSettings, recordId int) string {
+	host := strings.NewReplacer(":", "-", "[", "", "]", "").Replace(apiSettings.ApiUrl.Host)
+	return fmt.Sprintf("%s_%d_%s_%d", host, apiSettings.OrganizationId, apiSettings.Domain, recordId)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestCleanUp_OnlyDeletesOwnedRecords(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+
+	ch := challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")
+	require.NoError(t, solver.Present(ch))
+	require.NoError(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "other-key")))
+	// same name and value, but created by hand
+	manual, err := solver.apiClient.AddTxtRecord(context.TODO(), settings, "_acme-challenge", "key", 300)
+	require.NoError(t, err)
+
+	require.NoError(t, solver.CleanUp(ch))
+
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	var remaining []string
+	for _, r := range records {
+		if r.Name == "_acme-challenge" {
+			remaining = append(remaining, r.Text)
+			if r.Text == "key" {
+				require.Equal(t, manual.RecordID, r.RecordID)
+			}
+		}
+	}
+	require.ElementsMatch(t, []string{"key", "other-key"}, remaining)
+
+	owned, err := solver.registry.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, owned, 1)
+
+	// nothing left to clean up
+	require.NoError(t, solver.CleanUp(ch))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// policyKey is the key of the policy in its ConfigMap.
+const policyKey = "policy.yaml"
+
+// policy maps namespaces to the domains and organizations their challenges
+// may be solved in. A challenge is allowed if any rule matches it.
+type policy struct {
+	Rules []policyRule `json:"rules"`
+}
+
+// policyRule allows the namespaces it selects to solve challenges for
+// domains, in organizationIds.
+type policyRule struct {
+	// Namespaces are the names of the namespaces, "*" selects all
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces by their labels, in addition
+	// to Namespaces
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Domains are the DNS names that may be solved: "example.com" only
+	// allows example.com, "*.example.com" all names below it
+	Domains []string `json:"domains"`
+	// OrganizationIds the records may be written to, empty allows all
+	OrganizationIds []int `json:"organizationIds,omitempty"`
+}
+
+// policyDeniedError is returned for challenges the policy does not allow.
+type policyDeniedError struct {
+	namespace      string
+	dnsName        string
+	organizationId int
+}
+
+func (e *policyDeniedError) Error() string {
+	return fmt.Sprintf("denied by policy: namespace %q may not solve challenges for %q in organization %d", e.namespace, e.dnsName, e.organizationId)
+}
+
+// policyEnforcer checks challenges against the policy in the ConfigMap name in
+// namespace, read on every check so changes apply right away. With no name
+// every challenge is allowed.
+type policyEnforcer struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// newPolicyEnforcer returns the enforcer of the policy in the ConfigMap named
+// POLICY_CONFIGMAP in POD_NAMESPACE, unset disables the policy.
+func newPolicyEnforcer(client kubernetes.Interface) *policyEnforcer {
+	return &policyEnforcer{
+		client:    client,
+		namespace: getEnv("POD_NAMESPACE", "cert-manager"),
+		name:      getEnv("POLICY_CONFIGMAP", ""),
+	}
+}
+
+// Check returns a *policyDeniedError unless the policy allows namespace to
+// solve the challenge for dnsName in organizationId. A missing or invalid
+// policy denies everything.
+func (p *policyEnforcer) Check(ctx context.Context, namespace string, dnsName string, organizationId int) error {
+	if p == nil || p.name == "" {
+		return nil
+	}
+
+	pol, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	dnsName = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(dnsName), "*."), ".")
+	var namespaceLabels labels.Set
+	for _, rule := range pol.Rules {
+		if !rule.allowsDomain(dnsName) || !rule.allowsOrganization(organizationId) {
+			continue
+		}
+		if slices.Contains(rule.Namespaces, namespace) || slices.Contains(rule.Namespaces, "*") {
+			return nil
+		}
+		if rule.NamespaceSelector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("invalid namespaceSelector in policy %s/%s: %w", p.namespace, p.name, err)
+		}
+		if namespaceLabels == nil {
+			ns, err := p.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to get namespace %s for the policy: %w", namespace, err)
+			}
+			namespaceLabels = labels.Set(ns.Labels)
+		}
+		if selector.Matches(namespaceLabels) {
+			return nil
+		}
+	}
+
+	return &policyDeniedError{namespace: namespace, dnsName: dnsName, organizationId: organizationId}
+}
+
+func (p *policyEnforcer) load(ctx context.Context) (*policy, error) {
+	cm, err := p.client.CoreV1().ConfigMaps(p.namespace).Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("policy %s/%s not found, denying all challenges", p.namespace, p.name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get policy %s/%s: %w", p.namespace, p.name, err)
+	}
+
+	var pol policy
+	if err := yaml.UnmarshalStrict([]byte(cm.Data[policyKey]), &pol); err != nil {
+		return nil, fmt.Errorf("invalid policy %s/%s: %w", p.namespace, p.name, err)
+	}
+	return &pol, nil
+}
+
+// checkPolicy returns an error unless the policy allows the challenge of ch to
+// be solved with apiSettings. The namespace checked is the one of the
+// Challenge, which for a ClusterIssuer differs from the namespace of the
+// request. The name checked is the one the record is written for, i.e. the
+// FQDN after following CNAMEs without the _acme-challenge label.
+// challengeNamespace is checked if the Challenge is not found, e.g. when it
+// was deleted before a queued cleanup is retried.
+func (y *yandex360DNSSolver) checkPolicy(ctx context.Context, operation string, challenge *cmacme.Challenge, challengeNamespace string, ch *v1alpha1.ChallengeRequest, apiSettings *yandex360api.ApiSettings) error {
+	if y.policy == nil || y.policy.name == "" {
+		return nil
+	}
+	if challenge != nil {
+		challengeNamespace = challenge.Namespace
+	}
+	if challengeNamespace == "" {
+		return fmt.Errorf("denied by policy: Challenge of %s not found, its namespace is unknown", ch.ResolvedFQDN)
+	}
+
+	name := strings.TrimPrefix(ch.ResolvedFQDN, acmeChallengeLabel+".")
+	err := y.policy.Check(ctx, challengeNamespace, name, apiSettings.OrganizationId)
+	var denied *policyDeniedError
+	if errors.As(err, &denied) {
+		solverPolicyDenialsTotal.WithLabelValues(operation).Inc()
+		y.recordEvent(challenge, corev1.EventTypeWarning, reasonPolicyDenied, "%v", err)
+	}
+	return err
+}
+
+func (r policyRule) allowsDomain(dnsName string) bool {
+	return matchesDomain(r.Domains, dnsName)
+}
+
+// matchesDomain returns whether the lower case dnsName is one of domains, where
+// "*.example.com" matches every name below example.com.
+func matchesDomain(domains []string, dnsName string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if parent, ok := strings.CutPrefix(domain, "*."); ok {
+			if strings.HasSuffix(dnsName, "."+parent) {
+				return true
+			}
+			continue
+		}
+		if dnsName == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func (r policyRule) allowsOrganization(organizationId int) bool {
+	return len(r.OrganizationIds) == 0 || slices.Contains(r.OrganizationIds, organizationId)
+}
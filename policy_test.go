@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+const testPolicy = `
+rules:
+  - namespaces: [team-a]
+    domains: [example1.com, "*.example1.com"]
+    organizationIds: [1001]
+  - namespaceSelector:
+      matchLabels:
+        team: b
+    domains: ["*.b.example2.com"]
+`
+
+func policyConfigMap(policy string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "cert-manager"},
+		Data:       map[string]string{policyKey: policy},
+	}
+}
+
+func TestPolicyEnforcer_Check(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		policyConfigMap(testPolicy),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c"}},
+	)
+	enforcer := &policyEnforcer{client: client, namespace: "cert-manager", name: "policy"}
+
+	for _, tc := range []struct {
+		namespace      string
+		dnsName        string
+		organizationId int
+		allowed        bool
+	}{
+		{"team-a", "example1.com", 1001, true},
+		{"team-a", "www.Example1.com.", 1001, true},
+		{"team-a", "*.example1.com", 1001, true},
+		{"team-a", "example1.com", 1002, false},
+		{"team-a", "notexample1.com", 1001, false},
+		{"team-b", "www.b.example2.com", 1002, true},
+		{"team-b", "b.example2.com", 1002, false},
+		{"team-c", "www.b.example2.com", 1002, false},
+	} {
+		err := enforcer.Check(context.TODO(), tc.namespace, tc.dnsName, tc.organizationId)
+		if tc.allowed {
+			require.NoError(t, err, tc)
+			continue
+		}
+		var denied *policyDeniedError
+		require.ErrorAs(t, err, &denied, tc)
+	}
+
+	// no policy, no challenges
+	require.ErrorContains(t, (&policyEnforcer{client: client, namespace: "cert-manager", name: "missing"}).Check(context.TODO(), "team-a", "example1.com", 1001), "policy cert-manager/missing not found")
+	_, err := client.CoreV1().ConfigMaps("cert-manager").Update(context.TODO(), policyConfigMap("rules:\n  - namespace: team-a\n"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.ErrorContains(t, enforcer.Check(context.TODO(), "team-a", "example1.com", 1001), "invalid policy cert-manager/policy")
+
+	// a disabled policy allows everything
+	require.NoError(t, (&policyEnforcer{client: client}).Check(context.TODO(), "team-c", "example1.com", 1001))
+}
+
+func TestSolver_PresentDeniedByPolicy(t *testing.T) {
+	challenge := &cmacme.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: "challenge", Namespace: "default"},
+		Spec:       cmacme.ChallengeSpec{Key: "key", DNSName: "www.example1.com"},
+	}
+	solver, apiUrl := newTestSolver(t, challenge)
+	recorder := record.NewFakeRecorder(10)
+	solver.recorder = recorder
+	_, err := solver.k8sClient.CoreV1().ConfigMaps("cert-manager").Create(context.TODO(), policyConfigMap("rules:\n  - namespaces: [default]\n    domains: [example1.com]\n"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	solver.policy = &policyEnforcer{client: solver.k8sClient, namespace: "cert-manager", name: "policy"}
+
+	ch := challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "key")
+	ch.DNSName = "www.example1.com"
+	require.ErrorContains(t, solver.Present(ch), `denied by policy: namespace "default" may not solve challenges for "www.example1.com" in organization 1001`)
+	require.Contains(t, <-recorder.Events, "Warning PolicyDenied denied by policy")
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"})
+	require.NoError(t, err)
+	require.Empty(t, challengeRecords(records, "_acme-challenge.www", "key"))
+	require.Error(t, solver.CleanUp(ch))
+
+	// allowed once the policy is changed
+	_, err = solver.k8sClient.CoreV1().ConfigMaps("cert-manager").Update(context.TODO(), policyConfigMap("rules:\n  - namespaces: [default]\n    domains: [\"*.example1.com\"]\n"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, solver.Present(ch))
+	require.NoError(t, solver.CleanUp(ch))
+
+	// unknown challenges are denied
+	require.ErrorContains(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "unknown")), "Challenge of _acme-challenge.www.example1.com. not found")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// propagationSettings configures the check whether a presented record is
+// visible to the outside world.
+type propagationSettings struct {
+	// nameservers to query, e.g. "8.8.8.8:53"; empty disables the check
+	nameservers []string
+	timeout     time.Duration
+	interval    time.Duration
+}
+
+// propagationSettingsFromEnv reads the propagation check settings from the
+// environment:
+//
+//	PROPAGATION_NAMESERVERS  comma separated list, e.g. "8.8.8.8:53,1.1.1.1:53"
+//	PROPAGATION_TIMEOUT      give up after, default "10m"
+//	PROPAGATION_INTERVAL     query every, default "30s"
+func propagationSettingsFromEnv() (propagationSettings, error) {
+	var env envErrors
+	var nameservers []string
+	for _, ns := range strings.Split(getEnv("PROPAGATION_NAMESERVERS", ""), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			nameservers = append(nameservers, ns)
+		}
+	}
+
+	settings := propagationSettings{
+		nameservers: nameservers,
+		timeout:     env.duration("PROPAGATION_TIMEOUT", "10m"),
+		interval:    env.duration("PROPAGATION_INTERVAL", "30s"),
+	}
+	return settings, env.err()
+}
+
+// propagationWatcher polls the configured nameservers in the background until
+// a presented TXT record becomes visible on all of them. Yandex 360 is known
+// to publish changes very slowly, so this does not block Present.
+type propagationWatcher struct {
+	propagationSettings
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	watches map[string]context.CancelFunc
+}
+
+func newPropagationWatcher(settings propagationSettings) *propagationWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &propagationWatcher{
+		propagationSettings: settings,
+		ctx:                 ctx,
+		cancel:              cancel,
+		watches:             map[string]context.CancelFunc{},
+	}
+}
+
+// Watch starts polling for the TXT record fqdn with value key. done is called
+// with the time it took the record to propagate, or with propagated false on
+// timeout. It is not called if the watch is stopped.
+func (p *propagationWatcher) Watch(fqdn string, key string, done func(elapsed time.Duration, propagated bool)) {
+	if len(p.nameservers) == 0 {
+		return
+	}
+
+	id := fqdn + "|" + key
+	p.mu.Lock()
+	if _, ok := p.watches[id]; ok {
+		p.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	p.watches[id] = cancel
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.watches, id)
+			p.mu.Unlock()
+			cancel()
+		}()
+
+		start := time.Now()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			if p.visible(ctx, fqdn, key) {
+				done(time.Since(start), true)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					done(time.Since(start), false)
+				}
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops watching the record, e.g. because it has been cleaned up.
+func (p *propagationWatcher) Stop(fqdn string, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cancel, ok := p.watches[fqdn+"|"+key]; ok {
+		cancel()
+		delete(p.watches, fqdn+"|"+key)
+	}
+}
+
+// Close stops all watches.
+func (p *propagationWatcher) Close() {
+	p.cancel()
+}
+
+// visible reports whether every nameserver returns key for fqdn.
+func (p *propagationWatcher) visible(ctx context.Context, fqdn string, key string) bool {
+	client := &dns.Client{Timeout: 5 * time.Second}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+
+	for _, ns := range p.nameservers {
+		in, _, err := client.ExchangeContext(ctx, msg, ns)
+		if err != nil {
+			klog.FromContext(ctx).V(4).Info("Propagation check query failed", "nameserver", ns, "fqdn", fqdn, "err", err)
+			return false
+		}
+		if !containsTXT(in, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTXT(msg *dns.Msg, value string) bool {
+	for _, rr := range msg.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// runTXTServer serves the TXT record fqdn with value on a local UDP port.
+func runTXTServer(t *testing.T, fqdn string, value string) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		if req.Question[0].Name == fqdn {
+			msg.Answer = append(msg.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 5},
+				Txt: []string{value},
+			})
+		}
+		w.WriteMsg(msg)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestPropagationWatcher(t *testing.T) {
+	ns := runTXTServer(t, "_acme-challenge.example.com.", "key")
+	watcher := newPropagationWatcher(propagationSettings{nameservers: []string{ns}, timeout: 500 * time.Millisecond, interval: 50 * time.Millisecond})
+	defer watcher.Close()
+
+	result := make(chan bool, 2)
+	done := func(_ time.Duration, propagated bool) { result <- propagated }
+
+	watcher.Watch("_acme-challenge.example.com.", "key", done)
+	require.True(t, <-result)
+
+	watcher.Watch("_acme-challenge.example.com.", "other-key", done)
+	require.False(t, <-result)
+
+	// stopped watches never report
+	watcher.Watch("_acme-challenge.example.com.", "stopped-key", done)
+	watcher.Stop("_acme-challenge.example.com.", "stopped-key")
+	select {
+	case <-result:
+		t.Fatal("stopped watch reported a result")
+	case <-time.After(time.Second):
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// Reasons of the events recorded against Issuers and ClusterIssuers.
+const (
+	reasonSelfTestPassed = "SelfTestPassed"
+	reasonSelfTestFailed = "SelfTestFailed"
+)
+
+// selfTestSettings configures the self-test run on startup.
+type selfTestSettings struct {
+	enabled bool
+	// timeout of the whole self-test
+	timeout time.Duration
+}
+
+// selfTestSettingsFromEnv reads the self-test settings from the environment:
+//
+//	SELF_TEST          test the credentials of the issuers on startup, default "true"
+//	SELF_TEST_TIMEOUT  timeout of the self-test, default "2m"
+func selfTestSettingsFromEnv() (selfTestSettings, error) {
+	var env envErrors
+	settings := selfTestSettings{
+		enabled: env.boolean("SELF_TEST", "true"),
+		timeout: env.duration("SELF_TEST_TIMEOUT", "2m"),
+	}
+	return settings, env.err()
+}
+
+// selfTestResult is the outcome of the checks of one set of credentials.
+type selfTestResult struct {
+	domains int
+	err     error
+}
+
+// selfTest checks that the credentials of every Issuer and ClusterIssuer
+// using this solver work, so a broken token or organization id shows up on
+// startup rather than at the next renewal. Only read-only calls are made: the
+// domains of the organization are listed, which needs a valid token and
+// access to the organization, and the DNS records of the first domain, which
+// needs the directory:manage_dns scope. The results are recorded as events on
+// the issuers and exported as yandex360_solver_self_test_success. Failures are
+// only reported, the webhook serves regardless.
+func (y *yandex360DNSSolver) selfTest(ctx context.Context) {
+	logger := y.namedLogger("self-test")
+	ctx = klog.NewContext(ctx, logger)
+
+	issuers, err := y.discoverSolverIssuers(ctx)
+	if err != nil {
+		logger.Error(err, "Failed to discover the issuers")
+		return
+	}
+
+	// issuers sharing credentials are checked once
+	results := map[string]selfTestResult{}
+	for _, issuer := range issuers {
+		cfg := issuer.config
+		key := fmt.Sprintf("%s|%d|%s/%s", cfg.Endpoint, cfg.OrganizationId, issuer.namespace, cfg.APITokenSecretRef.Name)
+		result, ok := results[key]
+		if !ok {
+			result = y.selfTestIssuer(klog.NewContext(ctx, klog.LoggerWithValues(logger, "issuer", issuer.String())), issuer)
+			results[key] = result
+		}
+
+		if result.err != nil {
+			solverSelfTestSuccess.WithLabelValues(issuer.String()).Set(0)
+			logger.Error(result.err, "Self-test failed", "issuer", issuer.String(), "organizationId", cfg.OrganizationId)
+			y.recordIssuerEvent(issuer, corev1.EventTypeWarning, reasonSelfTestFailed, "Yandex 360 credentials check failed: %v", result.err)
+			continue
+		}
+		solverSelfTestSuccess.WithLabelValues(issuer.String()).Set(1)
+		logger.Info("Self-test passed", "issuer", issuer.String(), "organizationId", cfg.OrganizationId, "domains", result.domains)
+		y.recordIssuerEvent(issuer, corev1.EventTypeNormal, reasonSelfTestPassed, "Yandex 360 credentials can manage the DNS of organization %d, %d domains", cfg.OrganizationId, result.domains)
+	}
+}
+
+// selfTestIssuer checks the credentials of issuer.
+func (y *yandex360DNSSolver) selfTestIssuer(ctx context.Context, issuer solverIssuer) selfTestResult {
+	cfg := issuer.config
+	apiSettings, err := y.getApiSettings(ctx, cfg, issuer.namespace, "")
+	if err != nil {
+		return selfTestResult{err: err}
+	}
+
+	domains, err := y.apiClient.GetDomains(ctx, apiSettings)
+	if err != nil {
+		return selfTestResult{err: selfTestHint(err, fmt.Sprintf("check the token and organization id %d", cfg.OrganizationId))}
+	}
+	if len(domains) == 0 {
+		return selfTestResult{err: fmt.Errorf("organization %d has no domains", cfg.OrganizationId)}
+	}
+
+	apiSettings.Domain = domains[0].Name
+	if _, err := y.apiClient.GetDnsRecords(ctx, apiSettings); err != nil {
+		return selfTestResult{err: selfTestHint(err, fmt.Sprintf("check that the token has the directory:manage_dns scope for %s", apiSettings.Domain))}
+	}
+	return selfTestResult{domains: len(domains)}
+}
+
+// selfTestHint adds hint to authentication and authorization errors.
+func selfTestHint(err error, hint string) error {
+	var apiErr *yandex360api.ApiError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	return err
+}
+
+// recordIssuerEvent records an event against the Issuer or ClusterIssuer.
+func (y *yandex360DNSSolver) recordIssuerEvent(issuer solverIssuer, eventtype, reason, messageFmt string, args ...interface{}) {
+	if y.recorder == nil {
+		return
+	}
+	y.recorder.Eventf(issuer.issuer, eventtype, reason, messageFmt, args...)
+}
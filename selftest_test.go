@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+)
+
+func TestSolver_SelfTest(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	recorder := record.NewFakeRecorder(10)
+	solver.recorder = recorder
+
+	// a ClusterIssuer with a revoked token
+	_, err := solver.k8sClient.CoreV1().Secrets(ClusterResourceNamespace).Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "revoked", Namespace: ClusterResourceNamespace},
+		Data:       map[string][]byte{"token": []byte("revoked")},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = solver.cmClient.CertmanagerV1().ClusterIssuers().Create(context.TODO(), &cmapi.ClusterIssuer{
+		ObjectMeta: metav1.ObjectMeta{Name: "revoked"},
+		Spec: cmapi.IssuerSpec{IssuerConfig: cmapi.IssuerConfig{ACME: &cmacme.ACMEIssuer{
+			Solvers: []cmacme.ACMEChallengeSolver{{
+				DNS01: &cmacme.ACMEChallengeSolverDNS01{Webhook: &cmacme.ACMEIssuerDNS01ProviderWebhook{
+					GroupName:  GroupName,
+					SolverName: "yandex360-dns-solver",
+					Config:     &extapi.JSON{Raw: []byte(`{"endpoint":"` + apiUrl.String() + `","organizationId":1001,"apiTokenSecretRef":{"name":"revoked","key":"token"}}`)},
+				}},
+			}},
+		}}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	solver.selfTest(context.TODO())
+
+	event := <-recorder.Events
+	require.Contains(t, event, "Warning SelfTestFailed Yandex 360 credentials check failed: failed to GetDomains: response failed with status code: 401")
+	require.Contains(t, event, "check the token and organization id 1001")
+	require.Contains(t, <-recorder.Events, "Normal SelfTestPassed Yandex 360 credentials can manage the DNS of organization 1001, 2 domains")
+	require.Equal(t, 0.0, testutil.ToFloat64(solverSelfTestSuccess.WithLabelValues("ClusterIssuer/revoked")))
+	require.Equal(t, 1.0, testutil.ToFloat64(solverSelfTestSuccess.WithLabelValues("Issuer/default/yandex360")))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// errShuttingDown is returned by the operations started after the stop signal.
+var errShuttingDown = errors.New("webhook is shutting down, not accepting new challenges")
+
+// cancelTimeout bounds the wait for the operations cancelled at the end of
+// the grace period to return.
+const cancelTimeout = 5 * time.Second
+
+// operationTracker tracks the in-flight Present and CleanUp calls, so they can
+// finish their API calls when the webhook is stopped.
+type operationTracker struct {
+	// gracePeriod is how long drain waits before cancelling the operations
+	gracePeriod time.Duration
+
+	// ctx is cancelled once the grace period is over
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	stopping bool
+	inFlight sync.WaitGroup
+
+	once    sync.Once
+	drained chan struct{}
+}
+
+// newOperationTracker returns a tracker whose grace period is read from the
+// environment:
+//
+//	SHUTDOWN_GRACE_PERIOD  how long running operations may take after the stop signal, default "25s"
+func newOperationTracker() (*operationTracker, error) {
+	var env envErrors
+	gracePeriod := env.duration("SHUTDOWN_GRACE_PERIOD", "25s")
+	return newOperationTrackerWithGracePeriod(gracePeriod), env.err()
+}
+
+func newOperationTrackerWithGracePeriod(gracePeriod time.Duration) *operationTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &operationTracker{gracePeriod: gracePeriod, ctx: ctx, cancel: cancel, drained: make(chan struct{})}
+}
+
+// begin registers an operation and returns its context, which is cancelled
+// if the operation is still running at the end of the grace period. done must
+// be called once the operation returns. After the stop signal begin fails with
+// errShuttingDown.
+func (t *operationTracker) begin(ctx context.Context) (context.Context, func(), error) {
+	t.mu.Lock()
+	if t.stopping {
+		t.mu.Unlock()
+		return ctx, func() {}, errShuttingDown
+	}
+	t.inFlight.Add(1)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(t.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		t.inFlight.Done()
+	}, nil
+}
+
+// isStopping returns whether the stop signal was received.
+func (t *operationTracker) isStopping() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopping
+}
+
+// drain refuses new operations and waits for the running ones, cancelling
+// them after the grace period. It returns once they returned, concurrent calls
+// wait for the first one.
+func (t *operationTracker) drain() {
+	t.once.Do(func() {
+		defer close(t.drained)
+		defer t.cancel()
+		logger := klog.LoggerWithName(klog.Background(), "shutdown")
+
+		t.mu.Lock()
+		t.stopping = true
+		t.mu.Unlock()
+
+		finished := make(chan struct{})
+		go func() {
+			t.inFlight.Wait()
+			close(finished)
+		}()
+
+		logger.Info("Waiting for the running operations", "gracePeriod", t.gracePeriod)
+		select {
+		case <-finished:
+			logger.Info("All operations finished")
+			return
+		case <-time.After(t.gracePeriod):
+		}
+
+		logger.Info("Grace period is over, cancelling the running operations")
+		t.cancel()
+		select {
+		case <-finished:
+		case <-time.After(cancelTimeout):
+			logger.Info("Operations did not return after being cancelled")
+		}
+	})
+	<-t.drained
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestOperationTracker_Drain(t *testing.T) {
+	tracker := newOperationTrackerWithGracePeriod(time.Minute)
+	ctx, done, err := tracker.begin(context.TODO())
+	require.NoError(t, err)
+
+	drained := make(chan struct{})
+	go func() {
+		tracker.drain()
+		close(drained)
+	}()
+	require.Eventually(t, tracker.isStopping, time.Second, time.Millisecond)
+
+	_, _, err = tracker.begin(context.TODO())
+	require.ErrorIs(t, err, errShuttingDown)
+	select {
+	case <-drained:
+		t.Fatal("drained while an operation is running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	require.NoError(t, ctx.Err(), "running operations are not cancelled within the grace period")
+	done()
+	<-drained
+	tracker.drain()
+}
+
+func TestOperationTracker_CancelsAfterGracePeriod(t *testing.T) {
+	tracker := newOperationTrackerWithGracePeriod(20 * time.Millisecond)
+	ctx, done, err := tracker.begin(context.TODO())
+	require.NoError(t, err)
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+
+	start := time.Now()
+	tracker.drain()
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	require.Less(t, time.Since(start), cancelTimeout)
+}
+
+func TestSolver_CleanUpDuringShutdown(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	cleanups, err := newCleanupQueue(solver.k8sClient)
+	require.NoError(t, err)
+	solver.cleanups = cleanups
+	settings := &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"}
+	present := func(fqdn string) {
+		require.NoError(t, solver.Present(challengeRequest(apiUrl, fqdn, "key")))
+	}
+	present("_acme-challenge.www.example1.com.")
+	present("_acme-challenge.example1.com.")
+
+	// the first cleanup hangs on the lock of the domain past the grace period
+	solver.operations = newOperationTrackerWithGracePeriod(20 * time.Millisecond)
+	_, unlock, err := solver.lockDomain(context.TODO(), settings)
+	require.NoError(t, err)
+	cleanedUp := make(chan error)
+	go func() {
+		cleanedUp <- solver.CleanUp(challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "key"))
+	}()
+	require.Eventually(t, func() bool { return len(solver.health.status().InFlight) == 1 }, time.Second, time.Millisecond)
+	solver.operations.drain()
+	require.ErrorIs(t, <-cleanedUp, context.Canceled)
+	unlock()
+
+	// the second is refused
+	require.ErrorIs(t, solver.CleanUp(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")), errShuttingDown)
+
+	entries, err := solver.cleanups.List(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, challengeRecords(records, "_acme-challenge.www", "key"), 1)
+
+	// and both are done after the restart
+	solver.operations = newOperationTrackerWithGracePeriod(time.Minute)
+	require.NoError(t, solver.retryQueuedCleanups(context.TODO()))
+	entries, err = solver.cleanups.List(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	solver.apiClient.InvalidateCache(settings)
+	records, err = solver.apiClient.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Empty(t, challengeRecords(records, "_acme-challenge.www", "key"))
+	require.Empty(t, challengeRecords(records, "_acme-challenge", "key"))
+}
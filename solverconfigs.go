@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// solverConfigGroup is the API group of the Yandex360SolverConfig CRDs shipped
+// with the chart.
+const solverConfigGroup = "yandex360.alexfirs.ru"
+
+const (
+	solverConfigKind        = "Yandex360SolverConfig"
+	clusterSolverConfigKind = "ClusterYandex360SolverConfig"
+)
+
+var (
+	solverConfigsResource        = schema.GroupVersionResource{Group: solverConfigGroup, Version: "v1alpha1", Resource: "yandex360solverconfigs"}
+	clusterSolverConfigsResource = schema.GroupVersionResource{Group: solverConfigGroup, Version: "v1alpha1", Resource: "clusteryandex360solverconfigs"}
+)
+
+// solverConfigSyncTimeout bounds the wait for the first listing of the
+// configs, which never completes if the CRDs are not installed.
+const solverConfigSyncTimeout = 30 * time.Second
+
+// solverConfigRef references a reusable solver config from the config of an
+// issuer.
+type solverConfigRef struct {
+	// Kind is Yandex360SolverConfig (the default) or
+	// ClusterYandex360SolverConfig
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// solverConfigs looks up the Yandex360SolverConfig and
+// ClusterYandex360SolverConfig resources. The informers are only started on
+// the first lookup, so clusters not using the CRDs never list them.
+type solverConfigs struct {
+	client dynamic.Interface
+	stopCh <-chan struct{}
+
+	once       sync.Once
+	namespaced informers.GenericInformer
+	cluster    informers.GenericInformer
+}
+
+func newSolverConfigs(client dynamic.Interface, stopCh <-chan struct{}) *solverConfigs {
+	return &solverConfigs{client: client, stopCh: stopCh}
+}
+
+func (s *solverConfigs) start() {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(s.client, 0)
+	s.namespaced = factory.ForResource(solverConfigsResource)
+	s.cluster = factory.ForResource(clusterSolverConfigsResource)
+	factory.Start(s.stopCh)
+}
+
+// get returns the spec of the referenced config, a namespaced config is looked
+// up in namespace.
+func (s *solverConfigs) get(ctx context.Context, ref solverConfigRef, namespace string) (yandex360DNSProviderConfig, error) {
+	s.once.Do(s.start)
+
+	var informer informers.GenericInformer
+	var lister cache.GenericNamespaceLister
+	switch ref.Kind {
+	case "", solverConfigKind:
+		informer = s.namespaced
+		lister = s.namespaced.Lister().ByNamespace(namespace)
+	case clusterSolverConfigKind:
+		informer = s.cluster
+		lister = s.cluster.Lister()
+	default:
+		return yandex360DNSProviderConfig{}, fmt.Errorf("unknown configRef kind %q, expected %s or %s", ref.Kind, solverConfigKind, clusterSolverConfigKind)
+	}
+	if ref.Name == "" {
+		return yandex360DNSProviderConfig{}, fmt.Errorf("configRef has no name")
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, solverConfigSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), informer.Informer().HasSynced) {
+		return yandex360DNSProviderConfig{}, fmt.Errorf("%ss not synced, are the CRDs installed?", kindOrDefault(ref.Kind))
+	}
+
+	obj, err := lister.Get(ref.Name)
+	if err != nil {
+		if ref.Kind == clusterSolverConfigKind {
+			return yandex360DNSProviderConfig{}, fmt.Errorf("%s %s: %w", clusterSolverConfigKind, ref.Name, err)
+		}
+		return yandex360DNSProviderConfig{}, fmt.Errorf("%s %s/%s: %w", solverConfigKind, namespace, ref.Name, err)
+	}
+
+	cfg := yandex360DNSProviderConfig{}
+	spec, _, err := unstructured.NestedMap(obj.(*unstructured.Unstructured).Object, "spec")
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s %s: %w", kindOrDefault(ref.Kind), ref.Name, err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid %s %s: %w", kindOrDefault(ref.Kind), ref.Name, err)
+	}
+	if cfg.ConfigRef != nil {
+		return cfg, fmt.Errorf("invalid %s %s: configs may not reference other configs", kindOrDefault(ref.Kind), ref.Name)
+	}
+	return cfg, nil
+}
+
+func kindOrDefault(kind string) string {
+	if kind == "" {
+		return solverConfigKind
+	}
+	return kind
+}
+
+// resolveConfig merges the config referenced by cfg.ConfigRef into cfg, the
+// inline fields of cfg take precedence. Namespaced configs are looked up in
+// namespace, the namespace the secrets of the issuer are resolved in.
+func (y *yandex360DNSSolver) resolveConfig(ctx context.Context, cfg yandex360DNSProviderConfig, namespace string) (yandex360DNSProviderConfig, error) {
+	if cfg.ConfigRef == nil {
+		return cfg, nil
+	}
+	if y.solverConfigs == nil {
+		return cfg, fmt.Errorf("configRef %s is not supported without a Kubernetes client", cfg.ConfigRef.Name)
+	}
+
+	base, err := y.solverConfigs.get(ctx, *cfg.ConfigRef, namespace)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Endpoint != "" {
+		base.Endpoint = cfg.Endpoint
+	}
+	if cfg.OrganizationId != 0 {
+		base.OrganizationId = cfg.OrganizationId
+	}
+	if cfg.APITokenSecretRef.Name != "" {
+		base.APITokenSecretRef.Name = cfg.APITokenSecretRef.Name
+	}
+	if cfg.APITokenSecretRef.Key != "" {
+		base.APITokenSecretRef.Key = cfg.APITokenSecretRef.Key
+	}
+	if cfg.TTL != 0 {
+		base.TTL = cfg.TTL
+	}
+	if cfg.OAuthTokenURL != "" {
+		base.OAuthTokenURL = cfg.OAuthTokenURL
+	}
+	return base, nil
+}
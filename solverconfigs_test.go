@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	cmacme "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func solverConfigObject(kind string, namespace string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(solverConfigGroup + "/v1alpha1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// withSolverConfigs serves the configs to the solver returned by newTestSolver.
+func withSolverConfigs(t *testing.T, solver *yandex360DNSSolver, objects ...runtime.Object) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		solverConfigsResource:        solverConfigKind + "List",
+		clusterSolverConfigsResource: clusterSolverConfigKind + "List",
+	}, objects...)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	solver.solverConfigs = newSolverConfigs(client, stopCh)
+}
+
+func TestSolver_ResolveConfig(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	withSolverConfigs(t, solver,
+		solverConfigObject(solverConfigKind, "default", "yandex360", map[string]interface{}{
+			"endpoint":          apiUrl.String(),
+			"organizationId":    int64(1001),
+			"apiTokenSecretRef": map[string]interface{}{"name": "yandex360-credentials", "key": "token"},
+			"ttl":               int64(120),
+		}),
+		solverConfigObject(clusterSolverConfigKind, "", "shared", map[string]interface{}{
+			"endpoint":       "https://api360.yandex.net",
+			"organizationId": int64(1002),
+		}),
+	)
+
+	cfg, err := solver.resolveConfig(context.TODO(), yandex360DNSProviderConfig{TTL: 60, ConfigRef: &solverConfigRef{Name: "yandex360"}}, "default")
+	require.NoError(t, err)
+	require.Equal(t, apiUrl.String(), cfg.Endpoint)
+	require.Equal(t, 1001, cfg.OrganizationId)
+	require.Equal(t, "yandex360-credentials", cfg.APITokenSecretRef.Name)
+	require.Equal(t, "token", cfg.APITokenSecretRef.Key)
+	require.Equal(t, 60, cfg.TTL, "inline fields override the config")
+	require.Nil(t, cfg.ConfigRef)
+
+	cfg, err = solver.resolveConfig(context.TODO(), yandex360DNSProviderConfig{ConfigRef: &solverConfigRef{Kind: clusterSolverConfigKind, Name: "shared"}}, "default")
+	require.NoError(t, err)
+	require.Equal(t, "https://api360.yandex.net", cfg.Endpoint)
+	require.Equal(t, 1002, cfg.OrganizationId)
+
+	_, err = solver.resolveConfig(context.TODO(), yandex360DNSProviderConfig{ConfigRef: &solverConfigRef{Name: "yandex360"}}, "other")
+	require.ErrorContains(t, err, `Yandex360SolverConfig other/yandex360: yandex360solverconfigs.yandex360.alexfirs.ru "yandex360" not found`)
+	_, err = solver.resolveConfig(context.TODO(), yandex360DNSProviderConfig{ConfigRef: &solverConfigRef{Kind: "Secret", Name: "yandex360"}}, "default")
+	require.ErrorContains(t, err, `unknown configRef kind "Secret"`)
+}
+
+func TestSolver_PresentWithConfigRef(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	withSolverConfigs(t, solver, solverConfigObject(solverConfigKind, "default", "yandex360", map[string]interface{}{
+		"endpoint":          apiUrl.String(),
+		"organizationId":    int64(1001),
+		"apiTokenSecretRef": map[string]interface{}{"name": "yandex360-credentials", "key": "token"},
+	}))
+
+	ch := challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")
+	ch.Config = &extapi.JSON{Raw: []byte(`{"configRef":{"name":"yandex360"},"ttl":60}`)}
+	require.NoError(t, solver.Present(ch))
+
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"})
+	require.NoError(t, err)
+	created := challengeRecords(records, "_acme-challenge", "key")
+	require.Len(t, created, 1)
+	require.Equal(t, 60, created[0].TTL)
+	require.NoError(t, solver.CleanUp(ch))
+
+	ch.Config = &extapi.JSON{Raw: []byte(`{"configRef":{"name":"missing"}}`)}
+	require.ErrorContains(t, solver.Present(ch), "Yandex360SolverConfig default/missing")
+}
+
+func TestDiscoverSolverIssuers_ResolvesConfigRef(t *testing.T) {
+	solver, _ := newTestSolver(t)
+	withSolverConfigs(t, solver, solverConfigObject(clusterSolverConfigKind, "", "shared", map[string]interface{}{
+		"endpoint":       "https://api360.yandex.net",
+		"organizationId": int64(1002),
+	}))
+	for name, config := range map[string]string{
+		"shared":  `{"configRef":{"kind":"ClusterYandex360SolverConfig","name":"shared"},"organizationId":1003}`,
+		"missing": `{"configRef":{"name":"missing"}}`,
+	} {
+		_, err := solver.cmClient.CertmanagerV1().ClusterIssuers().Create(context.TODO(), &cmapi.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: cmapi.IssuerSpec{IssuerConfig: cmapi.IssuerConfig{ACME: &cmacme.ACMEIssuer{
+				Solvers: []cmacme.ACMEChallengeSolver{{
+					DNS01: &cmacme.ACMEChallengeSolverDNS01{Webhook: &cmacme.ACMEIssuerDNS01ProviderWebhook{
+						GroupName:  GroupName,
+						SolverName: "yandex360-dns-solver",
+						Config:     &extapi.JSON{Raw: []byte(config)},
+					}},
+				}},
+			}}},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	issuers, err := solver.discoverSolverIssuers(context.TODO())
+	require.NoError(t, err)
+	configs := map[string]yandex360DNSProviderConfig{}
+	for _, issuer := range issuers {
+		configs[issuer.String()] = issuer.config
+	}
+	require.Len(t, configs, 2, "the issuer referencing a missing config is skipped")
+	require.Equal(t, "https://api360.yandex.net", configs["ClusterIssuer/shared"].Endpoint)
+	require.Equal(t, 1003, configs["ClusterIssuer/shared"].OrganizationId)
+	require.Equal(t, 1001, configs["Issuer/default/yandex360"].OrganizationId)
+}
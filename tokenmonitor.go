@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// Reasons of the events recorded against token Secrets.
+const (
+	reasonTokenExpiring = "TokenExpiring"
+	reasonTokenExpired  = "TokenExpired"
+)
+
+// tokenMonitorSettings configures the token expiry monitor.
+type tokenMonitorSettings struct {
+	// interval between two checks, zero disables the monitor
+	interval time.Duration
+	// warnBefore is how long before their expiry tokens are warned about
+	warnBefore time.Duration
+	// infoURL is the token-info endpoint asked about tokens without expiry
+	// metadata, nil to only use the metadata
+	infoURL *url.URL
+}
+
+// tokenMonitorSettingsFromEnv reads the token monitor settings from the
+// environment:
+//
+//	TOKEN_CHECK_INTERVAL  interval between checks, default "6h"; "0" disables the monitor
+//	TOKEN_EXPIRY_WARNING  warn about tokens expiring within, default "720h"
+//	TOKEN_INFO_URL        token-info endpoint, unset only reads the expiry of the Secrets
+func tokenMonitorSettingsFromEnv() (tokenMonitorSettings, error) {
+	var env envErrors
+	settings := tokenMonitorSettings{
+		interval:   env.duration("TOKEN_CHECK_INTERVAL", "6h"),
+		warnBefore: env.duration("TOKEN_EXPIRY_WARNING", "720h"),
+	}
+	if value := getEnv("TOKEN_INFO_URL", ""); value != "" {
+		u, err := url.Parse(value)
+		if err != nil {
+			env = append(env, fmt.Errorf("TOKEN_INFO_URL must be a URL: %w", err))
+		}
+		settings.infoURL = u
+	}
+	return settings, env.err()
+}
+
+// tokenMonitor periodically checks when the tokens of the Secrets referenced
+// by the issuers of this solver expire. The expiry is read from the expiry key
+// of the Secret, written by the OAuth refresh, or asked from the token-info
+// endpoint. It is exported as yandex360_solver_token_expiry_days, and tokens
+// expiring within warnBefore are warned about with events on their Secret.
+type tokenMonitor struct {
+	tokenMonitorSettings
+	solver *yandex360DNSSolver
+	now    func() time.Time
+
+	// secrets are the metric labels set by the last check
+	secrets map[[2]string]bool
+}
+
+func newTokenMonitor(solver *yandex360DNSSolver, settings tokenMonitorSettings) *tokenMonitor {
+	return &tokenMonitor{
+		tokenMonitorSettings: settings,
+		solver:               solver,
+		now:                  time.Now,
+		secrets:              map[[2]string]bool{},
+	}
+}
+
+// Run checks the tokens every interval until stopCh is closed.
+func (m *tokenMonitor) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	logger := m.solver.namedLogger("token-monitor")
+	ctx = klog.NewContext(ctx, logger)
+	logger.Info("Starting token monitor", "interval", m.interval, "warnBefore", m.warnBefore, "infoURL", m.infoURL)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.check(ctx); err != nil {
+			klog.FromContext(ctx).Error(err, "Token check failed")
+		}
+	}, m.interval)
+}
+
+// check updates the expiry of the token of every Secret referenced by an
+// issuer of this solver.
+func (m *tokenMonitor) check(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "token.check")
+	defer func() { endSpan(span, err) }()
+
+	issuers, err := m.solver.discoverSolverIssuers(ctx)
+	if err != nil {
+		return err
+	}
+
+	visited := map[[2]string]bool{}
+	checked := map[[2]string]bool{}
+	for _, issuer := range issuers {
+		ref := issuer.config.APITokenSecretRef
+		if ref.Name == "" {
+			continue
+		}
+		labels := [2]string{issuer.namespace, ref.Name}
+		if visited[labels] {
+			continue
+		}
+		visited[labels] = true
+
+		logger := klog.LoggerWithValues(klog.FromContext(ctx), "issuer", issuer.String(), "secret", issuer.namespace+"/"+ref.Name)
+		ctx := klog.NewContext(ctx, logger)
+
+		secret, expiry, err := m.expiry(ctx, issuer)
+		if err != nil {
+			logger.Error(err, "Failed to check the token expiry")
+			continue
+		}
+		if expiry.IsZero() {
+			logger.V(2).Info("Token expiry unknown")
+			continue
+		}
+		checked[labels] = true
+
+		remaining := expiry.Sub(m.now())
+		solverTokenExpiryDays.WithLabelValues(labels[0], labels[1]).Set(remaining.Hours() / 24)
+		logger.V(2).Info("Checked token expiry", "expiry", expiry)
+
+		switch {
+		case remaining <= 0:
+			logger.Info("Token expired", "expiry", expiry)
+			m.recordEvent(secret, reasonTokenExpired, "Yandex 360 API token expired on %s", expiry.UTC().Format(time.RFC3339))
+		case remaining < m.warnBefore:
+			days := int(math.Ceil(remaining.Hours() / 24))
+			logger.Info("Token expires soon", "expiry", expiry, "days", days)
+			m.recordEvent(secret, reasonTokenExpiring, "Yandex 360 API token expires in %d days, on %s", days, expiry.UTC().Format(time.RFC3339))
+		}
+	}
+
+	// forget the Secrets no longer referenced or of unknown expiry
+	for labels := range m.secrets {
+		if !checked[labels] {
+			solverTokenExpiryDays.DeleteLabelValues(labels[0], labels[1])
+		}
+	}
+	m.secrets = checked
+	return nil
+}
+
+// expiry returns the token Secret of issuer and the expiry of its token, zero
+// if it is unknown.
+func (m *tokenMonitor) expiry(ctx context.Context, issuer solverIssuer) (*corev1.Secret, time.Time, error) {
+	cfg := issuer.config
+	secret, err := m.solver.tokens.secret(ctx, issuer.namespace, cfg.APITokenSecretRef.Name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if value, ok := secret.Data[secretKeyExpiry]; ok {
+		expiry, err := time.Parse(time.RFC3339, string(value))
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid %s of secret %s/%s: %w", secretKeyExpiry, secret.Namespace, secret.Name, err)
+		}
+		return secret, expiry, nil
+	}
+
+	if m.infoURL == nil {
+		return secret, time.Time{}, nil
+	}
+	token, err := m.solver.tokens.Source(issuer.namespace, cfg.APITokenSecretRef, cfg.OAuthTokenURL).Token(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := m.solver.apiClient.GetTokenInfo(ctx, m.infoURL, token)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return secret, info.Expiry, nil
+}
+
+func (m *tokenMonitor) recordEvent(secret *corev1.Secret, reason, messageFmt string, args ...interface{}) {
+	if m.solver.recorder == nil {
+		return
+	}
+	m.solver.recorder.Eventf(secret, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTokenMonitor_Check(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	recorder := record.NewFakeRecorder(10)
+	solver.recorder = recorder
+	now := time.Now()
+	expiryDays := func() float64 {
+		return testutil.ToFloat64(solverTokenExpiryDays.WithLabelValues("default", "yandex360-credentials"))
+	}
+
+	// the expiry of a static token is unknown without a token-info endpoint
+	monitor := newTokenMonitor(solver, tokenMonitorSettings{interval: time.Hour, warnBefore: 30 * 24 * time.Hour})
+	monitor.now = func() time.Time { return now }
+	require.NoError(t, monitor.check(context.TODO()))
+	require.Empty(t, monitor.secrets)
+	require.Empty(t, recorder.Events)
+
+	// the mock token is valid for a year
+	monitor.infoURL = apiUrl.JoinPath("tokeninfo")
+	require.NoError(t, monitor.check(context.TODO()))
+	require.InDelta(t, 365, expiryDays(), 0.1)
+	require.Empty(t, recorder.Events)
+
+	// the expiry written by the OAuth refresh wins
+	secrets := solver.k8sClient.CoreV1().Secrets("default")
+	secret, err := secrets.Get(context.TODO(), "yandex360-credentials", metav1.GetOptions{})
+	require.NoError(t, err)
+	secret.Data["expiry"] = []byte(now.Add(10 * 24 * time.Hour).UTC().Format(time.RFC3339))
+	_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, monitor.check(context.TODO()))
+	require.InDelta(t, 10, expiryDays(), 0.1)
+	require.Contains(t, <-recorder.Events, "Warning TokenExpiring Yandex 360 API token expires in 10 days")
+
+	secret.Data["expiry"] = []byte(now.Add(-time.Hour).UTC().Format(time.RFC3339))
+	_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, monitor.check(context.TODO()))
+	require.Less(t, expiryDays(), 0.0)
+	require.Contains(t, <-recorder.Events, "Warning TokenExpired Yandex 360 API token expired on")
+
+	// unreferenced secrets are forgotten
+	require.NoError(t, solver.cmClient.CertmanagerV1().Issuers("default").Delete(context.TODO(), "yandex360", metav1.DeleteOptions{}))
+	require.NoError(t, monitor.check(context.TODO()))
+	require.Empty(t, monitor.secrets)
+	require.Zero(t, testutil.CollectAndCount(solverTokenExpiryDays))
+}
+
+func TestTokenMonitorSettingsFromEnv_Invalid(t *testing.T) {
+	t.Setenv("TOKEN_CHECK_INTERVAL", "daily")
+	t.Setenv("TOKEN_INFO_URL", "https://oauth.example/%zz")
+
+	_, err := tokenMonitorSettingsFromEnv()
+	require.ErrorContains(t, err, "TOKEN_CHECK_INTERVAL must be a duration")
+	require.ErrorContains(t, err, "TOKEN_INFO_URL must be a URL")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	certmgrapiv1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// Keys of a Secret holding an OAuth refresh token instead of a static token.
+// The access token is stored under the key of the apiTokenSecretRef.
+const (
+	secretKeyClientID     = "client_id"
+	secretKeyClientSecret = "client_secret"
+	secretKeyRefreshToken = "refresh_token"
+	// secretKeyExpiry is the RFC 3339 expiry of the access token
+	secretKeyExpiry = "expiry"
+)
+
+// secretTokens hands out the TokenSources of the tokens stored in Secrets.
+// Every referenced Secret is watched once it is first used, so its token is
+// read from memory and a rotated token is used as soon as the Secret changes.
+// Until the watch has synced, e.g. because the webhook may only get the
+// Secret, it is read with a get on every request.
+//
+// A Secret with a refresh_token is exchanged for a new access token when its
+// access token is about to expire, and the new tokens are written back.
+type secretTokens struct {
+	client kubernetes.Interface
+	// stopCh stops the watches, nil disables them
+	stopCh <-chan struct{}
+	// apiClient refreshes the OAuth tokens
+	apiClient *yandex360api.ApiClient
+	oauth     oauthSettings
+
+	mu      sync.Mutex
+	watches map[string]cache.SharedInformer
+
+	// refreshMu serializes the refreshes, a refresh token may only be used
+	// once; refreshed holds the tokens until the watches see them
+	refreshMu sync.Mutex
+	refreshed map[string]yandex360api.OAuthToken
+}
+
+// oauthSettings configures the refresh of OAuth tokens.
+type oauthSettings struct {
+	// tokenURL is the default token endpoint of the issuers
+	tokenURL string
+	// refreshBefore is how long before its expiry an access token is renewed
+	refreshBefore time.Duration
+}
+
+// oauthSettingsFromEnv reads the OAuth settings from the environment:
+//
+//	OAUTH_TOKEN_URL       default token endpoint, default "https://oauth.yandex.ru/token"
+//	OAUTH_REFRESH_BEFORE  renew access tokens expiring within, default "720h"
+func oauthSettingsFromEnv() (oauthSettings, error) {
+	var env envErrors
+	settings := oauthSettings{
+		tokenURL:      getEnv("OAUTH_TOKEN_URL", yandex360api.DefaultOAuthTokenURL),
+		refreshBefore: env.duration("OAUTH_REFRESH_BEFORE", "720h"),
+	}
+	return settings, env.err()
+}
+
+func newSecretTokens(client kubernetes.Interface, stopCh <-chan struct{}, apiClient *yandex360api.ApiClient, oauth oauthSettings) *secretTokens {
+	return &secretTokens{
+		client:    client,
+		stopCh:    stopCh,
+		apiClient: apiClient,
+		oauth:     oauth,
+		watches:   map[string]cache.SharedInformer{},
+		refreshed: map[string]yandex360api.OAuthToken{},
+	}
+}
+
+// Source returns the TokenSource of the key of the Secret ref in namespace.
+// tokenURL overrides the default OAuth token endpoint if set.
+func (t *secretTokens) Source(namespace string, ref certmgrapiv1.SecretKeySelector, tokenURL string) yandex360api.TokenSource {
+	return yandex360api.TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return t.token(ctx, namespace, ref, tokenURL)
+	})
+}
+
+func (t *secretTokens) token(ctx context.Context, namespace string, ref certmgrapiv1.SecretKeySelector, tokenURL string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "solver.secret", trace.WithAttributes(
+		attribute.String(attrNamespace, namespace),
+		attribute.String("k8s.secret.name", ref.Name),
+	))
+	defer func() { endSpan(span, err) }()
+
+	secret, err := t.secret(ctx, namespace, ref.Name)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := secret.Data[secretKeyRefreshToken]; ok {
+		return t.oauthToken(ctx, secret, ref.Key, tokenURL)
+	}
+
+	bytes, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key not found %q in secret '%s/%s'", ref.Key, namespace, ref.Name)
+	}
+	return strings.TrimSuffix(string(bytes), "\n"), nil
+}
+
+// secret returns the Secret namespace/name from its watch, or gets it if the
+// watch has not synced yet.
+func (t *secretTokens) secret(ctx context.Context, namespace string, name string) (*corev1.Secret, error) {
+	if informer := t.watch(namespace, name); informer != nil && informer.HasSynced() {
+		obj, exists, err := informer.GetStore().GetByKey(namespace + "/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("failed to get secret %s/%s: not found", namespace, name)
+		}
+		return obj.(*corev1.Secret), nil
+	}
+
+	secret, err := t.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	return secret, nil
+}
+
+// watch returns the informer of the Secret namespace/name, starting it on
+// first use.
+func (t *secretTokens) watch(namespace string, name string) cache.SharedInformer {
+	if t.stopCh == nil {
+		return nil
+	}
+
+	key := namespace + "/" + name
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if informer, ok := t.watches[key]; ok {
+		return informer
+	}
+
+	selector := fields.OneTermEqualSelector(metav1.ObjectNameField, name).String()
+	secrets := t.client.CoreV1().Secrets(namespace)
+	informer := cache.NewSharedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return secrets.List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return secrets.Watch(context.Background(), options)
+		},
+	}, &corev1.Secret{}, 0)
+	go informer.Run(t.stopCh)
+
+	t.watches[key] = informer
+	return informer
+}
+
+// oauthToken returns the access token of the OAuth layout secret, refreshing
+// it first if it expires soon.
+func (t *secretTokens) oauthToken(ctx context.Context, secret *corev1.Secret, key string, tokenURL string) (string, error) {
+	t.refreshMu.Lock()
+	defer t.refreshMu.Unlock()
+
+	name := secret.Namespace + "/" + secret.Name
+	token := yandex360api.OAuthToken{
+		AccessToken:  strings.TrimSpace(string(secret.Data[key])),
+		RefreshToken: strings.TrimSpace(string(secret.Data[secretKeyRefreshToken])),
+	}
+	if expiry, err := time.Parse(time.RFC3339, string(secret.Data[secretKeyExpiry])); err == nil {
+		token.Expiry = expiry
+	}
+	// the watch may not have seen the tokens written back yet
+	if refreshed, ok := t.refreshed[name]; ok && refreshed.Expiry.After(token.Expiry) {
+		token = refreshed
+	}
+	if !token.NeedsRefresh(time.Now(), t.oauth.refreshBefore) {
+		return token.AccessToken, nil
+	}
+
+	if tokenURL == "" {
+		tokenURL = t.oauth.tokenURL
+	}
+	u, err := url.Parse(tokenURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OAuth token URL: %w", err)
+	}
+	client := yandex360api.OAuthClient{
+		TokenURL:     u,
+		ClientID:     strings.TrimSpace(string(secret.Data[secretKeyClientID])),
+		ClientSecret: strings.TrimSpace(string(secret.Data[secretKeyClientSecret])),
+	}
+
+	logger := klog.FromContext(ctx).WithValues("secret", name)
+	refreshed, err := t.apiClient.RefreshOAuthToken(ctx, client, token.RefreshToken)
+	if err != nil {
+		solverTokenRefreshesTotal.WithLabelValues("error").Inc()
+		if token.AccessToken != "" && time.Now().Before(token.Expiry) {
+			// still valid, try again on the next request
+			logger.Error(err, "Failed to refresh the OAuth token, using the current one", "expiry", token.Expiry)
+			return token.AccessToken, nil
+		}
+		return "", fmt.Errorf("failed to refresh the OAuth token of secret %s: %w", name, err)
+	}
+	solverTokenRefreshesTotal.WithLabelValues("success").Inc()
+	t.refreshed[name] = *refreshed
+	logger.Info("Refreshed the OAuth token", "expiry", refreshed.Expiry)
+
+	if err := t.writeBack(ctx, secret.Namespace, secret.Name, key, refreshed); err != nil {
+		// the refresh token may have been rotated, it is kept in memory
+		logger.Error(err, "Failed to write the refreshed OAuth token back to the secret")
+	}
+	return refreshed.AccessToken, nil
+}
+
+// writeBack stores token in the Secret namespace/name, the access token under
+// key.
+func (t *secretTokens) writeBack(ctx context.Context, namespace string, name string, key string, token *yandex360api.OAuthToken) error {
+	secrets := t.client.CoreV1().Secrets(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		secret = secret.DeepCopy()
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[key] = []byte(token.AccessToken)
+		secret.Data[secretKeyRefreshToken] = []byte(token.RefreshToken)
+		if token.Expiry.IsZero() {
+			delete(secret.Data, secretKeyExpiry)
+		} else {
+			secret.Data[secretKeyExpiry] = []byte(token.Expiry.UTC().Format(time.RFC3339))
+		}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	certmgrapiv1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestSecretTokens_WatchesSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "yandex360-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("first\n")},
+	}
+	client := fake.NewSimpleClientset(secret)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	tokens := newSecretTokens(client, stopCh, nil, oauthSettings{})
+	source := tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "yandex360-credentials"}, Key: "token"}, "")
+
+	// served by a get until the watch has synced
+	token, err := source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "first", token)
+
+	informer := tokens.watch("default", "yandex360-credentials")
+	require.Eventually(t, informer.HasSynced, 5*time.Second, 10*time.Millisecond)
+	client.ClearActions()
+
+	secret.Data["token"] = []byte("rotated")
+	_, err = client.CoreV1().Secrets("default").Update(context.TODO(), secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		token, err := source.Token(context.TODO())
+		return err == nil && token == "rotated"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the token is read from the watch
+	for _, action := range client.Actions() {
+		require.NotEqual(t, "get", action.GetVerb())
+	}
+
+	// missing keys and secrets fail
+	_, err = tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "yandex360-credentials"}, Key: "other"}, "").Token(context.TODO())
+	require.ErrorContains(t, err, `key not found "other"`)
+	_, err = tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "missing"}, Key: "token"}, "").Token(context.TODO())
+	require.ErrorContains(t, err, "failed to get secret default/missing")
+}
+
+func TestSecretTokens_RefreshesOAuthToken(t *testing.T) {
+	server := httptest.NewServer(yandex360api.NewYandex360ApiMock(yandex360api.Yandex360ApiMock_TestData).Handler())
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "yandex360-credentials", Namespace: "default"},
+		Data: map[string][]byte{
+			"token":         []byte("expiring"),
+			"refresh_token": []byte("mockRefreshToken=\n"),
+			"client_id":     []byte("mockClientId"),
+			"client_secret": []byte("mockClientSecret"),
+			"expiry":        []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339)),
+		},
+	}
+	client := fake.NewSimpleClientset(secret)
+	tokens := newSecretTokens(client, nil, yandex360api.NewApiClient(), oauthSettings{refreshBefore: 24 * time.Hour})
+	source := tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "yandex360-credentials"}, Key: "token"}, server.URL+"/token")
+
+	token, err := source.Token(context.TODO())
+	require.NoError(t, err)
+	require.NotEqual(t, "expiring", token)
+
+	// the new tokens are written back
+	updated, err := client.CoreV1().Secrets("default").Get(context.TODO(), "yandex360-credentials", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, string(updated.Data["token"]))
+	require.NotEqual(t, "mockRefreshToken=", string(updated.Data["refresh_token"]))
+	expiry, err := time.Parse(time.RFC3339, string(updated.Data["expiry"]))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(365*24*time.Hour), expiry, time.Minute)
+
+	// and used without another refresh, the rotated refresh token would fail
+	again, err := source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, token, again)
+
+	// a failed refresh still hands out a valid token
+	updated.Data["refresh_token"] = []byte("revoked")
+	updated.Data["expiry"] = []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	tokens = newSecretTokens(fake.NewSimpleClientset(updated), nil, yandex360api.NewApiClient(), oauthSettings{refreshBefore: 24 * time.Hour})
+	source = tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "yandex360-credentials"}, Key: "token"}, server.URL+"/token")
+	again, err = source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, token, again)
+
+	// but not an expired one
+	updated.Data["expiry"] = []byte(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	tokens = newSecretTokens(fake.NewSimpleClientset(updated), nil, yandex360api.NewApiClient(), oauthSettings{refreshBefore: 24 * time.Hour})
+	source = tokens.Source("default", certmgrapiv1.SecretKeySelector{LocalObjectReference: certmgrapiv1.LocalObjectReference{Name: "yandex360-credentials"}, Key: "token"}, server.URL+"/token")
+	_, err = source.Token(context.TODO())
+	require.ErrorContains(t, err, "invalid_grant")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// Span attributes of the solver, matching the ones of the API client.
+const (
+	attrOrganizationID = "yandex360.organization_id"
+	attrDomain         = "yandex360.domain"
+	attrRecordType     = "yandex360.record_type"
+	attrFQDN           = "yandex360.fqdn"
+	attrNamespace      = "k8s.namespace.name"
+	attrCorrelationID  = "yandex360.correlation_id"
+)
+
+var tracer = otel.Tracer("github.com/alexfirs/cert-manager-webhook-yandex360")
+
+// setupTracing exports spans with OTLP over gRPC if an OTLP endpoint is
+// configured through the standard OpenTelemetry environment variables, e.g.
+// OTEL_EXPORTER_OTLP_ENDPOINT. The returned function flushes the pending spans.
+func setupTracing(ctx context.Context) func() {
+	if getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" && getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" {
+		return func() {}
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create the OTLP trace exporter")
+		return func() {}
+	}
+
+	// resource.Default reads OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES
+	res, err := resource.Merge(
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("cert-manager-webhook-yandex360")),
+		resource.Default(),
+	)
+	if err != nil {
+		klog.ErrorS(err, "Failed to build the trace resource")
+		res = resource.Default()
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	klog.InfoS("Exporting traces with OTLP")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			klog.ErrorS(err, "Failed to flush traces")
+		}
+	}
+}
+
+// startSolverSpan starts the span of a solver operation for the challenge.
+func startSolverSpan(ctx context.Context, operation string, ch *v1alpha1.ChallengeRequest) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String(attrRecordType, yandex360api.TXTKey)}
+	if id := correlationIDFrom(ctx); id != "" {
+		attrs = append(attrs, attribute.String(attrCorrelationID, id))
+	}
+	if ch != nil {
+		attrs = append(attrs,
+			attribute.String(attrFQDN, ch.ResolvedFQDN),
+			attribute.String(attrNamespace, ch.ResourceNamespace),
+		)
+	}
+	return tracer.Start(ctx, "solver."+operation, trace.WithAttributes(attrs...))
+}
+
+// traceApiSettings adds the organization and the domain to span.
+func traceApiSettings(span trace.Span, apiSettings *yandex360api.ApiSettings) {
+	span.SetAttributes(
+		attribute.Int(attrOrganizationID, apiSettings.OrganizationId),
+		attribute.String(attrDomain, apiSettings.Domain),
+	)
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/logs"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+// webhookConfigFile is the webhook wide configuration file, usually mounted
+// from a ConfigMap. Every field set overrides the flag and the environment
+// variable of the same setting, removing it restores them.
+type webhookConfigFile struct {
+	// Endpoint is the API endpoint of issuers without one
+	Endpoint string `json:"endpoint,omitempty"`
+	// TTL of the challenge records of issuers without one
+	TTL int `json:"ttl,omitempty"`
+	// AllowedDomains are the only DNS names challenges are solved for, like
+	// the domains of a policy rule; empty allows all
+	AllowedDomains []string             `json:"allowedDomains,omitempty"`
+	API            webhookAPIConfig     `json:"api,omitempty"`
+	Logging        webhookLoggingConfig `json:"logging,omitempty"`
+}
+
+type webhookAPIConfig struct {
+	Timeout   *metav1.Duration       `json:"timeout,omitempty"`
+	Retry     webhookRetryConfig     `json:"retry,omitempty"`
+	RateLimit webhookRateLimitConfig `json:"rateLimit,omitempty"`
+}
+
+type webhookRetryConfig struct {
+	MaxAttempts int              `json:"maxAttempts,omitempty"`
+	BaseDelay   *metav1.Duration `json:"baseDelay,omitempty"`
+	MaxDelay    *metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+type webhookRateLimitConfig struct {
+	QPS   *float64 `json:"qps,omitempty"`
+	Burst int      `json:"burst,omitempty"`
+	Key   string   `json:"key,omitempty"`
+}
+
+type webhookLoggingConfig struct {
+	// Format is only applied on startup, the logger cannot be replaced later
+	Format    string `json:"format,omitempty"`
+	Verbosity *int   `json:"verbosity,omitempty"`
+}
+
+// webhookDefaults are the effective webhook wide settings.
+type webhookDefaults struct {
+	endpoint       string
+	ttl            int
+	allowedDomains []string
+	timeout        time.Duration
+	retry          yandex360api.RetryPolicy
+	rateLimit      yandex360api.RateLimit
+	format         string
+	// verbosity is nil if the file does not set it, leaving it as it is
+	verbosity *int
+}
+
+// webhookSettings are the webhook wide settings of the flags and environment
+// variables, overridden by the config file, which is reloaded when it changes.
+type webhookSettings struct {
+	path           string
+	reloadInterval time.Duration
+	endpoint       string
+	ttl            int
+	allowedDomains []string
+	// api holds the flags of the API client settings the file may override
+	api *apiClientConfig
+
+	current atomic.Pointer[webhookDefaults]
+
+	// mu serializes the reloads
+	mu sync.Mutex
+	// raw is the content of the file last applied
+	raw []byte
+}
+
+// webhookSettingsFromEnv reads the webhook wide settings from the environment:
+//
+//	WEBHOOK_CONFIG                  path of the config file, empty disables it
+//	WEBHOOK_CONFIG_RELOAD_INTERVAL  how often the file is checked for changes, default "10s"
+//	DEFAULT_ENDPOINT                API endpoint of issuers without one
+//	DEFAULT_TTL                     TTL of the challenge records of issuers without one, default "300"
+//	ALLOWED_DOMAINS                 comma separated DNS names challenges are solved for, empty allows all
+func webhookSettingsFromEnv(api *apiClientConfig) (*webhookSettings, error) {
+	var env envErrors
+	var allowedDomains []string
+	if value := getEnv("ALLOWED_DOMAINS", ""); value != "" {
+		allowedDomains = strings.Split(value, ",")
+	}
+
+	s := &webhookSettings{
+		path:           getEnv("WEBHOOK_CONFIG", ""),
+		reloadInterval: env.duration("WEBHOOK_CONFIG_RELOAD_INTERVAL", "10s"),
+		endpoint:       getEnv("DEFAULT_ENDPOINT", ""),
+		ttl:            env.integer("DEFAULT_TTL", "300"),
+		allowedDomains: allowedDomains,
+		api:            api,
+	}
+	if len(env) > 0 {
+		return s, env.err()
+	}
+	defaults, err := s.merge(webhookConfigFile{})
+	if err != nil {
+		return s, fmt.Errorf("invalid defaults: %w", err)
+	}
+	s.current.Store(defaults)
+	return s, nil
+}
+
+// AddFlags adds the flags overriding the environment to fs.
+func (s *webhookSettings) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.path, "config", s.path, "Path of the webhook config file, reloaded when it changes. Its settings override the flags.")
+	fs.DurationVar(&s.reloadInterval, "config-reload-interval", s.reloadInterval, "How often the webhook config file is checked for changes, 0 disables reloading.")
+	fs.StringVar(&s.endpoint, "default-endpoint", s.endpoint, "Yandex 360 API endpoint of issuers without an endpoint.")
+	fs.IntVar(&s.ttl, "default-ttl", s.ttl, "TTL of the challenge records of issuers without a ttl.")
+	fs.StringSliceVar(&s.allowedDomains, "allowed-domains", s.allowedDomains, `DNS names challenges are solved for, "*.example.com" allows the names below example.com. Empty allows all.`)
+}
+
+// defaults returns the effective settings.
+func (s *webhookSettings) defaults() *webhookDefaults {
+	return s.current.Load()
+}
+
+// readConfigFile reads and decodes the config file at path.
+func readConfigFile(path string) (webhookConfigFile, []byte, error) {
+	cfg := webhookConfigFile{}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return cfg, nil, fmt.Errorf("invalid webhook config %s: %w", path, err)
+	}
+	return cfg, raw, nil
+}
+
+// merge returns the flags overridden by cfg.
+func (s *webhookSettings) merge(cfg webhookConfigFile) (*webhookDefaults, error) {
+	d := &webhookDefaults{
+		endpoint:       s.endpoint,
+		ttl:            s.ttl,
+		allowedDomains: s.allowedDomains,
+		timeout:        s.api.timeout,
+		retry:          s.api.retry,
+		rateLimit:      s.api.rateLimit,
+		format:         cfg.Logging.Format,
+		verbosity:      cfg.Logging.Verbosity,
+	}
+	if cfg.Endpoint != "" {
+		d.endpoint = cfg.Endpoint
+	}
+	if cfg.TTL != 0 {
+		d.ttl = cfg.TTL
+	}
+	if cfg.AllowedDomains != nil {
+		d.allowedDomains = cfg.AllowedDomains
+	}
+	if cfg.API.Timeout != nil {
+		d.timeout = cfg.API.Timeout.Duration
+	}
+	if cfg.API.Retry.MaxAttempts != 0 {
+		d.retry.MaxAttempts = cfg.API.Retry.MaxAttempts
+	}
+	if cfg.API.Retry.BaseDelay != nil {
+		d.retry.BaseDelay = cfg.API.Retry.BaseDelay.Duration
+	}
+	if cfg.API.Retry.MaxDelay != nil {
+		d.retry.MaxDelay = cfg.API.Retry.MaxDelay.Duration
+	}
+	if cfg.API.RateLimit.QPS != nil {
+		d.rateLimit.QPS = *cfg.API.RateLimit.QPS
+	}
+	if cfg.API.RateLimit.Burst != 0 {
+		d.rateLimit.Burst = cfg.API.RateLimit.Burst
+	}
+	if cfg.API.RateLimit.Key != "" {
+		d.rateLimit.Key = yandex360api.RateLimitKey(cfg.API.RateLimit.Key)
+	}
+
+	if d.ttl < 1 {
+		return nil, fmt.Errorf("ttl must be positive, got %d", d.ttl)
+	}
+	if d.retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("api.retry.maxAttempts must be at least 1, got %d", d.retry.MaxAttempts)
+	}
+	key, err := yandex360api.ParseRateLimitKey(string(d.rateLimit.Key))
+	if err != nil {
+		return nil, fmt.Errorf("api.rateLimit.key: %w", err)
+	}
+	d.rateLimit.Key = key
+	switch d.format {
+	case "", "text", "json":
+	default:
+		return nil, fmt.Errorf(`logging.format must be "text" or "json", got %q`, d.format)
+	}
+	if d.verbosity != nil && *d.verbosity < 0 {
+		return nil, fmt.Errorf("logging.verbosity must not be negative, got %d", *d.verbosity)
+	}
+	return d, nil
+}
+
+// reloadWebhookConfig reads the config file and applies it to the API client
+// and the logger if it changed. An invalid file keeps the settings in effect.
+// With force the settings are applied even if the file did not change, e.g.
+// to a new API client.
+func (y *yandex360DNSSolver) reloadWebhookConfig(ctx context.Context, force bool) (err error) {
+	s := y.settings
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := webhookConfigFile{}
+	var raw []byte
+	if s.path != "" {
+		cfg, raw, err = readConfigFile(s.path)
+		if err != nil {
+			solverConfigReloadsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+	}
+	if !force && bytes.Equal(raw, s.raw) {
+		return nil
+	}
+	defaults, err := s.merge(cfg)
+	if err != nil {
+		solverConfigReloadsTotal.WithLabelValues("error").Inc()
+		return fmt.Errorf("invalid webhook config %s: %w", s.path, err)
+	}
+
+	previous := s.defaults()
+	s.current.Store(defaults)
+	s.raw = raw
+	y.apiClient.SetTimeout(defaults.timeout)
+	y.apiClient.SetRetryPolicy(defaults.retry)
+	if force || defaults.rateLimit != previous.rateLimit {
+		y.apiClient.SetRateLimit(defaults.rateLimit)
+	}
+	if defaults.verbosity != nil {
+		if _, err := logs.GlogSetter(strconv.Itoa(*defaults.verbosity)); err != nil {
+			return err
+		}
+	}
+	if !force && defaults.format != previous.format {
+		klog.FromContext(ctx).Info("Changing the logging format requires a restart", "format", defaults.format)
+	}
+	if s.path != "" {
+		solverConfigReloadsTotal.WithLabelValues("success").Inc()
+		klog.FromContext(ctx).Info("Loaded webhook config", "path", s.path, "endpoint", defaults.endpoint, "ttl", defaults.ttl, "allowedDomains", defaults.allowedDomains)
+	}
+	return nil
+}
+
+// watchWebhookConfig reloads the config file every reloadInterval until
+// stopCh is closed.
+func (y *yandex360DNSSolver) watchWebhookConfig(stopCh <-chan struct{}) {
+	ctx := klog.NewContext(context.Background(), y.namedLogger("config"))
+	wait.Until(func() {
+		if err := y.reloadWebhookConfig(ctx, false); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to reload the webhook config, keeping the previous one")
+		}
+	}, y.settings.reloadInterval, stopCh)
+}
+
+// applyLoggingConfig passes the logging settings of the config file at path to
+// the logging flags of fs, after they are parsed and before the webhook server
+// applies them. Flags set on the command line take precedence. Errors are left
+// to the first load in Initialize.
+func applyLoggingConfig(path string, fs *pflag.FlagSet) {
+	if path == "" {
+		return
+	}
+	cfg, _, err := readConfigFile(path)
+	if err != nil {
+		return
+	}
+	if cfg.Logging.Format != "" && !fs.Changed("logging-format") {
+		_ = fs.Set("logging-format", cfg.Logging.Format)
+	}
+	if cfg.Logging.Verbosity != nil && !fs.Changed("v") {
+		_ = fs.Set("v", strconv.Itoa(*cfg.Logging.Verbosity))
+	}
+}
+
+// allowsDomain returns whether the webhook config allows challenges for the
+// DNS name the record name is written for.
+func (d *webhookDefaults) allowsDomain(name string) bool {
+	if len(d.allowedDomains) == 0 {
+		return true
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(name), acmeChallengeLabel+"."), ".")
+	return matchesDomain(d.allowedDomains, name)
+}
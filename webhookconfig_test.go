@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd/server"
+
+	"github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api"
+)
+
+func TestWebhookSettings_Reload(t *testing.T) {
+	solver, apiUrl := newTestSolver(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	solver.settings.path = path
+	write := func(config string) {
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+	}
+
+	write(`
+endpoint: ` + apiUrl.String() + `
+ttl: 60
+allowedDomains: ["*.example1.com"]
+api:
+  retry:
+    maxAttempts: 2
+`)
+	require.NoError(t, solver.reloadWebhookConfig(context.TODO(), true))
+	defaults := solver.settings.defaults()
+	require.Equal(t, apiUrl.String(), defaults.endpoint)
+	require.Equal(t, 2, defaults.retry.MaxAttempts)
+	require.Equal(t, solver.apiConfig.retry.BaseDelay, defaults.retry.BaseDelay, "unset fields keep the flags")
+
+	// issuers without an endpoint and ttl use the defaults
+	ch := challengeRequest(apiUrl, "_acme-challenge.www.example1.com.", "key")
+	ch.Config = &extapi.JSON{Raw: []byte(`{"organizationId":1001,"apiTokenSecretRef":{"name":"yandex360-credentials","key":"token"}}`)}
+	require.NoError(t, solver.Present(ch))
+	records, err := solver.apiClient.GetDnsRecords(context.TODO(), &yandex360api.ApiSettings{ApiUrl: apiUrl, Token: mockToken, OrganizationId: 1001, Domain: "example1.com"})
+	require.NoError(t, err)
+	created := challengeRecords(records, "_acme-challenge.www", "key")
+	require.Len(t, created, 1)
+	require.Equal(t, 60, created[0].TTL)
+	require.NoError(t, solver.CleanUp(ch))
+
+	require.ErrorContains(t, solver.Present(challengeRequest(apiUrl, "_acme-challenge.example1.com.", "key")), "_acme-challenge.example1.com. is not in the allowed domains of the webhook config")
+
+	// an invalid file keeps the previous settings
+	failed := testutil.ToFloat64(solverConfigReloadsTotal.WithLabelValues("error"))
+	write("ttl: 60\nunknown: true\n")
+	require.ErrorContains(t, solver.reloadWebhookConfig(context.TODO(), false), "unknown field")
+	write("api:\n  retry:\n    maxAttempts: 0\n  rateLimit:\n    key: domain\n")
+	require.ErrorContains(t, solver.reloadWebhookConfig(context.TODO(), false), "api.rateLimit.key")
+	require.Equal(t, failed+2, testutil.ToFloat64(solverConfigReloadsTotal.WithLabelValues("error")))
+	require.Same(t, defaults, solver.settings.defaults())
+
+	// removed fields fall back to the flags
+	loaded := testutil.ToFloat64(solverConfigReloadsTotal.WithLabelValues("success"))
+	write("ttl: 120\n")
+	require.NoError(t, solver.reloadWebhookConfig(context.TODO(), false))
+	defaults = solver.settings.defaults()
+	require.Equal(t, 120, defaults.ttl)
+	require.Empty(t, defaults.endpoint)
+	require.Empty(t, defaults.allowedDomains)
+	require.Equal(t, solver.apiConfig.retry, defaults.retry)
+	require.Equal(t, loaded+1, testutil.ToFloat64(solverConfigReloadsTotal.WithLabelValues("success")))
+
+	// unchanged files are not applied again
+	require.NoError(t, solver.reloadWebhookConfig(context.TODO(), false))
+	require.Same(t, defaults, solver.settings.defaults())
+	require.Equal(t, loaded+1, testutil.ToFloat64(solverConfigReloadsTotal.WithLabelValues("success")))
+}
+
+func TestWebhookSettings_Flags(t *testing.T) {
+	t.Setenv("DEFAULT_TTL", "90")
+	solver := New().(*yandex360DNSSolver)
+	command := server.NewCommandStartWebhookServer(io.Discard, io.Discard, nil, "acme.example.com", solver)
+	solver.apiConfig.AddFlags(command.Flags())
+	solver.settings.AddFlags(command.Flags())
+
+	require.Equal(t, 90, solver.settings.defaults().ttl)
+	require.NoError(t, command.Flags().Parse([]string{"--default-ttl=120", "--allowed-domains=example1.com,*.example2.com", "--default-endpoint=https://api360.yandex.net"}))
+	defaults, err := solver.settings.merge(webhookConfigFile{})
+	require.NoError(t, err)
+	require.Equal(t, 120, defaults.ttl)
+	require.Equal(t, "https://api360.yandex.net", defaults.endpoint)
+	require.True(t, defaults.allowsDomain("_acme-challenge.www.example2.com."))
+	require.True(t, defaults.allowsDomain("_acme-challenge.Example1.com."))
+	require.False(t, defaults.allowsDomain("_acme-challenge.example2.com."))
+
+	// the logging settings of the file of --config are passed to the flags
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("logging:\n  format: json\n  verbosity: 4\n"), 0o644))
+	require.NoError(t, command.Flags().Parse([]string{"--config=" + path}))
+	applyLoggingConfig(solver.settings.path, command.Flags())
+	require.Equal(t, "json", command.Flags().Lookup("logging-format").Value.String())
+	require.Equal(t, "4", command.Flags().Lookup("v").Value.String())
+
+	// unless set on the command line
+	command = server.NewCommandStartWebhookServer(io.Discard, io.Discard, nil, "acme.example.com", solver)
+	solver.settings.AddFlags(command.Flags())
+	require.NoError(t, command.Flags().Parse([]string{"--config=" + path, "-v=2"}))
+	applyLoggingConfig(solver.settings.path, command.Flags())
+	require.Equal(t, "json", command.Flags().Lookup("logging-format").Value.String())
+	require.Equal(t, "2", command.Flags().Lookup("v").Value.String())
+}
+
+func TestWebhookSettingsFromEnv_Invalid(t *testing.T) {
+	api, err := apiClientConfigFromEnv()
+	require.NoError(t, err)
+
+	t.Setenv("DEFAULT_TTL", "5m")
+	_, err = webhookSettingsFromEnv(api)
+	require.ErrorContains(t, err, "DEFAULT_TTL must be an integer")
+
+	t.Setenv("DEFAULT_TTL", "0")
+	_, err = webhookSettingsFromEnv(api)
+	require.ErrorContains(t, err, "ttl must be positive")
+}
@@ -0,0 +1,121 @@
+package yandex360api
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// recordCache caches the DNS records of a domain for a short time and lets
+// concurrent callers share a single listing, e.g. when many challenges of one
+// domain are cleaned up at once. Entries are keyed by API host, token,
+// organization and domain and are invalidated by the writes of the client.
+type recordCache struct {
+	ttl   time.Duration
+	now   func() time.Time
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*recordCacheEntry
+}
+
+type recordCacheEntry struct {
+	// generation is increased on every invalidation, listings started before
+	// are not stored
+	generation uint64
+	records    []DnsRecord
+	expires    time.Time
+}
+
+func newRecordCache(ttl time.Duration) *recordCache {
+	return &recordCache{ttl: ttl, now: time.Now, entries: map[string]*recordCacheEntry{}}
+}
+
+// Get returns the cached records of the domain of apiSettings, or lists them
+// with list, sharing the listing with concurrent callers. cached reports
+// whether no request of this caller was needed.
+func (c *recordCache) Get(ctx context.Context, apiSettings *ApiSettings, list func(context.Context) ([]DnsRecord, error)) (records []DnsRecord, cached bool, err error) {
+	key := recordCacheKey(apiSettings)
+
+	c.mu.Lock()
+	entry := c.entry(key)
+	if entry.records != nil && c.now().Before(entry.expires) {
+		records = slices.Clone(entry.records)
+		c.mu.Unlock()
+		apiCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return records, true, nil
+	}
+	generation := entry.generation
+	c.mu.Unlock()
+
+	// the listing is shared, one caller giving up must not fail the others
+	ch := c.group.DoChan(key+"#"+strconv.FormatUint(generation, 10), func() (interface{}, error) {
+		records, err := list(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		if entry := c.entry(key); entry.generation == generation {
+			entry.records = records
+			entry.expires = c.now().Add(c.ttl)
+		}
+		c.mu.Unlock()
+		return records, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case result := <-ch:
+		if result.Shared {
+			apiCacheRequestsTotal.WithLabelValues("shared").Inc()
+		} else {
+			apiCacheRequestsTotal.WithLabelValues("miss").Inc()
+		}
+		if result.Err != nil {
+			return nil, false, result.Err
+		}
+		return slices.Clone(result.Val.([]DnsRecord)), result.Shared, nil
+	}
+}
+
+// Invalidate drops the cached records of the domain of apiSettings, after a
+// write or an attempted write. The listings of all tokens are dropped, a write
+// made with one token changes what all others see.
+func (c *recordCache) Invalidate(apiSettings *ApiSettings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := recordCacheDomain(apiSettings) + "/"
+	for key, entry := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			entry.generation++
+			entry.records = nil
+		}
+	}
+}
+
+// entry returns the entry of key, creating it if needed. c.mu must be held.
+func (c *recordCache) entry(key string) *recordCacheEntry {
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &recordCacheEntry{}
+		c.entries[key] = entry
+	}
+	return entry
+}
+
+// recordCacheKey keys the listing of a domain by the token too, tokens
+// without access to the domain must not be served the records.
+func recordCacheKey(apiSettings *ApiSettings) string {
+	return recordCacheDomain(apiSettings) + "/" + tokenHash(apiSettings.Token)
+}
+
+func recordCacheDomain(apiSettings *ApiSettings) string {
+	return apiSettings.ApiUrl.Host + "/" + strconv.Itoa(apiSettings.OrganizationId) + "/" + apiSettings.Domain
+}
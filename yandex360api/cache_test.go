@@ -0,0 +1,210 @@
+package yandex360api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newCountingMock serves settings, counting the listings of DNS records.
+// Every request is delayed by latency.
+func newCountingMock(t testing.TB, settings Yandex360ApiMockSettings, latency time.Duration) (*url.URL, *atomic.Int64) {
+	var listings atomic.Int64
+	handler := NewYandex360ApiMock(settings).Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/dns") {
+			listings.Add(1)
+		}
+		time.Sleep(latency)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	apiUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return apiUrl, &listings
+}
+
+// largeZoneSettings returns mock settings with a single domain of size records
+// in organization 1001.
+func largeZoneSettings(size int) Yandex360ApiMockSettings {
+	records := make(Records, 0, size)
+	for i := 1; i <= size; i++ {
+		records = append(records, DnsRecord{RecordID: i, Name: fmt.Sprintf("host%d", i), Type: "TXT", TTL: 300, Text: fmt.Sprintf("text%d", i)})
+	}
+	return Yandex360ApiMockSettings{
+		authKey:                 Yandex360ApiMock_TestData.authKey,
+		organizationsAndDomains: map[int]Domains{1001: {"example1.com": records}},
+	}
+}
+
+func TestRecordCache_SharesConcurrentListings(t *testing.T) {
+	apiUrl, listings := newCountingMock(t, Yandex360ApiMock_TestData, 50*time.Millisecond)
+	client := NewApiClient(WithRecordCache(time.Minute))
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records, err := client.GetDnsRecords(context.TODO(), settings)
+			require.NoError(t, err)
+			require.Len(t, records, 3)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(1), listings.Load())
+
+	// served from the cache
+	records, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), listings.Load())
+
+	// callers get their own copy
+	records[0].Name = "modified"
+	records, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Equal(t, "@", records[0].Name)
+
+	// another domain is listed separately
+	_, err = client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example2.com", Token: Yandex360ApiMock_TestData.authKey})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), listings.Load())
+}
+
+func TestRecordCache_InvalidatedByWrites(t *testing.T) {
+	apiUrl, listings := newCountingMock(t, Yandex360ApiMock_TestData, 0)
+	client := NewApiClient(WithRecordCache(time.Minute))
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+
+	created, err := client.AddTxtRecord(context.TODO(), settings, "_acme-challenge", "key", 300)
+	require.NoError(t, err)
+	records, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, int64(2), listings.Load())
+
+	require.NoError(t, client.DeleteDnsRecord(context.TODO(), settings, created.RecordID))
+	records, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, int64(3), listings.Load())
+}
+
+func TestRecordCache_Expires(t *testing.T) {
+	apiUrl, listings := newCountingMock(t, Yandex360ApiMock_TestData, 0)
+	client := NewApiClient(WithRecordCache(time.Second))
+	now := time.Now()
+	client.cache.now = func() time.Time { return now }
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetDnsRecords(context.TODO(), settings)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), listings.Load())
+
+	now = now.Add(2 * time.Second)
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), listings.Load())
+}
+
+func TestRecordCache_CallerCancellation(t *testing.T) {
+	apiUrl, _ := newCountingMock(t, Yandex360ApiMock_TestData, 100*time.Millisecond)
+	client := NewApiClient(WithRecordCache(time.Minute))
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.GetDnsRecords(ctx, settings)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}()
+
+	// the cancelled caller does not fail the listing it shares
+	time.Sleep(time.Millisecond)
+	records, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	wg.Wait()
+}
+
+func BenchmarkGetDnsRecords(b *testing.B) {
+	for _, size := range []int{500, 5000} {
+		apiUrl, _ := newCountingMock(b, largeZoneSettings(size), 0)
+		settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+		for _, bm := range []struct {
+			name   string
+			client *ApiClient
+		}{
+			{"uncached", NewApiClient()},
+			{"cached", NewApiClient(WithRecordCache(time.Second))},
+		} {
+			b.Run(fmt.Sprintf("records=%d/%s", size, bm.name), func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if _, err := bm.client.GetDnsRecords(context.TODO(), settings); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkConcurrentCleanUps lists the zone from 10 concurrent callers per
+// iteration after a write, like 10 CleanUps of the same domain.
+func BenchmarkConcurrentCleanUps(b *testing.B) {
+	for _, size := range []int{500, 5000} {
+		for _, bm := range []struct {
+			name string
+			opts []Option
+		}{
+			{"uncached", nil},
+			{"cached", []Option{WithRecordCache(time.Second)}},
+		} {
+			b.Run(fmt.Sprintf("records=%d/%s", size, bm.name), func(b *testing.B) {
+				apiUrl, listings := newCountingMock(b, largeZoneSettings(size), 0)
+				settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+				client := NewApiClient(bm.opts...)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					client.invalidate(settings)
+					var wg sync.WaitGroup
+					for j := 0; j < 10; j++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							if _, err := client.GetDnsRecords(context.TODO(), settings); err != nil {
+								b.Error(err)
+							}
+						}()
+					}
+					wg.Wait()
+				}
+				b.ReportMetric(float64(listings.Load())/float64(b.N), "requests/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package yandex360api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CircuitBreakerPolicy configures the circuit breakers of the client. There
+// is one per API host and organization, so an outage or a revoked token of one
+// organization does not slow down the others. A circuit opens after
+// FailureThreshold consecutive failed requests: no response, 401, 403, 429 or
+// a 5xx status after all retries. While it is open requests fail fast with a
+// *CircuitOpenError; after OpenDuration it lets HalfOpenProbes requests
+// through, closing again on their success.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures opening a
+	// circuit, zero disables the circuit breakers
+	FailureThreshold int
+	// OpenDuration is how long an open circuit rejects requests
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of concurrent requests let through a
+	// half-open circuit, at least 1
+	HalfOpenProbes int
+}
+
+// DefaultCircuitBreakerPolicy opens a circuit after 5 failures for 30 seconds.
+var DefaultCircuitBreakerPolicy = CircuitBreakerPolicy{FailureThreshold: 5, OpenDuration: 30 * time.Second, HalfOpenProbes: 1}
+
+// WithCircuitBreaker fails requests fast while Yandex 360 keeps failing for an
+// organization, see CircuitBreakerPolicy.
+func WithCircuitBreaker(policy CircuitBreakerPolicy) Option {
+	return func(a *ApiClient) {
+		a.circuits = nil
+		if policy.FailureThreshold > 0 {
+			a.circuits = newCircuitBreakers(policy)
+		}
+	}
+}
+
+// ErrCircuitOpen is matched by the *CircuitOpenError of rejected requests.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned for requests rejected by an open circuit.
+type CircuitOpenError struct {
+	Host           string
+	OrganizationId int
+	// Until is when the circuit lets the next probe through
+	Until time.Time
+	// LastError is the failure of the last request of the circuit
+	LastError string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for organization %d at %s until %s, last error: %s", e.OrganizationId, e.Host, e.Until.UTC().Format(time.RFC3339), e.LastError)
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) match.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// circuitStateValues are the values of the yandex360_api_circuit_state metric.
+var circuitStateValues = map[CircuitState]float64{CircuitClosed: 0, CircuitHalfOpen: 1, CircuitOpen: 2}
+
+// CircuitStatus is the state of the circuit breaker of an organization.
+type CircuitStatus struct {
+	Host                string       `json:"host"`
+	OrganizationId      int          `json:"organizationId"`
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	// OpenedAt is when the circuit last opened, zero if it never did
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// CircuitStates returns the state of the circuit breakers, empty if they are
+// disabled.
+func (a *ApiClient) CircuitStates() []CircuitStatus {
+	if a.circuits == nil {
+		return nil
+	}
+	return a.circuits.states()
+}
+
+type circuitKey struct {
+	host           string
+	organizationId int
+}
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	lastError string
+}
+
+// circuitBreakers holds one circuit per API host and organization.
+type circuitBreakers struct {
+	CircuitBreakerPolicy
+	now func() time.Time
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*circuit
+}
+
+func newCircuitBreakers(policy CircuitBreakerPolicy) *circuitBreakers {
+	if policy.HalfOpenProbes < 1 {
+		policy.HalfOpenProbes = 1
+	}
+	return &circuitBreakers{CircuitBreakerPolicy: policy, now: time.Now, circuits: map[circuitKey]*circuit{}}
+}
+
+// allow returns an error if the circuit of req is open, or else the function
+// to call with the outcome of req. Requests not made for an organization
+// always pass.
+func (b *circuitBreakers) allow(req *http.Request) (func(*http.Response, error), error) {
+	scope, _ := req.Context().Value(requestScopeKey{}).(requestScope)
+	if b == nil || scope.organizationId == 0 {
+		return func(*http.Response, error) {}, nil
+	}
+	key := circuitKey{host: req.URL.Host, organizationId: scope.organizationId}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[key] = c
+	}
+
+	probe := false
+	switch c.state {
+	case CircuitOpen:
+		until := c.openedAt.Add(b.OpenDuration)
+		if b.now().Before(until) {
+			return nil, b.reject(key, c, until)
+		}
+		b.setState(req.Context(), key, c, CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if c.probes >= b.HalfOpenProbes {
+			return nil, b.reject(key, c, b.now())
+		}
+		c.probes++
+		probe = true
+	}
+
+	return func(r *http.Response, err error) {
+		b.record(req.Context(), key, c, probe, r, err)
+	}, nil
+}
+
+func (b *circuitBreakers) reject(key circuitKey, c *circuit, until time.Time) error {
+	apiCircuitRejectedTotal.WithLabelValues(key.host, strconv.Itoa(key.organizationId)).Inc()
+	return &CircuitOpenError{Host: key.host, OrganizationId: key.organizationId, Until: until, LastError: c.lastError}
+}
+
+// record updates c with the outcome of a request.
+func (b *circuitBreakers) record(ctx context.Context, key circuitKey, c *circuit, probe bool, r *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		c.probes--
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// cancelled by the caller, says nothing about Yandex 360
+		return
+	case err != nil:
+		c.lastError = RedactString(err.Error())
+	case r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500:
+		c.lastError = fmt.Sprintf("status %d %s", r.StatusCode, http.StatusText(r.StatusCode))
+	default:
+		c.failures = 0
+		if c.state != CircuitClosed {
+			b.setState(ctx, key, c, CircuitClosed)
+		}
+		return
+	}
+
+	c.failures++
+	if c.state == CircuitHalfOpen || (c.state == CircuitClosed && c.failures >= b.FailureThreshold) {
+		c.openedAt = b.now()
+		b.setState(ctx, key, c, CircuitOpen)
+	}
+}
+
+func (b *circuitBreakers) setState(ctx context.Context, key circuitKey, c *circuit, state CircuitState) {
+	c.state = state
+	apiCircuitState.WithLabelValues(key.host, strconv.Itoa(key.organizationId)).Set(circuitStateValues[state])
+
+	logger := klog.FromContext(ctx).WithValues("host", key.host, "organizationId", key.organizationId)
+	switch state {
+	case CircuitOpen:
+		logger.Info("Circuit breaker opened", "failures", c.failures, "lastError", c.lastError, "openDuration", b.OpenDuration)
+	case CircuitHalfOpen:
+		logger.V(2).Info("Circuit breaker half-open, probing")
+	case CircuitClosed:
+		logger.Info("Circuit breaker closed")
+	}
+}
+
+func (b *circuitBreakers) states() []CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make([]CircuitStatus, 0, len(b.circuits))
+	for key, c := range b.circuits {
+		state := c.state
+		if state == CircuitOpen && !b.now().Before(c.openedAt.Add(b.OpenDuration)) {
+			// the next request is a probe
+			state = CircuitHalfOpen
+		}
+		states = append(states, CircuitStatus{
+			Host:                key.host,
+			OrganizationId:      key.organizationId,
+			State:               state,
+			ConsecutiveFailures: c.failures,
+			OpenedAt:            c.openedAt,
+			LastError:           c.lastError,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Host != states[j].Host {
+			return states[i].Host < states[j].Host
+		}
+		return states[i].OrganizationId < states[j].OrganizationId
+	})
+	return states
+}
@@ -0,0 +1,114 @@
+package yandex360api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_CircuitBreaker(t *testing.T) {
+	mock := NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler()
+	var down atomic.Bool
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+
+	now := time.Now()
+	client := NewApiClient(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}), WithCircuitBreaker(CircuitBreakerPolicy{FailureThreshold: 3, OpenDuration: time.Minute}))
+	client.circuits.now = func() time.Time { return now }
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+	other := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1002, Domain: "example3.com", Token: Yandex360ApiMock_TestData.authKey}
+	host := apiUrl.Host
+
+	// opens after 3 failures
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		_, err := client.GetDnsRecords(context.TODO(), settings)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	require.Equal(t, 2.0, testutil.ToFloat64(apiCircuitState.WithLabelValues(host, "1001")))
+
+	// and fails fast
+	requests.Store(0)
+	rejected := testutil.ToFloat64(apiCircuitRejectedTotal.WithLabelValues(host, "1001"))
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr))
+	require.Equal(t, 1001, openErr.OrganizationId)
+	require.Equal(t, "status 503 Service Unavailable", openErr.LastError)
+	require.Zero(t, requests.Load())
+	require.Equal(t, rejected+1, testutil.ToFloat64(apiCircuitRejectedTotal.WithLabelValues(host, "1001")))
+
+	// other organizations are not affected
+	down.Store(false)
+	_, err = client.GetDnsRecords(context.TODO(), other)
+	require.NoError(t, err)
+
+	require.Equal(t, []CircuitStatus{
+		{Host: host, OrganizationId: 1001, State: CircuitOpen, ConsecutiveFailures: 3, OpenedAt: now, LastError: "status 503 Service Unavailable"},
+		{Host: host, OrganizationId: 1002, State: CircuitClosed},
+	}, client.CircuitStates())
+
+	// a failed probe opens it again
+	now = now.Add(time.Minute)
+	down.Store(true)
+	requests.Store(0)
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NotErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int64(1), requests.Load())
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// a successful one closes it
+	now = now.Add(time.Minute)
+	down.Store(false)
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Equal(t, CircuitClosed, client.CircuitStates()[0].State)
+	require.Equal(t, 0.0, testutil.ToFloat64(apiCircuitState.WithLabelValues(host, "1001")))
+}
+
+func TestCircuitBreakers_HalfOpenProbes(t *testing.T) {
+	breakers := newCircuitBreakers(CircuitBreakerPolicy{FailureThreshold: 1, OpenDuration: time.Minute})
+	now := time.Now()
+	breakers.now = func() time.Time { return now }
+	req := httptest.NewRequest("GET", "https://api360.yandex.net/", nil)
+	req = req.WithContext(withRequestScope(context.TODO(), &ApiSettings{OrganizationId: 1001}))
+
+	done, err := breakers.allow(req)
+	require.NoError(t, err)
+	done(nil, errors.New("connection refused"))
+
+	now = now.Add(time.Minute)
+	probe, err := breakers.allow(req)
+	require.NoError(t, err)
+	// only one probe at a time
+	_, err = breakers.allow(req)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	probe(&http.Response{StatusCode: http.StatusOK}, nil)
+
+	_, err = breakers.allow(req)
+	require.NoError(t, err)
+
+	// requests not made for an organization are never rejected
+	_, err = breakers.allow(httptest.NewRequest("POST", "https://oauth.yandex.ru/token", nil))
+	require.NoError(t, err)
+}
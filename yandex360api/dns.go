@@ -5,12 +5,11 @@ import (
 	"strings"
 
 	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
 )
 
 func (y *Yandex360ApiMock) handleDNSRequest(w dns.ResponseWriter, req *dns.Msg) {
-	fmt.Print("HandleDNS:")
 	msg := new(dns.Msg)
-	fmt.Println(msg.String())
 	msg.SetReply(req)
 	switch req.Opcode {
 	case dns.OpcodeQuery:
@@ -25,7 +24,7 @@ func (y *Yandex360ApiMock) handleDNSRequest(w dns.ResponseWriter, req *dns.Msg)
 }
 
 func (y *Yandex360ApiMock) addDNSAnswer(q dns.Question, msg *dns.Msg, req *dns.Msg) error {
-	fmt.Printf("addDNSAnswer QType:%v, %s >> \n", q.Qtype, q.Name)
+	klog.V(4).InfoS("Mock DNS: query", "type", dns.TypeToString[q.Qtype], "name", q.Name)
 	switch q.Qtype {
 	// Always return loopback for any A query
 	case dns.TypeA:
@@ -38,8 +37,6 @@ func (y *Yandex360ApiMock) addDNSAnswer(q dns.Question, msg *dns.Msg, req *dns.M
 
 	// TXT records are the only important record for ACME dns-01 challenges
 	case dns.TypeTXT:
-		fmt.Println("\n\n>> TypeTXT: " + q.Name)
-
 		found := false
 		var records Records
 		var requestedSubDomain string
@@ -57,7 +54,7 @@ func (y *Yandex360ApiMock) addDNSAnswer(q dns.Question, msg *dns.Msg, req *dns.M
 		}
 
 		if !found {
-			fmt.Println("\n\n>> !FOUND DOMAIN")
+			klog.V(4).InfoS("Mock DNS: unknown domain", "name", q.Name)
 			msg.SetRcode(req, dns.RcodeNameError)
 			return nil
 		}
@@ -73,24 +70,21 @@ func (y *Yandex360ApiMock) addDNSAnswer(q dns.Question, msg *dns.Msg, req *dns.M
 		}
 
 		if !found {
-			fmt.Println("\n\n>> !FOUND TXT subdomain " + requestedSubDomain)
+			klog.V(4).InfoS("Mock DNS: no TXT record", "name", q.Name, "subdomain", requestedSubDomain)
 			msg.SetRcode(req, dns.RcodeNameError)
 			return nil
 		}
 
-		fmt.Println("\n\n>> FOUND: " + fmt.Sprintf("%s 5 IN TXT %s", q.Name, dnsRecord.Text))
 		rr, err := dns.NewRR(fmt.Sprintf("%s 5 IN TXT %s", q.Name, dnsRecord.Text))
 		if err != nil {
-			fmt.Println("\n\n>> rrErr " + err.Error())
 			return err
 		}
-		fmt.Println("\n\n>> answer")
+		klog.V(4).InfoS("Mock DNS: answer", "rr", rr.String())
 		msg.Answer = append(msg.Answer, rr)
 		return nil
 
 	// NS and SOA are for authoritative lookups, return obviously invalid data
 	case dns.TypeNS:
-		fmt.Println("\n\n>> TypeNS: " + q.Name)
 		rr, err := dns.NewRR(fmt.Sprintf("%s 5 IN NS ns.example-acme-webook.invalid.", q.Name))
 		if err != nil {
 			return err
@@ -0,0 +1,54 @@
+package yandex360api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ApiError is returned when Yandex 360 responds with a non-200 status code.
+type ApiError struct {
+	StatusCode int
+	// Code is the Yandex 360 error code, e.g. 16 for unauthenticated requests
+	Code      int
+	Message   string
+	RequestID string
+	// Body is the raw response body with credentials redacted, set if it is
+	// not a Yandex 360 error
+	Body string
+}
+
+func (e *ApiError) Error() string {
+	if e.Message == "" && e.Code == 0 {
+		return fmt.Sprintf("response failed with status code: %d and body: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("response failed with status code: %d, code: %d, message: %s, requestId: %s", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// responseError reads and closes the body of the failed response r and
+// returns it as an *ApiError.
+func responseError(r *http.Response) error {
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("response failed with status code: %d and unable parse body due to : %v", r.StatusCode, err)
+	}
+
+	apiErr := &ApiError{StatusCode: r.StatusCode, Body: RedactString(string(bdy))}
+
+	var rsp ErrorResponse
+	if json.Unmarshal(bdy, &rsp) == nil {
+		apiErr.Code = rsp.Code
+		apiErr.Message = rsp.Message
+		for _, d := range rsp.Details {
+			if d.RequestId != "" {
+				apiErr.RequestID = d.RequestId
+			}
+		}
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = r.Header.Get(requestIDHeader)
+	}
+	return apiErr
+}
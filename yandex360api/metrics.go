@@ -0,0 +1,171 @@
+package yandex360api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// Endpoints of the Yandex 360 API, used as metric labels.
+const (
+	endpointDnsList    = "dns.list"
+	endpointDnsCreate  = "dns.create"
+	endpointDnsUpdate  = "dns.update"
+	endpointDnsDelete  = "dns.delete"
+	endpointDomainList = "domains.list"
+	endpointOrgList    = "orgs.list"
+	endpointOAuthToken = "oauth.token"
+	endpointTokenInfo  = "oauth.tokeninfo"
+)
+
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "requests_total",
+		Help:      "Number of requests to the Yandex 360 API by endpoint, method and status code. The code is \"error\" if no response was received.",
+	}, []string{"endpoint", "method", "code"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the Yandex 360 API by endpoint and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "method"})
+
+	apiRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "rate_limited_total",
+		Help:      "Number of requests to the Yandex 360 API rejected with 429 Too Many Requests, by endpoint.",
+	}, []string{"endpoint"})
+
+	apiRateLimitQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "rate_limit_queue_depth",
+		Help:      "Number of requests waiting for the client side rate limit, by organization id.",
+	}, []string{"organization_id"})
+
+	apiRateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Time requests to the Yandex 360 API waited for the client side rate limit, by endpoint.",
+		Buckets:   []float64{0, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+
+	apiRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "retries_total",
+		Help:      "Number of requests to the Yandex 360 API sent again, by endpoint and the reason: the status code of the failed attempt or \"error\".",
+	}, []string{"endpoint", "reason"})
+
+	apiCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "record_cache_requests_total",
+		Help:      "Number of DNS record listings by result: hit (served from the cache), shared (joined a concurrent listing) or miss.",
+	}, []string{"result"})
+
+	apiCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "circuit_state",
+		Help:      "State of the circuit breaker of an organization: 0 closed, 1 half-open, 2 open.",
+	}, []string{"host", "organization_id"})
+
+	apiCircuitRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "yandex360",
+		Subsystem: "api",
+		Name:      "circuit_rejected_total",
+		Help:      "Number of requests to the Yandex 360 API failed fast by an open circuit breaker.",
+	}, []string{"host", "organization_id"})
+)
+
+// RegisterMetrics registers the metrics of the API client with registerer.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		apiRequestsTotal,
+		apiRequestDuration,
+		apiRateLimitedTotal,
+		apiRateLimitQueueDepth,
+		apiRateLimitWait,
+		apiRetriesTotal,
+		apiCacheRequestsTotal,
+		apiCircuitState,
+		apiCircuitRejectedTotal,
+	)
+}
+
+// doRequest sends req, retrying it according to the retry policy of the
+// client, and records the outcome of every attempt in the metrics of endpoint.
+// It fails fast if the circuit of the organization of req is open, and waits
+// for the rate limit of the context of req before every attempt.
+func (a *ApiClient) doRequest(req *http.Request, endpoint string) (*http.Response, error) {
+	if a.userAgent != "" {
+		req.Header.Set("User-Agent", a.userAgent)
+	}
+
+	done, err := a.circuits.allow(req)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := a.retryPolicy()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if err := a.rateLimiter().waitFor(req, endpoint); err != nil {
+			done(nil, err)
+			a.observe(req, endpoint, nil, err, start)
+			return nil, err
+		}
+		r, err := a.doAttempt(req, endpoint)
+		reason := retry.retryReason(req, attempt, r, err)
+		if reason == "" {
+			done(r, err)
+			a.observe(req, endpoint, r, err, start)
+			return r, err
+		}
+
+		delay := retry.delay(attempt, r)
+		discard(r)
+		apiRetriesTotal.WithLabelValues(endpoint, reason).Inc()
+		klog.FromContext(req.Context()).V(4).Info("Retrying Yandex 360 API request", "endpoint", endpoint, "method", req.Method, "reason", reason, "attempt", attempt, "delay", delay)
+		if err := sleep(req.Context(), delay); err != nil {
+			done(nil, err)
+			a.observe(req, endpoint, nil, err, start)
+			return nil, err
+		}
+	}
+}
+
+// doAttempt sends req once and records its outcome in the metrics of endpoint.
+func (a *ApiClient) doAttempt(req *http.Request, endpoint string) (*http.Response, error) {
+	logger := klog.FromContext(req.Context()).WithValues("endpoint", endpoint, "method", req.Method, "url", RedactString(req.URL.Redacted()))
+	logger.V(6).Info("Sending Yandex 360 API request", "headers", RedactHeader(req.Header))
+
+	start := time.Now()
+	r, err := a.httpClient().Do(req)
+	elapsed := time.Since(start)
+	apiRequestDuration.WithLabelValues(endpoint, req.Method).Observe(elapsed.Seconds())
+
+	if err != nil {
+		apiRequestsTotal.WithLabelValues(endpoint, req.Method, "error").Inc()
+		logger.V(4).Info("Yandex 360 API request failed", "duration", elapsed, "err", RedactString(err.Error()))
+		return r, err
+	}
+
+	logger.V(4).Info("Yandex 360 API request", "status", r.StatusCode, "duration", elapsed, "requestId", r.Header.Get(requestIDHeader))
+	traceResponse(r)
+	apiRequestsTotal.WithLabelValues(endpoint, req.Method, strconv.Itoa(r.StatusCode)).Inc()
+	if r.StatusCode == http.StatusTooManyRequests {
+		apiRateLimitedTotal.WithLabelValues(endpoint).Inc()
+	}
+	return r, nil
+}
@@ -3,24 +3,49 @@ package yandex360api
 import (
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
 )
 
 type ApiSettings struct {
-	ApiUrl         *url.URL
+	ApiUrl *url.URL
+	// Token is the OAuth token of the requests. If it is empty the token is
+	// taken from TokenSource, or from the TokenSource of the client.
 	Token          string
+	TokenSource    TokenSource
 	OrganizationId int
 	Domain         string
 	TTL            int
 }
 
 type ApiClient struct {
-	client *http.Client
+	// mu guards client, limiter and retry, which may be replaced by the
+	// setters while the client is in use
+	mu        sync.RWMutex
+	client    *http.Client
+	limiter   *rateLimiter
+	cache     *recordCache
+	circuits  *circuitBreakers
+	retry     RetryPolicy
+	userAgent string
+	tokens    TokenSource
+	// logger is used by requests whose context carries no logger
+	logger *klog.Logger
+	// observers are called with the result of every request
+	observers []Observer
+
+	// timeout and roundTrippers are applied to client by NewApiClient
+	timeout       time.Duration
+	roundTrippers []func(http.RoundTripper) http.RoundTripper
 }
 
 type ErrorResponse struct {
 	Code    int `json:"code"`
 	Details []struct {
-		Type string `json:"@type"`
+		Type      string `json:"@type"`
+		RequestId string `json:"requestId,omitempty"`
 	} `json:"details"`
 	Message string `json:"message"`
 }
@@ -50,3 +75,33 @@ type DnsRecord struct {
 	Value      string `json:"value,omitempty"`
 	Weight     int    `json:"weight,omitempty"`
 }
+
+type GetOrganizationsResponse struct {
+	Organizations []Organization `json:"organizations"`
+	NextPageToken string         `json:"nextPageToken"`
+}
+
+type Organization struct {
+	Id               int    `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email,omitempty"`
+	Language         string `json:"language,omitempty"`
+	SubscriptionPlan string `json:"subscriptionPlan,omitempty"`
+}
+
+type GetDomainsResponse struct {
+	Domains []Domain `json:"domains"`
+	Page    int      `json:"page"`
+	Pages   int      `json:"pages"`
+	PerPage int      `json:"perPage"`
+	Total   int      `json:"total"`
+}
+
+type Domain struct {
+	Name      string `json:"name"`
+	Country   string `json:"country,omitempty"`
+	Delegated bool   `json:"delegated"`
+	Master    bool   `json:"master"`
+	Mx        bool   `json:"mx"`
+	Verified  bool   `json:"verified"`
+}
@@ -0,0 +1,115 @@
+package yandex360api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultOAuthTokenURL is the token endpoint of Yandex OAuth.
+const DefaultOAuthTokenURL = "https://oauth.yandex.ru/token"
+
+// OAuthClient identifies the OAuth application the tokens were issued to.
+type OAuthClient struct {
+	// TokenURL is the token endpoint, DefaultOAuthTokenURL if nil
+	TokenURL     *url.URL
+	ClientID     string
+	ClientSecret string
+}
+
+// OAuthToken is an access token with the refresh token to renew it.
+type OAuthToken struct {
+	AccessToken  string
+	RefreshToken string
+	// Expiry is when the access token expires, zero if unknown
+	Expiry time.Time
+}
+
+// NeedsRefresh reports whether the access token is missing, of unknown
+// lifetime or expires within margin of now.
+func (t OAuthToken) NeedsRefresh(now time.Time, margin time.Duration) bool {
+	return t.AccessToken == "" || t.Expiry.IsZero() || !now.Add(margin).Before(t.Expiry)
+}
+
+// OAuthError is an error response of the OAuth token endpoint.
+type OAuthError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("oauth error: status %d, %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// RefreshOAuthToken exchanges refreshToken for a new access token of client.
+// The returned token keeps refreshToken unless the endpoint rotated it.
+func (a *ApiClient) RefreshOAuthToken(ctx context.Context, client OAuthClient, refreshToken string) (_ *OAuthToken, err error) {
+	ctx, span := tracer.Start(a.context(ctx), "yandex360api.RefreshOAuthToken", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("oauth.client_id", client.ClientID)))
+	defer func() { endSpan(span, err) }()
+
+	tokenURL := DefaultOAuthTokenURL
+	if client.TokenURL != nil {
+		tokenURL = client.TokenURL.String()
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {client.ClientID},
+		"client_secret": {client.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requested := time.Now()
+	r, err := a.doRequest(req, endpointOAuthToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: %v", RedactString(err.Error()))
+	}
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: read response: %w", err)
+	}
+
+	if r.StatusCode != http.StatusOK {
+		oauthErr := &OAuthError{StatusCode: r.StatusCode}
+		_ = json.Unmarshal(bdy, oauthErr)
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: %w", oauthErr)
+	}
+
+	var rsp oauthTokenResponse
+	if err := json.Unmarshal(bdy, &rsp); err != nil {
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: unmarshal response: %w", err)
+	}
+	if rsp.AccessToken == "" {
+		return nil, fmt.Errorf("failed to RefreshOAuthToken: no access token in the response")
+	}
+
+	token := &OAuthToken{AccessToken: rsp.AccessToken, RefreshToken: rsp.RefreshToken}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	if rsp.ExpiresIn > 0 {
+		token.Expiry = requested.Add(time.Duration(rsp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,80 @@
+package yandex360api
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_RefreshOAuthToken(t *testing.T) {
+	server := httptest.NewServer(NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler())
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	tokenURL := apiUrl.JoinPath("token")
+	oauth := OAuthClient{TokenURL: tokenURL, ClientID: "mockClientId", ClientSecret: "mockClientSecret"}
+	client := NewApiClient()
+
+	token, err := client.RefreshOAuthToken(context.TODO(), oauth, "mockRefreshToken=")
+	require.NoError(t, err)
+	require.NotEmpty(t, token.AccessToken)
+	require.NotEqual(t, "mockRefreshToken=", token.RefreshToken, "the refresh token is rotated")
+	require.WithinDuration(t, time.Now().Add(365*24*time.Hour), token.Expiry, time.Minute)
+	require.False(t, token.NeedsRefresh(time.Now(), 30*24*time.Hour))
+
+	// the new access token is accepted by the API
+	records, err := client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: token.AccessToken})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	// the old refresh token is not
+	_, err = client.RefreshOAuthToken(context.TODO(), oauth, "mockRefreshToken=")
+	var oauthErr *OAuthError
+	require.ErrorAs(t, err, &oauthErr)
+	require.Equal(t, "invalid_grant", oauthErr.Code)
+
+	// nor are other clients
+	_, err = client.RefreshOAuthToken(context.TODO(), OAuthClient{TokenURL: tokenURL, ClientID: "mockClientId", ClientSecret: "wrong"}, token.RefreshToken)
+	require.ErrorAs(t, err, &oauthErr)
+	require.Equal(t, "invalid_client", oauthErr.Code)
+	require.NotContains(t, err.Error(), token.RefreshToken)
+}
+
+func TestOAuthToken_NeedsRefresh(t *testing.T) {
+	now := time.Now()
+	require.True(t, OAuthToken{}.NeedsRefresh(now, time.Hour))
+	require.True(t, OAuthToken{AccessToken: "token"}.NeedsRefresh(now, time.Hour), "unknown expiry")
+	require.True(t, OAuthToken{AccessToken: "token", Expiry: now.Add(30 * time.Minute)}.NeedsRefresh(now, time.Hour))
+	require.False(t, OAuthToken{AccessToken: "token", Expiry: now.Add(2 * time.Hour)}.NeedsRefresh(now, time.Hour))
+}
+
+func TestApiClient_GetTokenInfo(t *testing.T) {
+	mock := NewYandex360ApiMock(Yandex360ApiMock_TestData)
+	server := httptest.NewServer(mock.Handler())
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	infoURL := apiUrl.JoinPath("tokeninfo")
+	client := NewApiClient()
+
+	info, err := client.GetTokenInfo(context.TODO(), infoURL, Yandex360ApiMock_TestData.authKey)
+	require.NoError(t, err)
+	require.Equal(t, "mockClientId", info.ClientID)
+	require.WithinDuration(t, time.Now().Add(365*24*time.Hour), info.Expiry, time.Minute)
+
+	mock.SetTokenExpiry(Yandex360ApiMock_TestData.authKey, time.Now().Add(48*time.Hour))
+	info, err = client.GetTokenInfo(context.TODO(), infoURL, Yandex360ApiMock_TestData.authKey)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(48*time.Hour), info.Expiry, time.Minute)
+
+	// expired tokens are rejected, by the API as well
+	mock.SetTokenExpiry(Yandex360ApiMock_TestData.authKey, time.Now().Add(-time.Second))
+	_, err = client.GetTokenInfo(context.TODO(), infoURL, Yandex360ApiMock_TestData.authKey)
+	var apiErr *ApiError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 401, apiErr.StatusCode)
+	_, err = client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	require.ErrorAs(t, err, &apiErr)
+}
@@ -0,0 +1,83 @@
+package yandex360api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RequestResult is the outcome of a Yandex 360 API request, after all its
+// retries.
+type RequestResult struct {
+	// Endpoint is the endpoint of the request, e.g. "dns.list"
+	Endpoint string
+	Method   string
+	// OrganizationId and Domain the request was made for, zero and empty if
+	// it was not made for one, e.g. an OAuth token refresh
+	OrganizationId int
+	Domain         string
+	// StatusCode of the response, zero if none was received
+	StatusCode int
+	// Err is the transport error if no response was received
+	Err      error
+	Duration time.Duration
+}
+
+// Failed reports whether the request got no response or an error status.
+func (r RequestResult) Failed() bool {
+	return r.Err != nil || r.StatusCode >= 400
+}
+
+// Unavailable reports whether the request failed because Yandex 360 could not
+// be reached or could not serve it: no response, 429 or a 5xx status.
+func (r RequestResult) Unavailable() bool {
+	return r.Err != nil || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+}
+
+// Observer is called with the result of every request of the client.
+type Observer func(RequestResult)
+
+// WithObserver calls observer with the result of every request, e.g. to
+// track the health of the API.
+func WithObserver(observer Observer) Option {
+	return func(a *ApiClient) {
+		a.observers = append(a.observers, observer)
+	}
+}
+
+type requestScopeKey struct{}
+
+// requestScope is the organization and domain of the requests of a call.
+type requestScope struct {
+	organizationId int
+	domain         string
+}
+
+// withRequestScope returns ctx carrying the organization and domain of
+// apiSettings for the observers.
+func withRequestScope(ctx context.Context, apiSettings *ApiSettings) context.Context {
+	return context.WithValue(ctx, requestScopeKey{}, requestScope{organizationId: apiSettings.OrganizationId, domain: apiSettings.Domain})
+}
+
+// observe calls the observers with the result of req.
+func (a *ApiClient) observe(req *http.Request, endpoint string, r *http.Response, err error, start time.Time) {
+	if len(a.observers) == 0 {
+		return
+	}
+
+	scope, _ := req.Context().Value(requestScopeKey{}).(requestScope)
+	result := RequestResult{
+		Endpoint:       endpoint,
+		Method:         req.Method,
+		OrganizationId: scope.organizationId,
+		Domain:         scope.domain,
+		Err:            err,
+		Duration:       time.Since(start),
+	}
+	if r != nil {
+		result.StatusCode = r.StatusCode
+	}
+	for _, observer := range a.observers {
+		observer(result)
+	}
+}
@@ -0,0 +1,42 @@
+package yandex360api
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_Observer(t *testing.T) {
+	server := httptest.NewServer(NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler())
+	apiUrl, _ := url.Parse(server.URL)
+
+	var results []RequestResult
+	client := NewApiClient(WithObserver(func(r RequestResult) { results = append(results, r) }), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, RequestResult{Endpoint: endpointDnsList, Method: "GET", OrganizationId: 1001, Domain: "example1.com", StatusCode: 200, Duration: results[0].Duration}, results[0])
+	require.False(t, results[0].Failed())
+
+	// a bad token fails, but Yandex 360 is available
+	_, err = client.GetDomains(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Token: "wrong"})
+	require.Error(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, endpointDomainList, results[1].Endpoint)
+	require.Equal(t, 401, results[1].StatusCode)
+	require.True(t, results[1].Failed())
+	require.False(t, results[1].Unavailable())
+
+	// no response at all
+	server.Close()
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	require.Error(t, err)
+	require.Len(t, results, 3)
+	require.Error(t, results[2].Err)
+	require.True(t, results[2].Unavailable())
+}
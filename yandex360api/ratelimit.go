@@ -0,0 +1,146 @@
+package yandex360api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// RateLimitKey selects what the requests are rate limited by.
+type RateLimitKey string
+
+const (
+	// RateLimitByToken shares one bucket between all requests made with the
+	// same OAuth token, which is how Yandex 360 enforces its quotas.
+	RateLimitByToken RateLimitKey = "token"
+	// RateLimitByOrganization shares one bucket per organization id.
+	RateLimitByOrganization RateLimitKey = "organization"
+)
+
+// RateLimit configures the client side token bucket rate limiting of the
+// requests to Yandex 360. Requests over the limit wait for a token until
+// their context is done.
+type RateLimit struct {
+	// QPS is the sustained number of requests per second, zero disables the
+	// rate limiting
+	QPS float64
+	// Burst is the number of requests that may be sent at once
+	Burst int
+	// Key selects the bucket of a request, RateLimitByToken if empty
+	Key RateLimitKey
+}
+
+// ParseRateLimitKey parses "token" or "organization".
+func ParseRateLimitKey(s string) (RateLimitKey, error) {
+	switch key := RateLimitKey(s); key {
+	case RateLimitByToken, RateLimitByOrganization:
+		return key, nil
+	case "":
+		return RateLimitByToken, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit key %q, must be %q or %q", s, RateLimitByToken, RateLimitByOrganization)
+	}
+}
+
+// rateLimiter holds one token bucket per key.
+type rateLimiter struct {
+	RateLimit
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	if limit.Key == "" {
+		limit.Key = RateLimitByToken
+	}
+	return &rateLimiter{RateLimit: limit, limiters: map[string]*rate.Limiter{}}
+}
+
+type rateLimitScopeKey struct{}
+
+// withRateLimit returns ctx whose requests are rate limited as made with
+// apiSettings, whose token must be resolved. doRequest waits for the rate limit
+// before every attempt, so retries are rate limited as well.
+func withRateLimit(ctx context.Context, apiSettings *ApiSettings) context.Context {
+	return context.WithValue(ctx, rateLimitScopeKey{}, apiSettings)
+}
+
+// waitFor blocks until the attempt of req to endpoint may be sent, if the
+// context of req is rate limited, see withRateLimit.
+func (l *rateLimiter) waitFor(req *http.Request, endpoint string) error {
+	apiSettings, _ := req.Context().Value(rateLimitScopeKey{}).(*ApiSettings)
+	if apiSettings == nil {
+		return nil
+	}
+	return l.Wait(req.Context(), apiSettings, endpoint)
+}
+
+// Wait blocks until the request of endpoint made with apiSettings may be
+// sent, or returns the error of ctx if it is done first.
+func (l *rateLimiter) Wait(ctx context.Context, apiSettings *ApiSettings, endpoint string) error {
+	if l == nil || l.QPS <= 0 {
+		return nil
+	}
+
+	reservation := l.limiter(apiSettings).Reserve()
+	delay := reservation.Delay()
+	apiRateLimitWait.WithLabelValues(endpoint).Observe(delay.Seconds())
+	if delay == 0 {
+		return nil
+	}
+
+	klog.FromContext(ctx).V(4).Info("Waiting for the Yandex 360 API rate limit", "endpoint", endpoint, "delay", delay)
+
+	organization := strconv.Itoa(apiSettings.OrganizationId)
+	apiRateLimitQueueDepth.WithLabelValues(organization).Inc()
+	defer apiRateLimitQueueDepth.WithLabelValues(organization).Dec()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the token back to the requests still waiting
+		reservation.Cancel()
+		return fmt.Errorf("waiting for the rate limit: %w", ctx.Err())
+	}
+}
+
+func (l *rateLimiter) limiter(apiSettings *ApiSettings) *rate.Limiter {
+	var key string
+	switch l.Key {
+	case RateLimitByOrganization:
+		key = apiSettings.ApiUrl.Host + "/" + strconv.Itoa(apiSettings.OrganizationId)
+	default:
+		key = apiSettings.ApiUrl.Host + "/" + tokenHash(apiSettings.Token)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.QPS), l.Burst)
+		l.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// tokenHash identifies token in keys of long living maps, so the token itself
+// is not kept in memory longer than needed.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
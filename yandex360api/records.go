@@ -0,0 +1,246 @@
+package yandex360api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Types of the DNS records supported by Yandex 360.
+const (
+	RecordTypeA     = "A"
+	RecordTypeAAAA  = "AAAA"
+	RecordTypeCNAME = "CNAME"
+	RecordTypeMX    = "MX"
+	RecordTypeSRV   = "SRV"
+	RecordTypeCAA   = "CAA"
+	RecordTypeNS    = "NS"
+	RecordTypeTXT   = TXTKey
+)
+
+// ErrInvalidRecord is wrapped by the errors of Validate.
+var ErrInvalidRecord = errors.New("invalid DNS record")
+
+// ErrRecordNotFound is returned when a record looked up by its id does not
+// exist.
+var ErrRecordNotFound = errors.New("DNS record not found")
+
+// NewARecord returns an A record of name pointing to the IPv4 address.
+func NewARecord(name string, address string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeA, Name: name, Address: address, TTL: ttl}
+}
+
+// NewAAAARecord returns an AAAA record of name pointing to the IPv6 address.
+func NewAAAARecord(name string, address string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeAAAA, Name: name, Address: address, TTL: ttl}
+}
+
+// NewCNAMERecord returns a CNAME record making name an alias of target.
+func NewCNAMERecord(name string, target string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeCNAME, Name: name, Target: target, TTL: ttl}
+}
+
+// NewMXRecord returns an MX record delivering the mail of name to exchange.
+func NewMXRecord(name string, exchange string, preference int, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeMX, Name: name, Exchange: exchange, Preference: preference, TTL: ttl}
+}
+
+// NewSRVRecord returns an SRV record of the service name, e.g. "_sip._tcp",
+// served by target on port.
+func NewSRVRecord(name string, target string, priority int, weight int, port int, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeSRV, Name: name, Target: target, Priority: priority, Weight: weight, Port: port, TTL: ttl}
+}
+
+// NewCAARecord returns a CAA record of name, e.g. with tag "issue" and value
+// "letsencrypt.org".
+func NewCAARecord(name string, flag int, tag string, value string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeCAA, Name: name, Flag: flag, Tag: tag, Value: value, TTL: ttl}
+}
+
+// NewNSRecord returns an NS record delegating name to the name server target.
+func NewNSRecord(name string, target string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeNS, Name: name, Target: target, TTL: ttl}
+}
+
+// NewTXTRecord returns a TXT record of name with text.
+func NewTXTRecord(name string, text string, ttl int) DnsRecord {
+	return DnsRecord{Type: RecordTypeTXT, Name: name, Text: text, TTL: ttl}
+}
+
+// Validate checks that r has a name, a positive TTL and the fields its type
+// requires, with values in range. The errors wrap ErrInvalidRecord.
+func (r DnsRecord) Validate() error {
+	if err := r.validate(); err != nil {
+		return fmt.Errorf("%w: %s %s: %v", ErrInvalidRecord, r.Type, r.Name, err)
+	}
+	return nil
+}
+
+func (r DnsRecord) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if strings.ContainsAny(r.Name, " \t") {
+		return fmt.Errorf("name %q contains whitespace", r.Name)
+	}
+	if r.TTL <= 0 {
+		return fmt.Errorf("ttl must be positive, got %d", r.TTL)
+	}
+
+	switch r.Type {
+	case RecordTypeA:
+		if ip := net.ParseIP(r.Address); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("address %q is not an IPv4 address", r.Address)
+		}
+	case RecordTypeAAAA:
+		if ip := net.ParseIP(r.Address); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("address %q is not an IPv6 address", r.Address)
+		}
+	case RecordTypeCNAME, RecordTypeNS:
+		return validateHostname("target", r.Target)
+	case RecordTypeMX:
+		if err := validateHostname("exchange", r.Exchange); err != nil {
+			return err
+		}
+		return validateRange("preference", r.Preference, 0, 65535)
+	case RecordTypeSRV:
+		if err := validateHostname("target", r.Target); err != nil {
+			return err
+		}
+		if err := validateRange("priority", r.Priority, 0, 65535); err != nil {
+			return err
+		}
+		if err := validateRange("weight", r.Weight, 0, 65535); err != nil {
+			return err
+		}
+		return validateRange("port", r.Port, 1, 65535)
+	case RecordTypeCAA:
+		if err := validateRange("flag", r.Flag, 0, 255); err != nil {
+			return err
+		}
+		switch r.Tag {
+		case "issue", "issuewild", "iodef":
+		default:
+			return fmt.Errorf(`tag must be "issue", "issuewild" or "iodef", got %q`, r.Tag)
+		}
+		if r.Value == "" {
+			return errors.New("value is required")
+		}
+	case RecordTypeTXT:
+		if r.Text == "" {
+			return errors.New("text is required")
+		}
+	default:
+		return fmt.Errorf("unsupported record type %q", r.Type)
+	}
+	return nil
+}
+
+func validateHostname(field string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if strings.ContainsAny(value, " \t/") {
+		return fmt.Errorf("%s %q is not a host name", field, value)
+	}
+	return nil
+}
+
+func validateRange(field string, value int, from int, to int) error {
+	if value < from || value > to {
+		return fmt.Errorf("%s must be between %d and %d, got %d", field, from, to, value)
+	}
+	return nil
+}
+
+// Data returns the value of r in the field its type uses: the address, the
+// target, the exchange, the text or the CAA value.
+func (r DnsRecord) Data() string {
+	switch r.Type {
+	case RecordTypeA, RecordTypeAAAA:
+		return r.Address
+	case RecordTypeCNAME, RecordTypeNS, RecordTypeSRV:
+		return r.Target
+	case RecordTypeMX:
+		return r.Exchange
+	case RecordTypeTXT:
+		return r.Text
+	case RecordTypeCAA:
+		return r.Value
+	}
+	return ""
+}
+
+// RecordQuery selects DNS records. Empty fields match any record.
+type RecordQuery struct {
+	// Name is the name of the record relative to the domain, e.g. "@" or
+	// "_acme-challenge.www", matched case-insensitively
+	Name string
+	Type string
+	// Value is compared with Data, the quotes around TXT values are ignored
+	Value string
+}
+
+// Matches returns whether r is selected by q.
+func (q RecordQuery) Matches(r DnsRecord) bool {
+	if q.Name != "" && !strings.EqualFold(q.Name, r.Name) {
+		return false
+	}
+	if q.Type != "" && !strings.EqualFold(q.Type, r.Type) {
+		return false
+	}
+	if q.Value != "" && strings.Trim(q.Value, `"`) != strings.Trim(r.Data(), `"`) {
+		return false
+	}
+	return true
+}
+
+// FilterDnsRecords returns the records selected by q.
+func FilterDnsRecords(records []DnsRecord, q RecordQuery) []DnsRecord {
+	var selected []DnsRecord
+	for _, r := range records {
+		if q.Matches(r) {
+			selected = append(selected, r)
+		}
+	}
+	return selected
+}
+
+// String returns r in the presentation format of zone files, e.g.
+// `@ 300 IN MX 10 mx.yandex.net.`.
+func (r DnsRecord) String() string {
+	return fmt.Sprintf("%s %d IN %s %s", r.Name, r.TTL, r.Type, r.Rdata())
+}
+
+// Rdata returns the data of r in the presentation format of its type, e.g.
+// "10 mx.yandex.net." for an MX record.
+func (r DnsRecord) Rdata() string {
+	switch r.Type {
+	case RecordTypeMX:
+		return fmt.Sprintf("%d %s", r.Preference, r.Exchange)
+	case RecordTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case RecordTypeCAA:
+		return fmt.Sprintf("%d %s %q", r.Flag, r.Tag, r.Value)
+	case RecordTypeTXT:
+		return strconv.Quote(strings.Trim(r.Text, `"`))
+	}
+	return r.Data()
+}
+
+// normalized returns r without its id, with the quotes around TXT values and
+// the trailing dots of host names removed, host names in lower case and IP
+// addresses in canonical form, for comparing records.
+func (r DnsRecord) normalized() DnsRecord {
+	r.RecordID = 0
+	r.Name = strings.ToLower(r.Name)
+	r.Text = strings.Trim(r.Text, `"`)
+	r.Target = strings.ToLower(strings.TrimSuffix(r.Target, "."))
+	r.Exchange = strings.ToLower(strings.TrimSuffix(r.Exchange, "."))
+	if ip := net.ParseIP(r.Address); ip != nil {
+		r.Address = ip.String()
+	}
+	return r
+}
@@ -0,0 +1,56 @@
+package yandex360api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDnsRecord_Validate(t *testing.T) {
+	valid := []DnsRecord{
+		NewARecord("@", "1.2.3.4", 300),
+		NewAAAARecord("www", "2001:db8::1", 300),
+		NewCNAMERecord("mail", "domain.mail.yandex.net.", 300),
+		NewMXRecord("@", "mx.yandex.net.", 10, 300),
+		NewSRVRecord("_sip._tcp", "sip.example.com.", 10, 20, 5060, 300),
+		NewCAARecord("@", 0, "issue", "letsencrypt.org", 300),
+		NewNSRecord("sub", "ns1.example.com.", 300),
+		NewTXTRecord("_acme-challenge", "key", 300),
+	}
+	for _, r := range valid {
+		require.NoError(t, r.Validate(), r.Type)
+	}
+
+	for want, r := range map[string]DnsRecord{
+		"name is required":                                          NewTXTRecord("", "key", 300),
+		"ttl must be positive, got 0":                               NewTXTRecord("_acme-challenge", "key", 0),
+		`address "::1" is not an IPv4 address`:                      NewARecord("@", "::1", 300),
+		`address "1.2.3.4" is not an IPv6 address`:                  NewAAAARecord("@", "1.2.3.4", 300),
+		"target is required":                                        NewCNAMERecord("www", "", 300),
+		"preference must be between 0 and 65535":                    NewMXRecord("@", "mx.yandex.net.", 70000, 300),
+		"port must be between 1 and 65535, got 0":                   NewSRVRecord("_sip._tcp", "sip.example.com.", 10, 20, 0, 300),
+		`tag must be "issue", "issuewild" or "iodef", got "policy"`: NewCAARecord("@", 0, "policy", "x", 300),
+		"text is required":                                          NewTXTRecord("_acme-challenge", "", 300),
+		`unsupported record type "PTR"`:                             {Type: "PTR", Name: "1", TTL: 300},
+	} {
+		err := r.Validate()
+		require.ErrorIs(t, err, ErrInvalidRecord, want)
+		require.ErrorContains(t, err, want)
+	}
+}
+
+func TestRecordQuery_Matches(t *testing.T) {
+	records := []DnsRecord{
+		NewARecord("@", "1.2.3.4", 300),
+		NewTXTRecord("_acme-challenge", `"key"`, 300),
+		NewTXTRecord("_acme-challenge", "other", 300),
+		NewMXRecord("@", "mx.yandex.net.", 10, 300),
+	}
+
+	require.Len(t, FilterDnsRecords(records, RecordQuery{}), 4)
+	require.Len(t, FilterDnsRecords(records, RecordQuery{Name: "@"}), 2)
+	require.Len(t, FilterDnsRecords(records, RecordQuery{Name: "_ACME-challenge", Type: "txt"}), 2)
+	require.Equal(t, []DnsRecord{records[1]}, FilterDnsRecords(records, RecordQuery{Type: RecordTypeTXT, Value: "key"}))
+	require.Equal(t, []DnsRecord{records[3]}, FilterDnsRecords(records, RecordQuery{Value: "mx.yandex.net."}))
+	require.Empty(t, FilterDnsRecords(records, RecordQuery{Name: "www"}))
+}
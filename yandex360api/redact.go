@@ -0,0 +1,167 @@
+package yandex360api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-logr/logr"
+)
+
+// Redacted replaces secrets in everything that may end up in a log.
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are never logged with their value.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	// authorizationPattern matches credentials of an Authorization header,
+	// e.g. "OAuth y0_AgAAAA..."
+	authorizationPattern = regexp.MustCompile(`(?i)\b(OAuth|Bearer|Basic)\s+[A-Za-z0-9._~+/=-]+`)
+	// secretFieldPattern matches secrets in JSON documents and form or query
+	// parameters, e.g. `"access_token": "..."` or "refresh_token=..."
+	secretFieldPattern = regexp.MustCompile(`(?i)("?\b(?:access_token|refresh_token|id_token|client_secret|password|token)"?\s*[:=]\s*"?)[^"&\s,}]+`)
+	// userinfoPattern matches the user and password of a URL, e.g. of a
+	// proxy in a *url.Error
+	userinfoPattern = regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://)[^/\s@"]+@`)
+)
+
+// RedactString removes OAuth tokens and other credentials from s.
+func RedactString(s string) string {
+	s = authorizationPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = userinfoPattern.ReplaceAllString(s, "${1}"+Redacted+"@")
+	return secretFieldPattern.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// RedactLogger returns logger removing credentials with RedactString from
+// the messages, the string and error values and the errors it logs, so the
+// call sites need not redact them.
+func RedactLogger(logger logr.Logger) logr.Logger {
+	sink := logger.GetSink()
+	if sink == nil {
+		return logger
+	}
+	if _, ok := sink.(redactingSink); ok {
+		return logger
+	}
+	// the sink reports the caller of the logger, one frame up
+	if withCallDepth, ok := sink.(logr.CallDepthLogSink); ok {
+		sink = withCallDepth.WithCallDepth(1)
+	}
+	return logger.WithSink(redactingSink{sink})
+}
+
+// redactingSink is the logr.LogSink of RedactLogger.
+type redactingSink struct {
+	sink logr.LogSink
+}
+
+func (s redactingSink) Init(info logr.RuntimeInfo) {
+	s.sink.Init(info)
+}
+
+func (s redactingSink) Enabled(level int) bool {
+	return s.sink.Enabled(level)
+}
+
+func (s redactingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink.Info(level, RedactString(msg), redactValues(keysAndValues)...)
+}
+
+func (s redactingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(redactError(err), RedactString(msg), redactValues(keysAndValues)...)
+}
+
+func (s redactingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return redactingSink{s.sink.WithValues(redactValues(keysAndValues)...)}
+}
+
+func (s redactingSink) WithName(name string) logr.LogSink {
+	return redactingSink{s.sink.WithName(name)}
+}
+
+func (s redactingSink) WithCallDepth(depth int) logr.LogSink {
+	if withCallDepth, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return redactingSink{withCallDepth.WithCallDepth(depth)}
+	}
+	return s
+}
+
+// redactValues returns keysAndValues with the credentials removed from the
+// strings and errors.
+func redactValues(keysAndValues []interface{}) []interface{} {
+	redacted := make([]interface{}, len(keysAndValues))
+	for i, value := range keysAndValues {
+		switch value := value.(type) {
+		case string:
+			redacted[i] = RedactString(value)
+		case error:
+			redacted[i] = redactError(value)
+		default:
+			redacted[i] = value
+		}
+	}
+	return redacted
+}
+
+// redactError returns err, or an error with its message redacted if it
+// contains credentials.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if redacted := RedactString(msg); redacted != msg {
+		return errors.New(redacted)
+	}
+	return err
+}
+
+// RedactHeader returns a copy of h safe for logging.
+func RedactHeader(h http.Header) http.Header {
+	redacted := h.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// MarshalLog implements logr.Marshaler, logging the settings without the
+// token.
+func (s ApiSettings) MarshalLog() interface{} {
+	apiUrl := ""
+	if s.ApiUrl != nil {
+		apiUrl = s.ApiUrl.Redacted()
+	}
+	return struct {
+		ApiUrl         string `json:"apiUrl"`
+		Token          string `json:"token"`
+		OrganizationId int    `json:"organizationId"`
+		Domain         string `json:"domain,omitempty"`
+		TTL            int    `json:"ttl,omitempty"`
+	}{apiUrl, redactToken(s.Token), s.OrganizationId, s.Domain, s.TTL}
+}
+
+// String implements fmt.Stringer, without the token.
+func (s ApiSettings) String() string {
+	apiUrl := ""
+	if s.ApiUrl != nil {
+		apiUrl = s.ApiUrl.Redacted()
+	}
+	return fmt.Sprintf("{ApiUrl:%s Token:%s OrganizationId:%d Domain:%s TTL:%d}", apiUrl, redactToken(s.Token), s.OrganizationId, s.Domain, s.TTL)
+}
+
+// GoString implements fmt.GoStringer for %#v, without the token.
+func (s ApiSettings) GoString() string {
+	return "yandex360api.ApiSettings" + s.String()
+}
+
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	return Redacted
+}
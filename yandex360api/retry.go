@@ -0,0 +1,89 @@
+package yandex360api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides how often failed requests are sent again and how long
+// to wait before. Which requests are retried depends on their method: listings
+// after transport errors and transient server errors, deletions only after
+// 429 Too Many Requests, creations never.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent at most, 1
+	// disables the retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for every
+	// following one
+	BaseDelay time.Duration
+	// MaxDelay caps the delay, including the one asked for by Retry-After
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of clients created without
+// WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// retryReason returns why the request should be sent again after attempt
+// failed with r or err, or "" if it should not. Listings are retried after
+// transport errors and transient server errors. Deletions are only retried
+// when the server refused them with 429, as a lost response to a processed
+// deletion would turn into a 404 on retry. Creations are never retried, they
+// could create the record twice.
+func (p RetryPolicy) retryReason(req *http.Request, attempt int, r *http.Response, err error) string {
+	if attempt >= p.MaxAttempts || req.Context().Err() != nil {
+		return ""
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if err != nil {
+			return "error"
+		}
+		switch r.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return strconv.Itoa(r.StatusCode)
+		}
+	case http.MethodDelete:
+		if err == nil && r.StatusCode == http.StatusTooManyRequests {
+			return strconv.Itoa(r.StatusCode)
+		}
+	}
+	return ""
+}
+
+// delay returns how long to wait before sending the request again after
+// attempt, honouring the Retry-After seconds of r if any.
+func (p RetryPolicy) delay(attempt int, r *http.Response) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if r != nil {
+		if seconds, err := strconv.Atoi(r.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// sleep waits for delay, or returns the error of ctx if it is done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discard drains and closes the body of a response that is not used.
+func discard(r *http.Response) {
+	if r == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(r.Body, 64<<10))
+	r.Body.Close()
+}
@@ -0,0 +1,280 @@
+package yandex360api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// ChangeAction is what a Change does to a DNS record.
+type ChangeAction string
+
+const (
+	ChangeCreate ChangeAction = "create"
+	ChangeUpdate ChangeAction = "update"
+	ChangeDelete ChangeAction = "delete"
+)
+
+// Change is a step of a Plan. Current is the live record updated or deleted,
+// Desired the record created or the new state of the updated one.
+type Change struct {
+	Action  ChangeAction `json:"action"`
+	Current *DnsRecord   `json:"current,omitempty"`
+	Desired *DnsRecord   `json:"desired,omitempty"`
+}
+
+// Plan is the list of changes bringing the live records of a domain to a
+// desired state, deletions first, then updates and creations, so e.g. a CNAME
+// replacing another record does not clash with it.
+type Plan struct {
+	Domain  string   `json:"domain"`
+	Changes []Change `json:"changes"`
+}
+
+// SyncOptions configure planning and applying the records of a domain.
+type SyncOptions struct {
+	// Owned returns whether a live record is managed by the caller. Records
+	// not owned are never updated or deleted; nil owns all records.
+	Owned func(DnsRecord) bool
+	// DryRun computes the plan without applying it
+	DryRun bool
+}
+
+// OwnedBy returns an Owned func owning the records selected by any of the
+// queries, e.g. the MX and the SPF records of a domain.
+func OwnedBy(queries ...RecordQuery) func(DnsRecord) bool {
+	return func(r DnsRecord) bool {
+		for _, q := range queries {
+			if q.Matches(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (o SyncOptions) owns(r DnsRecord) bool {
+	return o.Owned == nil || o.Owned(r)
+}
+
+// recordSetKey groups the records of the same name and type.
+type recordSetKey struct {
+	name       string
+	recordType string
+}
+
+func keyOf(r DnsRecord) recordSetKey {
+	return recordSetKey{name: strings.ToLower(r.Name), recordType: strings.ToUpper(r.Type)}
+}
+
+// ComputePlan returns the changes bringing the live records of domain to
+// desired. Within a name and type, desired records equal to a live one are
+// kept, the remaining ones replace the remaining owned live records by
+// updates and the rest is created or deleted. Live records not owned are left
+// alone, a desired record equal to one of them is not created again.
+func ComputePlan(domain string, live []DnsRecord, desired []DnsRecord, opts SyncOptions) (*Plan, error) {
+	seen := map[DnsRecord]bool{}
+	wanted := map[recordSetKey][]DnsRecord{}
+	for _, r := range desired {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[r.normalized()] {
+			return nil, fmt.Errorf("duplicate desired record %s", r)
+		}
+		seen[r.normalized()] = true
+		wanted[keyOf(r)] = append(wanted[keyOf(r)], r)
+	}
+
+	present := map[DnsRecord]bool{}
+	owned := map[recordSetKey][]DnsRecord{}
+	for _, r := range live {
+		if !opts.owns(r) {
+			present[r.normalized()] = true
+			continue
+		}
+		owned[keyOf(r)] = append(owned[keyOf(r)], r)
+	}
+
+	plan := &Plan{Domain: domain}
+	keys := map[recordSetKey]bool{}
+	for k := range wanted {
+		keys[k] = true
+	}
+	for k := range owned {
+		keys[k] = true
+	}
+	for k := range keys {
+		var creates []DnsRecord
+		current := owned[k]
+		for _, r := range wanted[k] {
+			i := indexOfRecord(current, r)
+			switch {
+			case i != -1:
+				current = append(current[:i:i], current[i+1:]...)
+			case !present[r.normalized()]:
+				creates = append(creates, r)
+			}
+		}
+		sortRecords(current)
+		sortRecords(creates)
+
+		for len(current) > 0 && len(creates) > 0 {
+			from, to := current[0], creates[0]
+			to.RecordID = from.RecordID
+			plan.Changes = append(plan.Changes, Change{Action: ChangeUpdate, Current: &from, Desired: &to})
+			current, creates = current[1:], creates[1:]
+		}
+		for _, r := range current {
+			r := r
+			plan.Changes = append(plan.Changes, Change{Action: ChangeDelete, Current: &r})
+		}
+		for _, r := range creates {
+			r := r
+			plan.Changes = append(plan.Changes, Change{Action: ChangeCreate, Desired: &r})
+		}
+	}
+
+	order := map[ChangeAction]int{ChangeDelete: 0, ChangeUpdate: 1, ChangeCreate: 2}
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if order[a.Action] != order[b.Action] {
+			return order[a.Action] < order[b.Action]
+		}
+		return lessRecord(a.record(), b.record())
+	})
+	return plan, nil
+}
+
+// record returns the record a change is shown by.
+func (c Change) record() DnsRecord {
+	if c.Current != nil {
+		return *c.Current
+	}
+	return *c.Desired
+}
+
+func indexOfRecord(records []DnsRecord, r DnsRecord) int {
+	for i, candidate := range records {
+		if candidate.normalized() == r.normalized() {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortRecords(records []DnsRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return lessRecord(records[i], records[j]) })
+}
+
+func lessRecord(a DnsRecord, b DnsRecord) bool {
+	if ka, kb := keyOf(a), keyOf(b); ka != kb {
+		if ka.name != kb.name {
+			return ka.name < kb.name
+		}
+		return ka.recordType < kb.recordType
+	}
+	return a.String() < b.String()
+}
+
+// Empty returns whether the live records are already in the desired state.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Count returns the number of changes with action.
+func (p *Plan) Count(action ChangeAction) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Diff returns the plan for humans, one record per line prefixed with "+" for
+// creations, "-" for deletions and "~" for updates, followed by the new state
+// of the record, and a summary line.
+func (p *Plan) Diff() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		switch c.Action {
+		case ChangeCreate:
+			fmt.Fprintf(&b, "+ %s\n", c.Desired)
+		case ChangeDelete:
+			fmt.Fprintf(&b, "- %s\n", c.Current)
+		case ChangeUpdate:
+			fmt.Fprintf(&b, "~ %s\n  -> %s\n", c.Current, c.Desired)
+		}
+	}
+	if p.Empty() {
+		fmt.Fprintf(&b, "%s: no changes\n", p.Domain)
+	} else {
+		fmt.Fprintf(&b, "%s: %d to create, %d to update, %d to delete\n", p.Domain, p.Count(ChangeCreate), p.Count(ChangeUpdate), p.Count(ChangeDelete))
+	}
+	return b.String()
+}
+
+// PlanDnsRecords returns the plan bringing the live records of the domain to
+// desired, see ComputePlan.
+func (a *ApiClient) PlanDnsRecords(ctx context.Context, apiSettings *ApiSettings, desired []DnsRecord, opts SyncOptions) (*Plan, error) {
+	live, err := a.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to PlanDnsRecords: %w", err)
+	}
+	plan, err := ComputePlan(apiSettings.Domain, live, desired, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to PlanDnsRecords: %w", err)
+	}
+	return plan, nil
+}
+
+// ApplyPlan applies the changes of plan in order and stops at the first one
+// failing. Changes of records not owned according to opts are refused before
+// anything is applied. With DryRun nothing is applied.
+func (a *ApiClient) ApplyPlan(ctx context.Context, apiSettings *ApiSettings, plan *Plan, opts SyncOptions) error {
+	for _, c := range plan.Changes {
+		if c.Current != nil && !opts.owns(*c.Current) {
+			return fmt.Errorf("failed to ApplyPlan: refusing to %s %s, the record is not owned", c.Action, c.Current)
+		}
+	}
+
+	logger := klog.FromContext(a.context(ctx))
+	for i, c := range plan.Changes {
+		if opts.DryRun {
+			logger.V(2).Info("Dry run, not applying change", "domain", plan.Domain, "action", c.Action, "record", c.record().String())
+			continue
+		}
+
+		var err error
+		switch c.Action {
+		case ChangeCreate:
+			_, err = a.AddDnsRecord(ctx, apiSettings, *c.Desired)
+		case ChangeUpdate:
+			_, err = a.UpdateDnsRecord(ctx, apiSettings, *c.Desired)
+		case ChangeDelete:
+			err = a.DeleteDnsRecord(ctx, apiSettings, c.Current.RecordID)
+		default:
+			err = fmt.Errorf("unknown action %q", c.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to ApplyPlan: %s %s after %d of %d changes: %w", c.Action, c.record(), i, len(plan.Changes), err)
+		}
+		logger.V(2).Info("Applied change", "domain", plan.Domain, "action", c.Action, "record", c.record().String())
+	}
+	return nil
+}
+
+// SyncDnsRecords brings the live records of the domain to desired and returns
+// the plan applied, or with DryRun the plan that would be applied.
+func (a *ApiClient) SyncDnsRecords(ctx context.Context, apiSettings *ApiSettings, desired []DnsRecord, opts SyncOptions) (*Plan, error) {
+	plan, err := a.PlanDnsRecords(ctx, apiSettings, desired, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan, a.ApplyPlan(ctx, apiSettings, plan, opts)
+}
@@ -0,0 +1,112 @@
+package yandex360api
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputePlan(t *testing.T) {
+	live := []DnsRecord{
+		{RecordID: 1, Name: "@", Type: "A", TTL: 300, Address: "1.2.3.4"},
+		{RecordID: 2, Name: "@", Type: "MX", TTL: 300, Exchange: "mx.old.net.", Preference: 10},
+		{RecordID: 3, Name: "mail", Type: "CNAME", TTL: 300, Target: "Domain.Mail.Yandex.NET"},
+		{RecordID: 4, Name: "@", Type: "TXT", TTL: 300, Text: `"v=spf1 redirect=_spf.yandex.net"`},
+		{RecordID: 5, Name: "old", Type: "TXT", TTL: 300, Text: "obsolete"},
+		{RecordID: 6, Name: "www", Type: "AAAA", TTL: 300, Address: "2001:DB8:0:0::1"},
+	}
+	desired := []DnsRecord{
+		NewARecord("@", "1.2.3.4", 300),
+		NewMXRecord("@", "mx.yandex.net.", 10, 300),
+		NewCNAMERecord("mail", "domain.mail.yandex.net.", 300),
+		NewTXTRecord("@", "v=spf1 redirect=_spf.yandex.net", 600),
+		NewTXTRecord("mail._domainkey", "v=DKIM1; k=rsa; p=key", 300),
+		NewAAAARecord("www", "2001:db8::1", 300),
+	}
+
+	plan, err := ComputePlan("example.com", live, desired, SyncOptions{})
+	require.NoError(t, err)
+	require.Equal(t, `- old 300 IN TXT "obsolete"
+~ @ 300 IN MX 10 mx.old.net.
+  -> @ 300 IN MX 10 mx.yandex.net.
+~ @ 300 IN TXT "v=spf1 redirect=_spf.yandex.net"
+  -> @ 600 IN TXT "v=spf1 redirect=_spf.yandex.net"
++ mail._domainkey 300 IN TXT "v=DKIM1; k=rsa; p=key"
+example.com: 1 to create, 2 to update, 1 to delete
+`, plan.Diff())
+	require.Equal(t, 2, plan.Changes[1].Desired.RecordID, "updates keep the record id")
+
+	// records not owned are left alone and not created again
+	plan, err = ComputePlan("example.com", live, desired, SyncOptions{Owned: OwnedBy(RecordQuery{Type: RecordTypeMX}, RecordQuery{Name: "mail._domainkey"})})
+	require.NoError(t, err)
+	require.Equal(t, `~ @ 300 IN MX 10 mx.old.net.
+  -> @ 300 IN MX 10 mx.yandex.net.
++ @ 600 IN TXT "v=spf1 redirect=_spf.yandex.net"
++ mail._domainkey 300 IN TXT "v=DKIM1; k=rsa; p=key"
+example.com: 2 to create, 1 to update, 0 to delete
+`, plan.Diff())
+
+	plan, err = ComputePlan("example.com", live, live, SyncOptions{})
+	require.NoError(t, err)
+	require.True(t, plan.Empty())
+	require.Equal(t, "example.com: no changes\n", plan.Diff())
+
+	_, err = ComputePlan("example.com", live, append(desired, desired[0]), SyncOptions{})
+	require.ErrorContains(t, err, "duplicate desired record @ 300 IN A 1.2.3.4")
+	_, err = ComputePlan("example.com", live, []DnsRecord{NewARecord("@", "localhost", 300)}, SyncOptions{})
+	require.ErrorIs(t, err, ErrInvalidRecord)
+}
+
+func TestApiClient_SyncDnsRecords(t *testing.T) {
+	server := httptest.NewServer(NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler())
+	t.Cleanup(server.Close)
+	apiUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client := NewApiClient()
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	// the mail records are managed, the others are left as they are
+	opts := SyncOptions{Owned: OwnedBy(RecordQuery{Type: RecordTypeMX}, RecordQuery{Name: "mail"}), DryRun: true}
+	desired := []DnsRecord{
+		NewMXRecord("@", "mx.yandex.net.", 10, 21600),
+		NewCNAMERecord("mail", "domain.mail.yandex.net.", 21600),
+	}
+	before, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	plan, err := client.SyncDnsRecords(context.TODO(), settings, desired, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, plan.Count(ChangeCreate))
+	after, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Equal(t, before, after, "a dry run changes nothing")
+
+	opts.DryRun = false
+	_, err = client.SyncDnsRecords(context.TODO(), settings, desired, opts)
+	require.NoError(t, err)
+	plan, err = client.PlanDnsRecords(context.TODO(), settings, desired, opts)
+	require.NoError(t, err)
+	require.True(t, plan.Empty(), plan.Diff())
+
+	desired[0].Preference = 20
+	plan, err = client.SyncDnsRecords(context.TODO(), settings, desired[:1], opts)
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.Count(ChangeUpdate))
+	require.Equal(t, 1, plan.Count(ChangeDelete))
+	after, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, after, len(before)+1)
+	require.Len(t, FilterDnsRecords(after, RecordQuery{Type: RecordTypeMX}), 1)
+	require.Equal(t, 20, FilterDnsRecords(after, RecordQuery{Type: RecordTypeMX})[0].Preference)
+	require.Len(t, FilterDnsRecords(after, RecordQuery{Name: "cname1"}), 1)
+
+	// a plan touching records not owned is refused
+	plan, err = client.PlanDnsRecords(context.TODO(), settings, nil, SyncOptions{})
+	require.NoError(t, err)
+	require.ErrorContains(t, client.ApplyPlan(context.TODO(), settings, plan, opts), "is not owned")
+	after, err = client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, after, len(before)+1)
+}
@@ -0,0 +1,125 @@
+package yandex360api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the OAuth token sent in the Authorization header of
+// the requests. It is asked for every request, implementations cache the
+// token themselves if getting it is expensive.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token calls f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// ErrNoToken is returned by the requests when neither the ApiSettings nor the
+// client supply a token.
+var ErrNoToken = errors.New("no Yandex 360 OAuth token configured")
+
+// StaticTokenSource always returns token.
+func StaticTokenSource(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// EnvTokenSource returns the value of the environment variable name, read on
+// every request.
+func EnvTokenSource(name string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		token := strings.TrimSpace(os.Getenv(name))
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is empty: %w", name, ErrNoToken)
+		}
+		return token, nil
+	})
+}
+
+// FileTokenSource returns the content of the file at path, e.g. a mounted
+// Secret. The file is read again when its modification time changes, so a
+// rotated token is picked up without a restart.
+func FileTokenSource(path string) TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+func (s *fileTokenSource) Token(context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty: %w", s.path, ErrNoToken)
+	}
+	s.token = token
+	s.modTime = info.ModTime()
+	return token, nil
+}
+
+// WithTokenSource supplies the token of the requests whose ApiSettings have
+// neither a Token nor a TokenSource.
+func WithTokenSource(source TokenSource) Option {
+	return func(a *ApiClient) {
+		a.tokens = source
+	}
+}
+
+// resolveToken returns apiSettings with the token filled in from its
+// TokenSource or the one of the client, unless it has a Token already.
+func (a *ApiClient) resolveToken(ctx context.Context, apiSettings *ApiSettings) (*ApiSettings, error) {
+	if apiSettings.Token != "" {
+		return apiSettings, nil
+	}
+
+	source := apiSettings.TokenSource
+	if source == nil {
+		source = a.tokens
+	}
+	if source == nil {
+		return nil, ErrNoToken
+	}
+
+	token, err := source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the OAuth token: %w", err)
+	}
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	resolved := *apiSettings
+	resolved.Token = token
+	return &resolved, nil
+}
@@ -0,0 +1,74 @@
+package yandex360api
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTokenSource_Reloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+	source := FileTokenSource(path)
+
+	token, err := source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "first", token)
+
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+	// make sure the modification time changes on coarse file systems
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	token, err = source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "second", token)
+
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	require.NoError(t, os.Chtimes(path, later.Add(time.Second), later.Add(time.Second)))
+	_, err = source.Token(context.TODO())
+	require.ErrorIs(t, err, ErrNoToken)
+}
+
+func TestEnvTokenSource(t *testing.T) {
+	source := EnvTokenSource("YANDEX360_TEST_TOKEN")
+	_, err := source.Token(context.TODO())
+	require.ErrorIs(t, err, ErrNoToken)
+
+	t.Setenv("YANDEX360_TEST_TOKEN", "from-env")
+	token, err := source.Token(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "from-env", token)
+}
+
+func TestApiClient_TokenSource(t *testing.T) {
+	server := httptest.NewServer(NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler())
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com"}
+
+	// no token at all
+	_, err := NewApiClient().GetDnsRecords(context.TODO(), settings)
+	require.ErrorIs(t, err, ErrNoToken)
+
+	// the default source of the client
+	client := NewApiClient(WithTokenSource(StaticTokenSource(Yandex360ApiMock_TestData.authKey)))
+	records, err := client.GetDnsRecords(context.TODO(), settings)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	// the source of the settings wins
+	failing := TokenSourceFunc(func(context.Context) (string, error) { return "", errors.New("vault sealed") })
+	_, err = client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", TokenSource: failing})
+	require.ErrorContains(t, err, "vault sealed")
+
+	// and the token of the settings wins over both
+	_, err = client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", TokenSource: failing, Token: Yandex360ApiMock_TestData.authKey})
+	require.NoError(t, err)
+}
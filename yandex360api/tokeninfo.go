@@ -0,0 +1,69 @@
+package yandex360api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TokenInfo describes an access token as reported by a token-info endpoint.
+type TokenInfo struct {
+	// ClientID is the OAuth application the token was issued to
+	ClientID string
+	// Login is the account the token was issued for
+	Login string
+	// Expiry is when the token expires, zero if it does not
+	Expiry time.Time
+}
+
+type tokenInfoResponse struct {
+	ClientID  string `json:"client_id"`
+	Login     string `json:"login"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// GetTokenInfo asks the token-info endpoint infoURL about token. The endpoint
+// is called with the token as OAuth authorization and responds with its
+// client_id, login and expires_in in seconds.
+func (a *ApiClient) GetTokenInfo(ctx context.Context, infoURL *url.URL, token string) (_ *TokenInfo, err error) {
+	ctx, span := tracer.Start(a.context(ctx), "yandex360api.GetTokenInfo", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, infoURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetTokenInfo: %w", err)
+	}
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	requested := time.Now()
+	r, err := a.doRequest(req, endpointTokenInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetTokenInfo: %v", RedactString(err.Error()))
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to GetTokenInfo: %w", responseError(r))
+	}
+
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetTokenInfo: read response: %w", err)
+	}
+
+	var rsp tokenInfoResponse
+	if err := json.Unmarshal(bdy, &rsp); err != nil {
+		return nil, fmt.Errorf("failed to GetTokenInfo: unmarshal response: %w", err)
+	}
+
+	info := &TokenInfo{ClientID: rsp.ClientID, Login: rsp.Login}
+	if rsp.ExpiresIn > 0 {
+		info.Expiry = requested.Add(time.Duration(rsp.ExpiresIn) * time.Second)
+	}
+	return info, nil
+}
@@ -0,0 +1,58 @@
+package yandex360api
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes of the API client.
+const (
+	attrOrganizationID = "yandex360.organization_id"
+	attrDomain         = "yandex360.domain"
+	attrRecordType     = "yandex360.record_type"
+	attrRecordID       = "yandex360.record_id"
+	attrRequestID      = "yandex360.request_id"
+	attrCacheHit       = "yandex360.cache_hit"
+)
+
+// requestIDHeader is the response header carrying the Yandex 360 request id.
+const requestIDHeader = "X-Request-Id"
+
+var tracer = otel.Tracer("github.com/alexfirs/cert-manager-webhook-yandex360/yandex360api")
+
+// startSpan starts the span of an ApiClient method. The requests made with
+// the returned context are observed as made for the organization and domain
+// of apiSettings.
+func startSpan(ctx context.Context, name string, apiSettings *ApiSettings, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = withRequestScope(ctx, apiSettings)
+	attrs = append(attrs, attribute.Int(attrOrganizationID, apiSettings.OrganizationId))
+	if apiSettings.Domain != "" {
+		attrs = append(attrs, attribute.String(attrDomain, apiSettings.Domain))
+	}
+	return tracer.Start(ctx, "yandex360api."+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceResponse adds the status code and the Yandex 360 request id of r to the
+// span of the request.
+func traceResponse(r *http.Response) {
+	span := trace.SpanFromContext(r.Request.Context())
+	span.SetAttributes(semconv.HTTPStatusCode(r.StatusCode))
+	if requestID := r.Header.Get(requestIDHeader); requestID != "" {
+		span.SetAttributes(attribute.String(attrRequestID, requestID))
+	}
+}
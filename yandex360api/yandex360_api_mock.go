@@ -8,15 +8,25 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
 )
 
 const ErrTemplate = `{"code":%d,"message":"%s","details":[{"@type":"type.googleapis.com/google.rpc.RequestInfo","requestId":"00000000-0000-0000-0000-000000000000","servingData":""}]}`
 
+// oauthTokenLifetime is the lifetime of the tokens issued by the /token
+// stand-in, one year like the ones of Yandex OAuth.
+const oauthTokenLifetime = 365 * 24 * time.Hour
+
 type RequestContextKey string
 
 const (
@@ -28,6 +38,15 @@ const (
 type Yandex360ApiMockSettings struct {
 	authKey                 string
 	organizationsAndDomains map[int]Domains
+
+	// the OAuth application of the /token stand-in, which exchanges
+	// refreshToken for new access tokens accepted by the API
+	oauthClientID     string
+	oauthClientSecret string
+	refreshToken      string
+	// authKeyLifetime is the lifetime of authKey reported by /tokeninfo,
+	// counted from the creation of the mock; zero never expires
+	authKeyLifetime time.Duration
 }
 type Domains map[string]Records
 type Records []DnsRecord
@@ -38,12 +57,34 @@ type Yandex360ApiMock struct {
 	dnsServer *dns.Server
 	settings  Yandex360ApiMockSettings
 	sync.RWMutex
+
+	requestCounter atomic.Int64
+	// lastRecordId is the highest record id handed out, ids are never reused
+	lastRecordId int
+	// tokens are the accepted access tokens with their expiry, zero if they
+	// do not expire: authKey and the ones handed out by the /token stand-in
+	tokens map[string]time.Time
 }
 
+// NewYandex360ApiMock creates a mock serving a copy of settings, so the
+// caller's test data is never modified by the requests made to the mock.
 func NewYandex360ApiMock(settings Yandex360ApiMockSettings) *Yandex360ApiMock {
-	return &Yandex360ApiMock{
-		settings: settings,
+	y := &Yandex360ApiMock{
+		settings: settings.clone(),
+		tokens:   map[string]time.Time{},
+	}
+	y.tokens[y.settings.authKey] = time.Time{}
+	if y.settings.authKeyLifetime > 0 {
+		y.tokens[y.settings.authKey] = time.Now().Add(y.settings.authKeyLifetime)
+	}
+	for _, domains := range y.settings.organizationsAndDomains {
+		for _, records := range domains {
+			for _, r := range records {
+				y.lastRecordId = max(y.lastRecordId, r.RecordID)
+			}
+		}
 	}
+	return y
 }
 
 func (y *Yandex360ApiMock) Run(addr string) error {
@@ -51,8 +92,31 @@ func (y *Yandex360ApiMock) Run(addr string) error {
 		return errors.New("server is running")
 	}
 
+	y.server = &http.Server{Addr: addr, Handler: y.Handler()}
+
+	return y.server.ListenAndServe()
+}
+
+// Handler returns the router of the mock, e.g. to be served by httptest.
+func (y *Yandex360ApiMock) Handler() http.Handler {
 	router := mux.NewRouter()
 
+	router.Handle(
+		"/directory/v1/org",
+		y.authMiddleware(
+			http.HandlerFunc(y.OrganizationListHandler),
+		),
+	).Methods("GET")
+
+	router.Handle(
+		"/directory/v1/org/{organizationId:[0-9]+}/domains",
+		y.authMiddleware(
+			y.organizationMiddleware(
+				http.HandlerFunc(y.DomainListHandler),
+			),
+		),
+	).Methods("GET")
+
 	router.Handle(
 		"/directory/v1/org/{organizationId:[0-9]+}/domains/{tlDomain}/dns",
 		y.authMiddleware(
@@ -86,9 +150,21 @@ func (y *Yandex360ApiMock) Run(addr string) error {
 		),
 	).Methods("DELETE")
 
-	y.server = &http.Server{Addr: addr, Handler: router}
+	router.Handle(
+		"/directory/v1/org/{organizationId:[0-9]+}/domains/{tlDomain}/dns/{recordId:[0-9]+}",
+		y.authMiddleware(
+			y.organizationMiddleware(
+				y.domainMiddleware(
+					http.HandlerFunc(y.DnsUpdateRecordHandler),
+				),
+			),
+		),
+	).Methods("POST")
+
+	router.HandleFunc("/token", y.OAuthTokenHandler).Methods("POST")
+	router.Handle("/tokeninfo", y.authMiddleware(http.HandlerFunc(y.TokenInfoHandler))).Methods("GET")
 
-	return y.server.ListenAndServe()
+	return y.requestIdMiddleware(router)
 }
 
 func (y *Yandex360ApiMock) RunDns(port string) {
@@ -112,9 +188,77 @@ func (y *Yandex360ApiMock) StopDns(_ context.Context) error {
 
 // API handlers
 
-func (y *Yandex360ApiMock) DnsCreateEntryHandler(w http.ResponseWriter, req *http.Request) {
-	fmt.Println("DnsPutEntryHandler")
+// OAuthTokenHandler is a stand-in of the Yandex OAuth token endpoint for the
+// refresh_token grant. Every exchange rotates the refresh token.
+func (y *Yandex360ApiMock) OAuthTokenHandler(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form")
+		return
+	}
+
+	y.Lock()
+	defer y.Unlock()
+
+	switch {
+	case req.PostForm.Get("grant_type") != "refresh_token":
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only refresh_token is supported")
+	case y.settings.oauthClientID == "" || req.PostForm.Get("client_id") != y.settings.oauthClientID || req.PostForm.Get("client_secret") != y.settings.oauthClientSecret:
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", "Client not found")
+	case req.PostForm.Get("refresh_token") != y.settings.refreshToken:
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+	default:
+		n := y.requestCounter.Load()
+		accessToken := fmt.Sprintf("mockAccessToken%d=", n)
+		y.settings.refreshToken = fmt.Sprintf("mockRefreshToken%d=", n)
+		y.tokens[accessToken] = time.Now().Add(oauthTokenLifetime)
+
+		klog.V(4).InfoS("Mock: issued OAuth token")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token_type":    "bearer",
+			"access_token":  accessToken,
+			"refresh_token": y.settings.refreshToken,
+			"expires_in":    int64(oauthTokenLifetime / time.Second),
+		})
+	}
+}
 
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// TokenInfoHandler reports the client and the remaining lifetime of the
+// access token of the request.
+func (y *Yandex360ApiMock) TokenInfoHandler(w http.ResponseWriter, req *http.Request) {
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "OAuth ")
+
+	y.RLock()
+	defer y.RUnlock()
+
+	var expiresIn int64
+	if expiry := y.tokens[token]; !expiry.IsZero() {
+		expiresIn = int64(time.Until(expiry) / time.Second)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"client_id":  y.settings.oauthClientID,
+		"login":      "mock",
+		"expires_in": expiresIn,
+	})
+}
+
+// SetTokenExpiry makes token, e.g. authKey, expire at expiry. Expired tokens
+// are rejected.
+func (y *Yandex360ApiMock) SetTokenExpiry(token string, expiry time.Time) {
+	y.Lock()
+	defer y.Unlock()
+	y.tokens[token] = expiry
+}
+
+func (y *Yandex360ApiMock) DnsCreateEntryHandler(w http.ResponseWriter, req *http.Request) {
 	orgId, domain := getOrganizatonIdAndDomainFromRequestContext(req)
 
 	bdy, err := io.ReadAll(req.Body)
@@ -132,7 +276,9 @@ func (y *Yandex360ApiMock) DnsCreateEntryHandler(w http.ResponseWriter, req *htt
 
 	y.Lock()
 	domainEntries := y.settings.organizationsAndDomains[orgId][domain]
-	newDnsRecord.RecordID = len(domainEntries) + 1
+	// record ids are never reused, even after a deletion
+	y.lastRecordId++
+	newDnsRecord.RecordID = y.lastRecordId
 	y.settings.organizationsAndDomains[orgId][domain] = append(domainEntries, newDnsRecord)
 	y.Unlock()
 
@@ -142,23 +288,14 @@ func (y *Yandex360ApiMock) DnsCreateEntryHandler(w http.ResponseWriter, req *htt
 		w.Write([]byte("unexpected mock error: unable to marshal"))
 	}
 
-	fmt.Print("Added ")
-	fmt.Println(string(response))
+	klog.V(4).InfoS("Mock: added DNS record", "organizationId", orgId, "domain", domain, "recordId", newDnsRecord.RecordID, "name", newDnsRecord.Name, "type", newDnsRecord.Type)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
 func (y *Yandex360ApiMock) DnsDeleteRecordHandler(w http.ResponseWriter, req *http.Request) {
-	fmt.Println("DnsDeleteRecordHandler")
-
 	orgId, domain := getOrganizatonIdAndDomainFromRequestContext(req)
-	domainEntries, ok := y.settings.organizationsAndDomains[orgId][domain]
-
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
 
 	// TODO: extract to middleware?
 	vars := mux.Vars(req)
@@ -173,53 +310,94 @@ func (y *Yandex360ApiMock) DnsDeleteRecordHandler(w http.ResponseWriter, req *ht
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	fmt.Printf("DnsDeleteRecordHandler: orgId:%d; domain:%s; recId:%d, LOOKUP\n", orgId, domain, recordId)
-	index := -1
-	for i, val := range domainEntries {
-		if val.RecordID == recordId {
-			index = i
-			break
-		}
+
+	y.Lock()
+	domainEntries, ok := y.settings.organizationsAndDomains[orgId][domain]
+	index := slices.IndexFunc(domainEntries, func(r DnsRecord) bool { return r.RecordID == recordId })
+	if ok && index != -1 {
+		// a new slice, listings in progress may still use the old one
+		y.settings.organizationsAndDomains[orgId][domain] = slices.Delete(slices.Clone(domainEntries), index, index+1)
+	}
+	y.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 	if index == -1 {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	success := false
+	klog.V(4).InfoS("Mock: deleted DNS record", "organizationId", orgId, "domain", domain, "recordId", recordId)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+}
+
+// DnsUpdateRecordHandler replaces the record with the id of the path by the
+// record of the body, keeping its id.
+func (y *Yandex360ApiMock) DnsUpdateRecordHandler(w http.ResponseWriter, req *http.Request) {
+	orgId, domain := getOrganizatonIdAndDomainFromRequestContext(req)
+
+	recordId, err := strconv.Atoi(mux.Vars(req)["recordId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bdy, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var updated DnsRecord
+	if err := json.Unmarshal(bdy, &updated); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	updated.RecordID = recordId
 
 	y.Lock()
-	// TODO: make number of attempts here if it's a real code but for mock purposes KISS
-	if domainEntries[index].RecordID == recordId {
-		fmt.Printf("DnsDeleteRecordHandler: orgId:%d; domain:%s; recId:%d, DELETING\n", orgId, domain, recordId)
-		// not very effective but not a lot of records typically
-		newListOfEntries := append(domainEntries[:index], domainEntries[index+1:]...)
-		y.settings.organizationsAndDomains[orgId][domain] = newListOfEntries
-		success = true
+	domainEntries := y.settings.organizationsAndDomains[orgId][domain]
+	index := slices.IndexFunc(domainEntries, func(r DnsRecord) bool { return r.RecordID == recordId })
+	if index != -1 {
+		// a new slice, listings in progress may still use the old one
+		domainEntries = slices.Clone(domainEntries)
+		domainEntries[index] = updated
+		y.settings.organizationsAndDomains[orgId][domain] = domainEntries
 	}
 	y.Unlock()
 
-	fmt.Printf("DnsDeleteRecordHandler: orgId:%d; domain:%s; recId:%d, success:%v\n", orgId, domain, recordId, success)
+	if index == -1 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(getJsonError(5, "Not Found")))
+		return
+	}
 
-	if !success {
+	response, err := json.Marshal(updated)
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unexpected mock error: unable to marshal"))
 		return
 	}
 
+	klog.V(4).InfoS("Mock: updated DNS record", "organizationId", orgId, "domain", domain, "recordId", recordId, "name", updated.Name, "type", updated.Type)
+
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("{}"))
+	w.Write(response)
 }
 
 func (y *Yandex360ApiMock) DnsListHandler(w http.ResponseWriter, req *http.Request) {
-	fmt.Println("DnsListHandler")
-
 	// defaults
 	perPage := 10
 	page := 1
 	page, perPage = getPagingAttributes(req, 1, 10)
 
 	orgId, domain := getOrganizatonIdAndDomainFromRequestContext(req)
+	y.RLock()
 	domainEntries, ok := y.settings.organizationsAndDomains[orgId][domain]
+	y.RUnlock()
 
 	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
@@ -227,7 +405,7 @@ func (y *Yandex360ApiMock) DnsListHandler(w http.ResponseWriter, req *http.Reque
 	}
 
 	total := len(domainEntries)
-	records := domainEntries[max(0, (page-1)*perPage):min((page)*perPage, total)]
+	records := domainEntries[min(max(0, (page-1)*perPage), total):min((page)*perPage, total)]
 
 	resp := GetDataResponse{
 		Page:    page,
@@ -247,6 +425,88 @@ func (y *Yandex360ApiMock) DnsListHandler(w http.ResponseWriter, req *http.Reque
 	w.Write(response)
 }
 
+// OrganizationListHandler lists all organizations of the mock by id, paged
+// with pageSize and the pageToken of the previous page.
+func (y *Yandex360ApiMock) OrganizationListHandler(w http.ResponseWriter, req *http.Request) {
+	pageSize := 10
+	if value, err := strconv.Atoi(req.URL.Query().Get("pageSize")); err == nil && value > 0 {
+		pageSize = value
+	}
+	start := 0
+	if value := req.URL.Query().Get("pageToken"); value != "" {
+		var err error
+		if start, err = strconv.Atoi(value); err != nil || start < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	y.RLock()
+	ids := make([]int, 0, len(y.settings.organizationsAndDomains))
+	for id := range y.settings.organizationsAndDomains {
+		ids = append(ids, id)
+	}
+	y.RUnlock()
+	sort.Ints(ids)
+
+	resp := GetOrganizationsResponse{Organizations: []Organization{}}
+	end := min(start+pageSize, len(ids))
+	for _, id := range ids[min(start, len(ids)):end] {
+		resp.Organizations = append(resp.Organizations, Organization{Id: id, Name: fmt.Sprintf("Organization %d", id), Language: "ru", SubscriptionPlan: "business"})
+	}
+	if end < len(ids) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+
+	response, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unexpected mock error: unable to marshal"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func (y *Yandex360ApiMock) DomainListHandler(w http.ResponseWriter, req *http.Request) {
+	page, perPage := getPagingAttributes(req, 1, 10)
+
+	orgId := req.Context().Value(OrganizationContextKey).(int)
+
+	y.RLock()
+	names := make([]string, 0, len(y.settings.organizationsAndDomains[orgId]))
+	for name := range y.settings.organizationsAndDomains[orgId] {
+		names = append(names, name)
+	}
+	y.RUnlock()
+	sort.Strings(names)
+
+	total := len(names)
+	domains := make([]Domain, 0, perPage)
+	for _, name := range names[min(max(0, (page-1)*perPage), total):min(page*perPage, total)] {
+		domains = append(domains, Domain{Name: name, Country: "ru", Delegated: true, Master: true, Mx: true, Verified: true})
+	}
+
+	resp := GetDomainsResponse{
+		Page:    page,
+		PerPage: perPage,
+		Pages:   int(math.Ceil(float64(total) / float64(perPage))),
+		Total:   total,
+		Domains: domains,
+	}
+
+	response, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unexpected mock error: unable to marshal"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
 func (y *Yandex360ApiMock) organizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -263,7 +523,9 @@ func (y *Yandex360ApiMock) organizationMiddleware(next http.Handler) http.Handle
 			return
 		}
 
+		y.RLock()
 		domains, ok := y.settings.organizationsAndDomains[orgId]
+		y.RUnlock()
 		if !ok || domains == nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(getJsonErrorUnauthorized()))
@@ -271,8 +533,6 @@ func (y *Yandex360ApiMock) organizationMiddleware(next http.Handler) http.Handle
 		}
 
 		ctx := context.WithValue(r.Context(), OrganizationContextKey, orgId)
-
-		fmt.Println("organizationMiddleware fine, orgid=" + strconv.Itoa(orgId))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -293,7 +553,9 @@ func (y *Yandex360ApiMock) domainMiddleware(next http.Handler) http.Handler {
 			w.Write([]byte(getJsonErrorUnauthorized()))
 		}
 
+		y.RLock()
 		domains, ok := y.settings.organizationsAndDomains[orgId][tlDomain]
+		y.RUnlock()
 		if !ok || domains == nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(getJsonErrorUnauthorized()))
@@ -301,20 +563,30 @@ func (y *Yandex360ApiMock) domainMiddleware(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(currentContex, DomainEntriesContextKey, tlDomain)
-
-		fmt.Println("domainMiddleware fine,  domain=" + tlDomain)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requestIdMiddleware sets a unique X-Request-Id on every response, like the
+// real API does.
+func (y *Yandex360ApiMock) requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", fmt.Sprintf("00000000-0000-0000-0000-%012d", y.requestCounter.Add(1)))
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (y *Yandex360ApiMock) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if ("OAuth " + y.settings.authKey) != r.Header.Get("Authorization") {
+		y.RLock()
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "OAuth ")
+		expiry, authorized := y.tokens[token]
+		y.RUnlock()
+		if !authorized || (!expiry.IsZero() && !time.Now().Before(expiry)) {
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(getJsonErrorUnauthorized()))
 			return
 		}
-		fmt.Println("authMiddleware fine")
 		next.ServeHTTP(w, r)
 	})
 }
@@ -342,6 +614,25 @@ func getPagingAttributes(req *http.Request, defaultPage int, defaultPerPage int)
 }
 
 // utilities
+
+func (s Yandex360ApiMockSettings) clone() Yandex360ApiMockSettings {
+	c := Yandex360ApiMockSettings{
+		authKey:                 s.authKey,
+		organizationsAndDomains: make(map[int]Domains, len(s.organizationsAndDomains)),
+		oauthClientID:           s.oauthClientID,
+		oauthClientSecret:       s.oauthClientSecret,
+		refreshToken:            s.refreshToken,
+		authKeyLifetime:         s.authKeyLifetime,
+	}
+	for orgId, domains := range s.organizationsAndDomains {
+		c.organizationsAndDomains[orgId] = make(Domains, len(domains))
+		for domain, records := range domains {
+			c.organizationsAndDomains[orgId][domain] = append(Records{}, records...)
+		}
+	}
+	return c
+}
+
 func getJsonError(code int, message string) string {
 	return fmt.Sprintf(ErrTemplate, code, message)
 }
@@ -2,38 +2,33 @@ package yandex360api
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/suite"
 )
 
-const baseUrl = "http://localhost:8489/directory/v1/org/"
-
 type yandex360apiMockTestSuite struct {
 	suite.Suite
 	yandex360api *Yandex360ApiMock
+	server       *httptest.Server
 	client       *http.Client
+	baseUrl      string
 }
 
-func (suite *yandex360apiMockTestSuite) SetupTest() {
+func (suite *yandex360apiMockTestSuite) SetupSuite() {
 	suite.yandex360api = NewYandex360ApiMock(Yandex360ApiMock_TestData)
-	suite.client = http.DefaultClient
-
-	go func() {
-		suite.yandex360api.Run(":8489")
-	}()
+	suite.server = httptest.NewServer(suite.yandex360api.Handler())
+	suite.client = suite.server.Client()
+	suite.baseUrl = suite.server.URL + "/directory/v1/org/"
 }
 
 func (suite *yandex360apiMockTestSuite) TearDownSuite() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	suite.yandex360api.Stop(ctx)
+	suite.server.Close()
 }
 
 func TestYandex360apiMockTestSuite(t *testing.T) {
@@ -124,9 +119,87 @@ func (suite *yandex360apiMockTestSuite) TestYandex360apiMock_AddRecord() {
 
 }
 
+func (suite *yandex360apiMockTestSuite) TestYandex360apiMock_AddRecord_IdsNotReused() {
+	orgId := 1001
+	domain := "example2.com"
+
+	suite.requestAdd("POST", orgId, domain, DnsRecord{Type: "TXT", Name: "_first", Text: "first", TTL: 300}, http.StatusOK)
+	rsp := suite.requestListData(orgId, domain, 1, 10)
+	first := rsp.Records[len(rsp.Records)-1]
+	suite.requestDelete("DELETE", orgId, domain, first.RecordID, http.StatusOK)
+
+	suite.requestAdd("POST", orgId, domain, DnsRecord{Type: "TXT", Name: "_second", Text: "second", TTL: 300}, http.StatusOK)
+	rsp = suite.requestListData(orgId, domain, 1, 10)
+	second := rsp.Records[len(rsp.Records)-1]
+	suite.Require().Equal("_second", second.Name)
+	suite.Require().Greater(second.RecordID, first.RecordID)
+}
+
+func (suite *yandex360apiMockTestSuite) TestYandex360apiMock_UpdateRecord() {
+	orgId := 1003
+	domain := "example.com"
+
+	// nonexisting record
+	suite.requestUpdate(orgId, domain, 100, DnsRecord{Type: "TXT", Name: "sometxt3", Text: "updated", TTL: 300}, http.StatusNotFound)
+
+	suite.requestUpdate(orgId, domain, 3, DnsRecord{Type: "TXT", Name: "sometxt3", Text: "updated", TTL: 300}, http.StatusOK)
+	rsp := suite.requestListData(orgId, domain, 1, 10)
+	suite.Require().Equal(3, len(rsp.Records))
+	suite.Require().Equal(DnsRecord{RecordID: 3, Type: "TXT", Name: "sometxt3", Text: "updated", TTL: 300}, rsp.Records[2])
+}
+
+func (suite *yandex360apiMockTestSuite) TestYandex360apiMock_GetOrganizations() {
+	req, _ := http.NewRequest("GET", suite.server.URL+"/directory/v1/org?pageSize=2", nil)
+	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
+	r, err := suite.client.Do(req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusOK, r.StatusCode)
+
+	var rsp GetOrganizationsResponse
+	suite.Require().NoError(json.NewDecoder(r.Body).Decode(&rsp))
+	suite.Require().Equal(2, len(rsp.Organizations))
+	suite.Require().Equal(1001, rsp.Organizations[0].Id)
+	suite.Require().Equal("2", rsp.NextPageToken)
+
+	req, _ = http.NewRequest("GET", suite.server.URL+"/directory/v1/org?pageSize=2&pageToken="+rsp.NextPageToken, nil)
+	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
+	r, err = suite.client.Do(req)
+	suite.Require().NoError(err)
+	rsp = GetOrganizationsResponse{}
+	suite.Require().NoError(json.NewDecoder(r.Body).Decode(&rsp))
+	suite.Require().Equal(1, len(rsp.Organizations))
+	suite.Require().Equal(1003, rsp.Organizations[0].Id)
+	suite.Require().Empty(rsp.NextPageToken)
+}
+
+func (suite *yandex360apiMockTestSuite) TestYandex360apiMock_GetDomains() {
+	req, _ := http.NewRequest("GET", suite.baseUrl+"1001/domains?page=1&perPage=1", nil)
+	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
+	r, err := suite.client.Do(req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusOK, r.StatusCode)
+
+	var rsp GetDomainsResponse
+	bdy, err := io.ReadAll(r.Body)
+	suite.Require().NoError(err)
+	suite.Require().NoError(json.Unmarshal(bdy, &rsp))
+
+	suite.Require().Equal(2, rsp.Total)
+	suite.Require().Equal(2, rsp.Pages)
+	suite.Require().Equal(1, len(rsp.Domains))
+	suite.Require().Equal("example1.com", rsp.Domains[0].Name)
+
+	// unauthorized organization
+	req, _ = http.NewRequest("GET", suite.baseUrl+"1000/domains", nil)
+	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
+	r, err = suite.client.Do(req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusUnauthorized, r.StatusCode)
+}
+
 func (suite *yandex360apiMockTestSuite) requestAdd(method string, orgId int, domain string, dnsRecord DnsRecord, expectedCode int) {
 	body, _ := json.Marshal(dnsRecord)
-	req, _ := http.NewRequest(method, baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns", bytes.NewBuffer(body))
+	req, _ := http.NewRequest(method, suite.baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns", bytes.NewBuffer(body))
 	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
 
 	r, err := suite.client.Do(req)
@@ -135,7 +208,7 @@ func (suite *yandex360apiMockTestSuite) requestAdd(method string, orgId int, dom
 }
 
 func (suite *yandex360apiMockTestSuite) requestDelete(method string, orgId int, domain string, recordId int, expectedCode int) {
-	req, _ := http.NewRequest(method, baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns/"+strconv.Itoa(recordId), nil)
+	req, _ := http.NewRequest(method, suite.baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns/"+strconv.Itoa(recordId), nil)
 	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
 	r, err := suite.client.Do(req)
 	suite.Require().NoError(err)
@@ -143,7 +216,7 @@ func (suite *yandex360apiMockTestSuite) requestDelete(method string, orgId int,
 }
 
 func (suite *yandex360apiMockTestSuite) requestAndValidateGetDnsEntries(orgIdString string, domain string, passToken bool, expectedStatusCode int) {
-	req, _ := http.NewRequest("GET", baseUrl+orgIdString+"/domains/"+domain+"/dns", nil)
+	req, _ := http.NewRequest("GET", suite.baseUrl+orgIdString+"/domains/"+domain+"/dns", nil)
 	if passToken {
 		req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
 	}
@@ -155,7 +228,7 @@ func (suite *yandex360apiMockTestSuite) requestAndValidateGetDnsEntries(orgIdStr
 func (suite *yandex360apiMockTestSuite) requestListData(orgId int, domain string, page int, perPage int) GetDataResponse {
 	var rsp GetDataResponse
 
-	req, _ := http.NewRequest("GET", baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns?page="+strconv.Itoa(page)+"&perPage="+strconv.Itoa(perPage)+"", nil)
+	req, _ := http.NewRequest("GET", suite.baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns?page="+strconv.Itoa(page)+"&perPage="+strconv.Itoa(perPage)+"", nil)
 	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
 	r, err := suite.client.Do(req)
 	suite.Require().NoError(err)
@@ -167,3 +240,12 @@ func (suite *yandex360apiMockTestSuite) requestListData(orgId int, domain string
 	suite.Require().NoError(err)
 	return rsp
 }
+
+func (suite *yandex360apiMockTestSuite) requestUpdate(orgId int, domain string, recordId int, dnsRecord DnsRecord, expectedCode int) {
+	body, _ := json.Marshal(dnsRecord)
+	req, _ := http.NewRequest("POST", suite.baseUrl+strconv.Itoa(orgId)+"/domains/"+domain+"/dns/"+strconv.Itoa(recordId), bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "OAuth "+Yandex360ApiMock_TestData.authKey)
+	r, err := suite.client.Do(req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(expectedCode, r.StatusCode)
+}
@@ -1,7 +1,13 @@
 package yandex360api
 
+import "time"
+
 var Yandex360ApiMock_TestData = Yandex360ApiMockSettings{
-	authKey: "mockTestKey=",
+	authKey:           "mockTestKey=",
+	oauthClientID:     "mockClientId",
+	oauthClientSecret: "mockClientSecret",
+	refreshToken:      "mockRefreshToken=",
+	authKeyLifetime:   365 * 24 * time.Hour,
 	organizationsAndDomains: map[int]Domains{
 		1001: {
 			"example1.com": Records{
@@ -2,149 +2,517 @@ package yandex360api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
 )
 
 const TXTKey = "TXT"
 const TXTDataKey = "txtdata"
 
-func NewApiClient() *ApiClient {
-	client := http.Client{}
+// perPage is the page size used when listing collections.
+const perPage = 50
+
+// Option configures an ApiClient.
+type Option func(*ApiClient)
+
+// WithRateLimit rate limits the requests of the client, see RateLimit.
+func WithRateLimit(limit RateLimit) Option {
+	return func(a *ApiClient) {
+		a.limiter = newRateLimiter(limit)
+	}
+}
+
+// WithRecordCache caches the DNS records of a domain for ttl and coalesces
+// concurrent listings of the same domain. The records of a domain are
+// invalidated by every write of the client to it, zero disables the cache.
+func WithRecordCache(ttl time.Duration) Option {
+	return func(a *ApiClient) {
+		a.cache = nil
+		if ttl > 0 {
+			a.cache = newRecordCache(ttl)
+		}
+	}
+}
+
+// WithHTTPClient sends the requests with a copy of client, e.g. one with a
+// proxy or a custom CA configured in its transport. The options changing the
+// transport or the timeout apply to the copy.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *ApiClient) {
+		c := *client
+		a.client = &c
+	}
+}
+
+// WithTimeout limits the time of every request, including reading the
+// response body. Zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(a *ApiClient) {
+		a.timeout = timeout
+	}
+}
 
-	return &ApiClient{
-		client: &client,
+// WithUserAgent sets the User-Agent header of every request.
+func WithUserAgent(userAgent string) Option {
+	return func(a *ApiClient) {
+		a.userAgent = userAgent
 	}
 }
 
-func (a *ApiClient) AddTxtRecord(apiSettings *ApiSettings, name string, text string, ttl int) error {
-	err := a.AddDnsRecord(apiSettings, DnsRecord{Name: name, Text: text, Type: "TXT", TTL: ttl})
+// WithRoundTripper wraps the transport of the client with middleware. The
+// middleware of the first call is the outermost one.
+func WithRoundTripper(middleware func(http.RoundTripper) http.RoundTripper) Option {
+	return func(a *ApiClient) {
+		a.roundTrippers = append(a.roundTrippers, middleware)
+	}
+}
+
+// WithLogger logs with logger for requests whose context carries no logger.
+func WithLogger(logger klog.Logger) Option {
+	return func(a *ApiClient) {
+		a.logger = &logger
+	}
+}
+
+// WithRetryPolicy retries failed requests according to policy instead of
+// DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(a *ApiClient) {
+		a.retry = policy
+	}
+}
+
+// SetTimeout changes the timeout of the requests of a client in use, e.g. on a
+// configuration reload. Requests already sent keep their timeout.
+func (a *ApiClient) SetTimeout(timeout time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := *a.client
+	c.Timeout = timeout
+	a.client = &c
+}
+
+// SetRetryPolicy changes the retry policy of a client in use.
+func (a *ApiClient) SetRetryPolicy(policy RetryPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.retry = policy
+}
+
+// SetRateLimit changes the rate limit of a client in use. The token buckets
+// start full again, requests already waiting keep the old limit.
+func (a *ApiClient) SetRateLimit(limit RateLimit) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limiter = newRateLimiter(limit)
+}
+
+func (a *ApiClient) httpClient() *http.Client {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.client
+}
+
+func (a *ApiClient) retryPolicy() RetryPolicy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.retry
+}
+
+func (a *ApiClient) rateLimiter() *rateLimiter {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.limiter
+}
+
+// RoundTripperFunc adapts a function to http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func NewApiClient(opts ...Option) *ApiClient {
+	a := &ApiClient{
+		client: &http.Client{},
+		retry:  DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	transport := a.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(a.roundTrippers) - 1; i >= 0; i-- {
+		transport = a.roundTrippers[i](transport)
+	}
+	a.client.Transport = otelhttp.NewTransport(transport)
+	if a.timeout > 0 {
+		a.client.Timeout = a.timeout
+	}
+	return a
+}
+
+// context returns ctx carrying the logger of WithLogger, unless it already
+// carries one.
+func (a *ApiClient) context(ctx context.Context) context.Context {
+	if a.logger == nil {
+		return ctx
+	}
+	if _, err := logr.FromContext(ctx); err == nil {
+		return ctx
+	}
+	return klog.NewContext(ctx, *a.logger)
+}
+
+// AddTxtRecord creates a TXT record and returns it as created by Yandex 360,
+// i.e. with its RecordID set.
+func (a *ApiClient) AddTxtRecord(ctx context.Context, apiSettings *ApiSettings, name string, text string, ttl int) (*DnsRecord, error) {
+	record, err := a.AddDnsRecord(ctx, apiSettings, DnsRecord{Name: name, Text: text, Type: "TXT", TTL: ttl})
 	if err != nil {
-		return fmt.Errorf("failed to AddTxtRecord: %v", err)
+		return nil, fmt.Errorf("failed to AddTxtRecord: %w", err)
 	}
-	return err
+	return record, nil
 }
 
-func (a *ApiClient) GetDnsRecords(apiSettings *ApiSettings) ([]DnsRecord, error) {
-	// TODO: add proper paging
-	const page = 1
-	const perPage = 50
+// GetDnsRecords returns all DNS records of the domain. With WithRecordCache
+// the records may be served from the cache.
+func (a *ApiClient) GetDnsRecords(ctx context.Context, apiSettings *ApiSettings) (records []DnsRecord, err error) {
+	ctx, span := startSpan(a.context(ctx), "GetDnsRecords", apiSettings)
+	defer func() { endSpan(span, err) }()
 
-	data, err := getDnsRecords(*a.client, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, page, perPage)
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GetDnsRecords: %v", err)
+		return nil, fmt.Errorf("failed to GetDnsRecords: %w", err)
+	}
+
+	if a.cache == nil {
+		return a.listDnsRecords(ctx, apiSettings)
 	}
 
-	return data.Records, nil
+	records, cached, err := a.cache.Get(ctx, apiSettings, func(ctx context.Context) ([]DnsRecord, error) {
+		return a.listDnsRecords(ctx, apiSettings)
+	})
+	span.SetAttributes(attribute.Bool(attrCacheHit, cached))
+	return records, err
 }
 
-func (a *ApiClient) AddDnsRecord(apiSettings *ApiSettings, record DnsRecord) error {
-	err := addDnsRecord(*a.client, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, record)
-	if err != nil {
-		return fmt.Errorf("failed to AddDnsRecord: %v", err)
+// listDnsRecords lists all pages of DNS records of the domain.
+func (a *ApiClient) listDnsRecords(ctx context.Context, apiSettings *ApiSettings) (records []DnsRecord, err error) {
+	ctx = withRateLimit(ctx, apiSettings)
+	for page := 1; ; page++ {
+		data, err := a.getDnsRecords(ctx, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, page, perPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to GetDnsRecords: %w", err)
+		}
+
+		records = append(records, data.Records...)
+		if page >= data.Pages || len(data.Records) == 0 {
+			break
+		}
 	}
 
-	return nil
+	return records, nil
 }
 
-func (a *ApiClient) DeleteTxtRecordByName(apiSettings *ApiSettings, name string) error {
-	records, err := a.GetDnsRecords(apiSettings)
+// GetDomains returns all domains connected to the organization. The Domain
+// field of apiSettings is ignored.
+func (a *ApiClient) GetDomains(ctx context.Context, apiSettings *ApiSettings) (domains []Domain, err error) {
+	ctx, span := startSpan(a.context(ctx), "GetDomains", apiSettings)
+	defer func() { endSpan(span, err) }()
+
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
 	if err != nil {
-		return fmt.Errorf("DeleteDnsRecordByName: failed to getDnsRecords: %v", err)
+		return nil, fmt.Errorf("failed to GetDomains: %w", err)
 	}
 
-	recordId := -1
+	ctx = withRateLimit(ctx, apiSettings)
+	for page := 1; ; page++ {
+		data, err := a.getDomains(ctx, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, page, perPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to GetDomains: %w", err)
+		}
+
+		domains = append(domains, data.Domains...)
+		if page >= data.Pages || len(data.Domains) == 0 {
+			break
+		}
+	}
+
+	return domains, nil
+}
+
+// GetDnsRecord returns the DNS record of the domain with recordId, or an
+// error wrapping ErrRecordNotFound.
+func (a *ApiClient) GetDnsRecord(ctx context.Context, apiSettings *ApiSettings, recordId int) (*DnsRecord, error) {
+	records, err := a.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetDnsRecord: %w", err)
+	}
 	for _, r := range records {
-		if r.Name == name && r.Type == "TXT" {
-			recordId = r.RecordID
+		if r.RecordID == recordId {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to GetDnsRecord %d: %w", recordId, ErrRecordNotFound)
+}
+
+// FindDnsRecords returns the DNS records of the domain selected by q.
+func (a *ApiClient) FindDnsRecords(ctx context.Context, apiSettings *ApiSettings, q RecordQuery) ([]DnsRecord, error) {
+	records, err := a.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to FindDnsRecords: %w", err)
+	}
+	return FilterDnsRecords(records, q), nil
+}
+
+// GetOrganizations returns all organizations the token has access to. The
+// OrganizationId and Domain fields of apiSettings are ignored.
+func (a *ApiClient) GetOrganizations(ctx context.Context, apiSettings *ApiSettings) (organizations []Organization, err error) {
+	ctx, span := startSpan(a.context(ctx), "GetOrganizations", apiSettings)
+	defer func() { endSpan(span, err) }()
+
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetOrganizations: %w", err)
+	}
+
+	ctx = withRateLimit(ctx, apiSettings)
+	pageToken := ""
+	for {
+		data, err := a.getOrganizations(ctx, *apiSettings.ApiUrl, apiSettings.Token, pageToken, perPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to GetOrganizations: %w", err)
+		}
+
+		organizations = append(organizations, data.Organizations...)
+		if data.NextPageToken == "" || len(data.Organizations) == 0 {
 			break
 		}
+		pageToken = data.NextPageToken
 	}
-	if recordId == -1 {
-		return fmt.Errorf("DeleteDnsRecordByName: failed to Find name %s: %v, data :%v", name, err, records)
+
+	return organizations, nil
+}
+
+// AddDnsRecord validates and creates record and returns it as created by
+// Yandex 360, i.e. with its RecordID set.
+func (a *ApiClient) AddDnsRecord(ctx context.Context, apiSettings *ApiSettings, record DnsRecord) (_ *DnsRecord, err error) {
+	ctx, span := startSpan(a.context(ctx), "AddDnsRecord", apiSettings, attribute.String(attrRecordType, record.Type))
+	defer func() { endSpan(span, err) }()
+
+	if err := record.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to AddDnsRecord: %w", err)
+	}
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to AddDnsRecord: %w", err)
+	}
+
+	ctx = withRateLimit(ctx, apiSettings)
+	defer a.invalidate(apiSettings)
+	created, err := a.addDnsRecord(ctx, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to AddDnsRecord: %w", err)
+	}
+
+	return created, nil
+}
+
+// UpdateDnsRecord validates record and replaces the record with its RecordID,
+// and returns it as updated by Yandex 360. Like creations, updates are not
+// retried.
+func (a *ApiClient) UpdateDnsRecord(ctx context.Context, apiSettings *ApiSettings, record DnsRecord) (_ *DnsRecord, err error) {
+	ctx, span := startSpan(a.context(ctx), "UpdateDnsRecord", apiSettings, attribute.String(attrRecordType, record.Type), attribute.Int(attrRecordID, record.RecordID))
+	defer func() { endSpan(span, err) }()
+
+	if record.RecordID == 0 {
+		return nil, fmt.Errorf("failed to UpdateDnsRecord: %w: recordId is required", ErrInvalidRecord)
+	}
+	if err := record.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to UpdateDnsRecord: %w", err)
+	}
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to UpdateDnsRecord: %w", err)
 	}
 
-	err = deleteDnsRecord(*a.client, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, recordId)
+	ctx = withRateLimit(ctx, apiSettings)
+	defer a.invalidate(apiSettings)
+	updated, err := a.updateDnsRecord(ctx, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, record)
 	if err != nil {
-		return fmt.Errorf("DeleteDnsRecordByName: failed to DeleteDnsRecord: %v", err)
+		return nil, fmt.Errorf("failed to UpdateDnsRecord: %w", err)
+	}
+
+	return updated, nil
+}
+
+// DeleteTxtRecord deletes the TXT records named name whose value is text, so
+// records of the same name written by others are left alone. It fails if there
+// is no such record.
+func (a *ApiClient) DeleteTxtRecord(ctx context.Context, apiSettings *ApiSettings, name string, text string) error {
+	records, err := a.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return fmt.Errorf("DeleteTxtRecord: failed to getDnsRecords: %w", err)
+	}
+
+	var recordIds []int
+	for _, r := range FilterDnsRecords(records, RecordQuery{Name: name, Type: TXTKey, Value: text}) {
+		recordIds = append(recordIds, r.RecordID)
+	}
+	if len(recordIds) == 0 {
+		return fmt.Errorf("DeleteTxtRecord: failed to find TXT record %s", name)
+	}
+
+	for _, recordId := range recordIds {
+		if err := a.DeleteDnsRecord(ctx, apiSettings, recordId); err != nil {
+			return fmt.Errorf("DeleteTxtRecord: failed to DeleteDnsRecord: %w", err)
+		}
 	}
 	return nil
 }
 
-func (a *ApiClient) DeleteDnsRecord(apiSettings *ApiSettings, recordId int) error {
-	err := deleteDnsRecord(*a.client, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, recordId)
+func (a *ApiClient) DeleteDnsRecord(ctx context.Context, apiSettings *ApiSettings, recordId int) (err error) {
+	ctx, span := startSpan(a.context(ctx), "DeleteDnsRecord", apiSettings, attribute.Int(attrRecordID, recordId))
+	defer func() { endSpan(span, err) }()
+
+	apiSettings, err = a.resolveToken(ctx, apiSettings)
 	if err != nil {
-		return fmt.Errorf("failed to DeleteDnsRecord: %v", err)
+		return fmt.Errorf("failed to DeleteDnsRecord: %w", err)
+	}
+
+	ctx = withRateLimit(ctx, apiSettings)
+	defer a.invalidate(apiSettings)
+	err = a.deleteDnsRecord(ctx, *apiSettings.ApiUrl, apiSettings.Token, apiSettings.OrganizationId, apiSettings.Domain, recordId)
+	if err != nil {
+		return fmt.Errorf("failed to DeleteDnsRecord: %w", err)
 	}
 	return nil
 }
 
-func deleteDnsRecord(httpClient http.Client, apiUrl url.URL, token string, companyId int, domain string, recordId int) error {
+// invalidate drops the cached records of the domain after a write, even a
+// failed one may have been applied.
+func (a *ApiClient) invalidate(apiSettings *ApiSettings) {
+	if a.cache != nil {
+		a.cache.Invalidate(apiSettings)
+	}
+}
+
+// InvalidateCache drops the cached records of the domain, e.g. because it may
+// have been modified by another client.
+func (a *ApiClient) InvalidateCache(apiSettings *ApiSettings) {
+	a.invalidate(apiSettings)
+}
+
+func (a *ApiClient) deleteDnsRecord(ctx context.Context, apiUrl url.URL, token string, companyId int, domain string, recordId int) error {
 	u := apiUrl
 	u.Path += "/directory/v1/org/" + strconv.Itoa(companyId) + "/domains/" + domain + "/dns/" + strconv.Itoa(recordId)
 
-	req, _ := http.NewRequest("DELETE", u.String(), nil)
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", u.String(), nil)
 
 	req.Header.Set("Authorization", "OAuth "+token)
 
-	r, err := httpClient.Do(req)
+	r, err := a.doRequest(req, endpointDnsDelete)
 
 	if err != nil {
-		return fmt.Errorf("delete failed: %d", r.StatusCode)
+		return fmt.Errorf("delete failed: %w", err)
 	}
 
 	if r.StatusCode != 200 {
-		bdy, err := io.ReadAll(r.Body)
-		r.Body.Close()
-		if err == nil {
-			return fmt.Errorf("response failed with status code: %d and body: %s", r.StatusCode, bdy)
-		} else {
-			return fmt.Errorf("response failed with status code: %d and unable parse body due to : %v", r.StatusCode, err)
-		}
-
+		return responseError(r)
 	}
+	r.Body.Close()
 	return nil
 }
 
-func addDnsRecord(httpClient http.Client, apiUrl url.URL, token string, companyId int, domain string, record DnsRecord) error {
+func (a *ApiClient) addDnsRecord(ctx context.Context, apiUrl url.URL, token string, companyId int, domain string, record DnsRecord) (*DnsRecord, error) {
 	u := apiUrl
 	u.Path += "/directory/v1/org/" + strconv.Itoa(companyId) + "/domains/" + domain + "/dns"
 
 	jsonValue, _ := json.Marshal(record)
 
-	req, _ := http.NewRequest("POST", u.String(), bytes.NewBuffer(jsonValue))
+	req, _ := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonValue))
 
 	req.Header.Set("Authorization", "OAuth "+token)
 
-	r, err := httpClient.Do(req)
+	r, err := a.doRequest(req, endpointDnsCreate)
 
 	if err != nil {
 		if r != nil {
-			return fmt.Errorf("post failed: %d, %v", r.StatusCode, err)
+			return nil, fmt.Errorf("post failed: %d, %v", r.StatusCode, err)
 		} else {
-			return fmt.Errorf("post failed: %v", err)
+			return nil, fmt.Errorf("post failed: %w", err)
 		}
 
 	}
 
 	if r.StatusCode != 200 {
-		bdy, err := io.ReadAll(r.Body)
-		r.Body.Close()
-		if err == nil {
-			return fmt.Errorf("response failed with status code: %d and body: %s", r.StatusCode, bdy)
-		} else {
-			return fmt.Errorf("response failed with status code: %d and unable parse body due to : %v", r.StatusCode, err)
-		}
+		return nil, responseError(r)
+	}
 
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
 	}
-	return nil
+
+	var created DnsRecord
+	err = json.Unmarshal(bdy, &created)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return &created, nil
 }
 
-func getDnsRecords(httpClient http.Client, apiUrl url.URL, token string, companyId int, domain string, page int, perPage int) (*GetDataResponse, error) {
+func (a *ApiClient) updateDnsRecord(ctx context.Context, apiUrl url.URL, token string, companyId int, domain string, record DnsRecord) (*DnsRecord, error) {
+	u := apiUrl
+	u.Path += "/directory/v1/org/" + strconv.Itoa(companyId) + "/domains/" + domain + "/dns/" + strconv.Itoa(record.RecordID)
+
+	jsonValue, _ := json.Marshal(record)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonValue))
+
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	r, err := a.doRequest(req, endpointDnsUpdate)
+
+	if err != nil {
+		return nil, fmt.Errorf("post failed: %w", err)
+	}
+
+	if r.StatusCode != 200 {
+		return nil, responseError(r)
+	}
+
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var updated DnsRecord
+	err = json.Unmarshal(bdy, &updated)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return &updated, nil
+}
+
+func (a *ApiClient) getDnsRecords(ctx context.Context, apiUrl url.URL, token string, companyId int, domain string, page int, perPage int) (*GetDataResponse, error) {
 	u := apiUrl
 	u.Path += "/directory/v1/org/" + strconv.Itoa(companyId) + "/domains/" + domain + "/dns"
 
@@ -154,31 +522,115 @@ func getDnsRecords(httpClient http.Client, apiUrl url.URL, token string, company
 
 	u.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", u.String(), nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 
 	req.Header.Set("Authorization", "OAuth "+token)
 
-	r, err := httpClient.Do(req)
+	r, err := a.doRequest(req, endpointDnsList)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to make GET request: %v", err)
+		return nil, fmt.Errorf("failed to make GET request: %w", err)
+	}
+
+	if r.StatusCode != 200 {
+		return nil, responseError(r)
 	}
 
 	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var rsp GetDataResponse
 
-	if r.StatusCode != 200 || err != nil {
-		return nil, fmt.Errorf("response failed with status code: %d and body: %s", r.StatusCode, bdy)
+	err = json.Unmarshal(bdy, &rsp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
 	}
+	klog.FromContext(ctx).V(6).Info("Listed DNS records", "organizationId", companyId, "domain", domain, "page", page, "records", len(rsp.Records), "total", rsp.Total)
 
+	return &rsp, nil
+}
+
+func (a *ApiClient) getDomains(ctx context.Context, apiUrl url.URL, token string, companyId int, page int, perPage int) (*GetDomainsResponse, error) {
+	u := apiUrl
+	u.Path += "/directory/v1/org/" + strconv.Itoa(companyId) + "/domains"
+
+	q := u.Query()
+	q.Add("page", strconv.Itoa(page))
+	q.Add("perPage", strconv.Itoa(perPage))
+
+	u.RawQuery = q.Encode()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	r, err := a.doRequest(req, endpointDomainList)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to make GET request: %w", err)
+	}
+
+	if r.StatusCode != 200 {
+		return nil, responseError(r)
+	}
+
+	bdy, err := io.ReadAll(r.Body)
 	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
 
-	var rsp GetDataResponse
+	var rsp GetDomainsResponse
+
+	err = json.Unmarshal(bdy, &rsp)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return &rsp, nil
+}
+
+func (a *ApiClient) getOrganizations(ctx context.Context, apiUrl url.URL, token string, pageToken string, pageSize int) (*GetOrganizationsResponse, error) {
+	u := apiUrl
+	u.Path += "/directory/v1/org"
+
+	q := u.Query()
+	q.Add("pageSize", strconv.Itoa(pageSize))
+	if pageToken != "" {
+		q.Add("pageToken", pageToken)
+	}
+
+	u.RawQuery = q.Encode()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+
+	req.Header.Set("Authorization", "OAuth "+token)
+
+	r, err := a.doRequest(req, endpointOrgList)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to make GET request: %w", err)
+	}
+
+	if r.StatusCode != 200 {
+		return nil, responseError(r)
+	}
+
+	bdy, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var rsp GetOrganizationsResponse
 
 	err = json.Unmarshal(bdy, &rsp)
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal response: %w", err)
 	}
-	fmt.Println(string(bdy), rsp)
 
 	return &rsp, nil
 }
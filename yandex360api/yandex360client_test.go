@@ -1,31 +1,44 @@
 package yandex360api
 
 import (
+	"bytes"
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	//"github.com/boryashkin/cert-manager-webhook-beget/yandex360api"
 	//	yandex360api "github.com/cert-manager/webhook-example/client"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/textlogger"
 )
 
 type ApiClientTestSuite struct {
 	suite.Suite
 	yandex360api *Yandex360ApiMock
+	server       *httptest.Server
 	client       *ApiClient
 	apiUrl       *url.URL
 }
 
-func (suite *ApiClientTestSuite) SetupTest() {
+func (suite *ApiClientTestSuite) SetupSuite() {
 	suite.yandex360api = NewYandex360ApiMock(
 		Yandex360ApiMock_TestData,
 	)
-	go func() {
-		suite.yandex360api.Run(":12943")
-	}()
-	apiUrl, err := url.Parse("http://localhost:12943")
+	suite.server = httptest.NewServer(suite.yandex360api.Handler())
+	apiUrl, err := url.Parse(suite.server.URL)
 	suite.Require().NoError(err)
 
 	suite.apiUrl = apiUrl
@@ -33,7 +46,7 @@ func (suite *ApiClientTestSuite) SetupTest() {
 }
 
 func (suite *ApiClientTestSuite) TearDownSuite() {
-	suite.yandex360api.Stop(context.TODO())
+	suite.server.Close()
 }
 
 func TestApiClientTestSuiteSuite(t *testing.T) {
@@ -41,30 +54,38 @@ func TestApiClientTestSuiteSuite(t *testing.T) {
 }
 
 func (suite *ApiClientTestSuite) TestApiClient_GetDnsRecords() {
-	_, err := suite.client.GetDnsRecords(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	_, err := suite.client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
 	suite.Require().NoError(err, "getData returned an err %s", err)
 }
 
 func (suite *ApiClientTestSuite) TestApiClient_AddTxtRecord() {
 
 	// basic add
-	err := suite.client.AddTxtRecord(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, "sometxt10", "sometxtvalue", 300)
+	record, err := suite.client.AddTxtRecord(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, "sometxt10", "sometxtvalue", 300)
 	suite.Require().NoError(err, "AddTxtRecord returned error")
+	suite.Require().NotZero(record.RecordID)
+	suite.Require().Equal("sometxt10", record.Name)
+	suite.Require().Equal("sometxtvalue", record.Text)
 }
 
-func (suite *ApiClientTestSuite) TestApiClient_DeleteTxtRecordByName() {
+func (suite *ApiClientTestSuite) TestApiClient_DeleteTxtRecord() {
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
 
 	// fail if not found
-	err := suite.client.DeleteTxtRecordByName(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, "sometxt0")
-	suite.Require().Error(err, "DeleteTxtRecordByName 1 not returned error")
+	err := suite.client.DeleteTxtRecord(context.TODO(), settings, "sometxt0", "randomtext1")
+	suite.Require().Error(err, "DeleteTxtRecord 1 not returned error")
 
 	// not delete cname
-	err = suite.client.DeleteTxtRecordByName(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, "cname")
-	suite.Require().Error(err, "DeleteTxtRecordByName 2 not returned error")
+	err = suite.client.DeleteTxtRecord(context.TODO(), settings, "cname1", "someother1.site")
+	suite.Require().Error(err, "DeleteTxtRecord 2 not returned error")
+
+	// not delete a record of the same name with another value
+	err = suite.client.DeleteTxtRecord(context.TODO(), settings, "sometxt1", "othertext")
+	suite.Require().Error(err, "DeleteTxtRecord 3 not returned error")
 
 	// delete txt
-	err = suite.client.DeleteTxtRecordByName(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, "sometxt1")
-	suite.Require().NoError(err, "DeleteTxtRecordByName 3 returned an err %s", err)
+	err = suite.client.DeleteTxtRecord(context.TODO(), settings, "sometxt1", "randomtext1")
+	suite.Require().NoError(err, "DeleteTxtRecord 4 returned an err %s", err)
 }
 
 func (suite *ApiClientTestSuite) TestApiClient_AddRecords() {
@@ -74,11 +95,358 @@ func (suite *ApiClientTestSuite) TestApiClient_AddRecords() {
 		Type: "TXT",
 		TTL:  21600,
 	}
-	err := suite.client.AddDnsRecord(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, r)
+	_, err := suite.client.AddDnsRecord(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, r)
 	suite.Require().NoError(err, "getData returned an err %s", err)
 }
 
 func (suite *ApiClientTestSuite) TestApiClient_DeleteRecord() {
-	err := suite.client.DeleteDnsRecord(&ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, 4)
+	err := suite.client.DeleteDnsRecord(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}, 4)
 	suite.Require().NoError(err, "getData returned an err %s", err)
 }
+
+func (suite *ApiClientTestSuite) TestApiClient_GetDnsRecords_Paging() {
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example2.com", Token: Yandex360ApiMock_TestData.authKey}
+	for i := 0; i < 2*perPage; i++ {
+		_, err := suite.client.AddTxtRecord(context.TODO(), settings, "paged"+strconv.Itoa(i), "value", 300)
+		suite.Require().NoError(err)
+	}
+
+	records, err := suite.client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().NoError(err)
+	suite.Require().Equal(2*perPage+3, len(records))
+	suite.Require().Equal("paged"+strconv.Itoa(2*perPage-1), records[len(records)-1].Name)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_GetDomains() {
+	domains, err := suite.client.GetDomains(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().NoError(err)
+	suite.Require().Equal(2, len(domains))
+	suite.Require().Equal("example1.com", domains[0].Name)
+	suite.Require().Equal("example2.com", domains[1].Name)
+
+	_, err = suite.client.GetDomains(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1000, Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().Error(err)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_ApiError() {
+	_, err := suite.client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1000, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().Error(err)
+
+	var apiErr *ApiError
+	suite.Require().ErrorAs(err, &apiErr)
+	suite.Require().Equal(http.StatusUnauthorized, apiErr.StatusCode)
+	suite.Require().Equal(16, apiErr.Code)
+	suite.Require().Equal("Unauthorized", apiErr.Message)
+	suite.Require().NotEmpty(apiErr.RequestID)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_Metrics() {
+	listed := testutil.ToFloat64(apiRequestsTotal.WithLabelValues(endpointDnsList, "GET", "200"))
+	unauthorized := testutil.ToFloat64(apiRequestsTotal.WithLabelValues(endpointDnsList, "GET", "401"))
+
+	_, err := suite.client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().NoError(err)
+	_, err = suite.client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: "invalid"})
+	suite.Require().Error(err)
+
+	suite.Require().Equal(listed+1, testutil.ToFloat64(apiRequestsTotal.WithLabelValues(endpointDnsList, "GET", "200")))
+	suite.Require().Equal(unauthorized+1, testutil.ToFloat64(apiRequestsTotal.WithLabelValues(endpointDnsList, "GET", "401")))
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_Retries() {
+	// the first two attempts of every request are refused
+	var attempts sync.Map
+	handler := suite.yandex360api.Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.String()
+		n, _ := attempts.LoadOrStore(key, new(int))
+		if *n.(*int)++; *n.(*int) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+	retried := testutil.ToFloat64(apiRetriesTotal.WithLabelValues(endpointDnsList, "503"))
+
+	records, err := suite.client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().NoError(err)
+	suite.Require().NotEmpty(records)
+	suite.Require().Equal(retried+2, testutil.ToFloat64(apiRetriesTotal.WithLabelValues(endpointDnsList, "503")))
+
+	// creations are not retried
+	_, err = suite.client.AddTxtRecord(context.TODO(), settings, "retried", "value", 300)
+	var apiErr *ApiError
+	suite.Require().ErrorAs(err, &apiErr)
+	suite.Require().Equal(http.StatusServiceUnavailable, apiErr.StatusCode)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_Options() {
+	var userAgents, order []string
+	middleware := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				userAgents = append(userAgents, req.Header.Get("User-Agent"))
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(4), textlogger.Output(&buf)))
+	client := NewApiClient(
+		WithHTTPClient(suite.server.Client()),
+		WithUserAgent("yandex360-test/1.0"),
+		WithRoundTripper(middleware("outer")),
+		WithRoundTripper(middleware("inner")),
+		WithLogger(logger),
+	)
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]string{"outer", "inner"}, order)
+	suite.Require().Equal("yandex360-test/1.0", userAgents[0])
+	suite.Require().Contains(buf.String(), "Yandex 360 API request")
+
+	// the logger of the context wins
+	buf.Reset()
+	_, err = client.GetDnsRecords(klog.NewContext(context.TODO(), klog.Background()), settings)
+	suite.Require().NoError(err)
+	suite.Require().Empty(buf.String())
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_Timeout() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+
+	client := NewApiClient(WithTimeout(20*time.Millisecond), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	start := time.Now()
+	_, err := client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().Error(err)
+	suite.Require().Less(time.Since(start), 150*time.Millisecond)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_Setters() {
+	var requests, slow atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(time.Duration(slow.Load()))
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	client := NewApiClient(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().Error(err)
+	suite.Require().Equal(int64(1), requests.Load())
+
+	requests.Store(0)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().Error(err)
+	suite.Require().Equal(int64(3), requests.Load())
+
+	slow.Store(int64(200 * time.Millisecond))
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	client.SetTimeout(20 * time.Millisecond)
+	start := time.Now()
+	_, err = client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().Error(err)
+	suite.Require().Less(time.Since(start), 150*time.Millisecond)
+
+	// the second request waits for a token of the new limit
+	slow.Store(0)
+	client.SetRateLimit(RateLimit{QPS: 0.5, Burst: 1})
+	_, _ = client.GetDnsRecords(context.TODO(), settings)
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.GetDnsRecords(ctx, settings)
+	suite.Require().ErrorIs(err, context.DeadlineExceeded)
+}
+
+// spanExporter records the spans of the tests. The global tracer provider
+// can only be installed once, tracers obtained before keep delegating to it.
+var (
+	spanExporter    = tracetest.NewInMemoryExporter()
+	installProvider sync.Once
+)
+
+func (suite *ApiClientTestSuite) TestApiClient_Tracing() {
+	installProvider.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	spanExporter.Reset()
+	exporter := spanExporter
+
+	_, err := suite.client.GetDnsRecords(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().NoError(err)
+
+	spans := exporter.GetSpans()
+	var parent, request *tracetest.SpanStub
+	for i := range spans {
+		if spans[i].Name == "yandex360api.GetDnsRecords" {
+			parent = &spans[i]
+		} else {
+			request = &spans[i]
+		}
+	}
+	suite.Require().NotNil(parent)
+	suite.Require().NotNil(request)
+	suite.Require().Equal(parent.SpanContext.SpanID(), request.Parent.SpanID())
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range append(parent.Attributes, request.Attributes...) {
+		attrs[kv.Key] = kv.Value
+	}
+	suite.Require().Equal(int64(1001), attrs[attrOrganizationID].AsInt64())
+	suite.Require().Equal("example1.com", attrs[attrDomain].AsString())
+	suite.Require().Equal(int64(http.StatusOK), attrs["http.status_code"].AsInt64())
+	suite.Require().NotEmpty(attrs[attrRequestID].AsString())
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_LogsNoToken() {
+	var buf bytes.Buffer
+	logger := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(10), textlogger.Output(&buf)))
+	ctx := klog.NewContext(context.TODO(), logger)
+
+	_, err := suite.client.GetDnsRecords(ctx, &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().NoError(err)
+	_, err = suite.client.GetDnsRecords(ctx, &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: "invalid-token"})
+	suite.Require().Error(err)
+
+	suite.Require().Contains(buf.String(), "Yandex 360 API request")
+	suite.Require().Contains(buf.String(), Redacted)
+	suite.Require().NotContains(buf.String(), Yandex360ApiMock_TestData.authKey)
+	suite.Require().NotContains(buf.String(), "invalid-token")
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_RateLimit() {
+	client := NewApiClient(WithRateLimit(RateLimit{QPS: 10, Burst: 1}))
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+	other := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: "other-token"}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := client.GetDnsRecords(context.TODO(), settings)
+		suite.Require().NoError(err)
+	}
+	suite.Require().GreaterOrEqual(time.Since(start), 150*time.Millisecond)
+
+	// another token has its own bucket, the mock rejects it right away
+	start = time.Now()
+	_, err := client.GetDnsRecords(context.TODO(), other)
+	suite.Require().Error(err)
+	suite.Require().Less(time.Since(start), 50*time.Millisecond)
+
+	suite.Require().Equal(0.0, testutil.ToFloat64(apiRateLimitQueueDepth.WithLabelValues("1001")))
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_RateLimit_Cancel() {
+	client := NewApiClient(WithRateLimit(RateLimit{QPS: 0.1, Burst: 1, Key: RateLimitByOrganization}))
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1002, Domain: "example3.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		suite.Eventually(func() bool {
+			return testutil.ToFloat64(apiRateLimitQueueDepth.WithLabelValues("1002")) == 1
+		}, time.Second, 5*time.Millisecond)
+	}()
+
+	_, err = client.GetDnsRecords(ctx, settings)
+	suite.Require().ErrorIs(err, context.DeadlineExceeded)
+	<-queued
+	suite.Require().Equal(0.0, testutil.ToFloat64(apiRateLimitQueueDepth.WithLabelValues("1002")))
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_RateLimit_Retries() {
+	// every attempt is refused and may be retried right away
+	var attempts atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	apiUrl, _ := url.Parse(server.URL)
+	settings := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	client := NewApiClient(WithRateLimit(RateLimit{QPS: 10, Burst: 1}), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, MaxDelay: time.Second}))
+	start := time.Now()
+	_, err := client.GetDnsRecords(context.TODO(), settings)
+	suite.Require().Error(err)
+	suite.Require().Equal(int64(3), attempts.Load())
+	// the retries waited for a token each
+	suite.Require().GreaterOrEqual(time.Since(start), 150*time.Millisecond)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_UpdateDnsRecord() {
+	settings := &ApiSettings{ApiUrl: suite.apiUrl, OrganizationId: 1002, Domain: "example3.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	created, err := suite.client.AddDnsRecord(context.TODO(), settings, NewMXRecord("@", "mx.yandex.net.", 10, 300))
+	suite.Require().NoError(err)
+
+	update := *created
+	update.Preference = 20
+	update.TTL = 600
+	updated, err := suite.client.UpdateDnsRecord(context.TODO(), settings, update)
+	suite.Require().NoError(err)
+	suite.Require().Equal(update, *updated)
+
+	record, err := suite.client.GetDnsRecord(context.TODO(), settings, created.RecordID)
+	suite.Require().NoError(err)
+	suite.Require().Equal(update, *record)
+
+	found, err := suite.client.FindDnsRecords(context.TODO(), settings, RecordQuery{Type: RecordTypeMX, Value: "mx.yandex.net."})
+	suite.Require().NoError(err)
+	suite.Require().Equal([]DnsRecord{update}, found)
+
+	// invalid records are not sent
+	update.Preference = -1
+	_, err = suite.client.UpdateDnsRecord(context.TODO(), settings, update)
+	suite.Require().ErrorIs(err, ErrInvalidRecord)
+	_, err = suite.client.UpdateDnsRecord(context.TODO(), settings, NewMXRecord("@", "mx.yandex.net.", 10, 300))
+	suite.Require().ErrorIs(err, ErrInvalidRecord, "the record id is required")
+	_, err = suite.client.AddDnsRecord(context.TODO(), settings, NewARecord("@", "not-an-address", 300))
+	suite.Require().ErrorIs(err, ErrInvalidRecord)
+
+	// unknown records
+	missing := *created
+	missing.RecordID = 1000
+	_, err = suite.client.UpdateDnsRecord(context.TODO(), settings, missing)
+	var apiErr *ApiError
+	suite.Require().ErrorAs(err, &apiErr)
+	suite.Require().Equal(http.StatusNotFound, apiErr.StatusCode)
+	_, err = suite.client.GetDnsRecord(context.TODO(), settings, 1000)
+	suite.Require().ErrorIs(err, ErrRecordNotFound)
+}
+
+func (suite *ApiClientTestSuite) TestApiClient_GetOrganizations() {
+	client := NewApiClient()
+	organizations, err := client.GetOrganizations(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, Token: Yandex360ApiMock_TestData.authKey})
+	suite.Require().NoError(err)
+	suite.Require().Len(organizations, 3)
+	suite.Require().Equal(1001, organizations[0].Id)
+	suite.Require().Equal("Organization 1003", organizations[2].Name)
+
+	_, err = client.GetOrganizations(context.TODO(), &ApiSettings{ApiUrl: suite.apiUrl, Token: "invalid"})
+	var apiErr *ApiError
+	suite.Require().ErrorAs(err, &apiErr)
+	suite.Require().Equal(http.StatusUnauthorized, apiErr.StatusCode)
+}
@@ -0,0 +1,246 @@
+package yandex360api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxTXTString is the longest character string of a TXT record, longer texts
+// are split into several strings.
+const maxTXTString = 255
+
+// RR returns r as a resource record of the zone origin, e.g. "example.com".
+// Host names without a trailing dot, as Yandex 360 may return them, are made
+// absolute.
+func (r DnsRecord) RR(origin string) (dns.RR, error) {
+	origin = dns.Fqdn(origin)
+	owner := origin
+	if r.Name != "@" {
+		owner = r.Name + "." + origin
+	}
+	hdr := dns.RR_Header{Name: owner, Class: dns.ClassINET, Ttl: uint32(r.TTL)}
+
+	switch r.Type {
+	case RecordTypeA, RecordTypeAAAA:
+		ip := net.ParseIP(r.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %s: address %q is not an IP address", ErrInvalidRecord, r, r.Address)
+		}
+		if r.Type == RecordTypeA {
+			hdr.Rrtype = dns.TypeA
+			return &dns.A{Hdr: hdr, A: ip}, nil
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case RecordTypeCNAME:
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(r.Target)}, nil
+	case RecordTypeNS:
+		hdr.Rrtype = dns.TypeNS
+		return &dns.NS{Hdr: hdr, Ns: dns.Fqdn(r.Target)}, nil
+	case RecordTypeMX:
+		hdr.Rrtype = dns.TypeMX
+		return &dns.MX{Hdr: hdr, Preference: uint16(r.Preference), Mx: dns.Fqdn(r.Exchange)}, nil
+	case RecordTypeSRV:
+		hdr.Rrtype = dns.TypeSRV
+		return &dns.SRV{Hdr: hdr, Priority: uint16(r.Priority), Weight: uint16(r.Weight), Port: uint16(r.Port), Target: dns.Fqdn(r.Target)}, nil
+	case RecordTypeCAA:
+		hdr.Rrtype = dns.TypeCAA
+		return &dns.CAA{Hdr: hdr, Flag: uint8(r.Flag), Tag: r.Tag, Value: r.Value}, nil
+	case RecordTypeTXT:
+		hdr.Rrtype = dns.TypeTXT
+		return &dns.TXT{Hdr: hdr, Txt: splitTXT(strings.Trim(r.Text, `"`))}, nil
+	}
+	return nil, fmt.Errorf("%w: %s: unsupported record type %q", ErrInvalidRecord, r.Name, r.Type)
+}
+
+// splitTXT splits text into the character strings of a TXT record, escaped
+// for dns.TXT, which holds them in presentation format. The zone parser
+// splits quoted strings longer than maxTXTString characters of presentation
+// format, so the escaped strings are kept that short and an escape is never
+// split.
+func splitTXT(text string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(text); i++ {
+		escaped := escapeTXTByte(text[i])
+		if part.Len()+len(escaped) > maxTXTString {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(escaped)
+	}
+	return append(parts, part.String())
+}
+
+// escapeTXTByte returns c in the presentation format of a TXT string.
+func escapeTXTByte(c byte) string {
+	switch {
+	case c == '\\' || c == '"':
+		return `\` + string(c)
+	case c < ' ' || c > '~':
+		return fmt.Sprintf(`\%03d`, c)
+	}
+	return string(c)
+}
+
+// unescapeTXT returns the text of a character string of dns.TXT, undoing the
+// \X and \DDD escapes of the presentation format.
+func unescapeTXT(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i+2 < len(s) && isDigit(s[i]) && isDigit(s[i+1]) && isDigit(s[i+2]) {
+			b.WriteByte((s[i]-'0')*100 + (s[i+1]-'0')*10 + (s[i+2] - '0'))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// RecordFromRR returns rr of the zone origin as a Yandex 360 record. It fails
+// for types Yandex 360 does not support and for names outside of origin.
+func RecordFromRR(rr dns.RR, origin string) (DnsRecord, error) {
+	hdr := rr.Header()
+	origin = dns.Fqdn(origin)
+	if !dns.IsSubDomain(origin, hdr.Name) {
+		return DnsRecord{}, fmt.Errorf("%s is not in zone %s", hdr.Name, origin)
+	}
+	name := "@"
+	if labels := dns.CountLabel(hdr.Name) - dns.CountLabel(origin); labels > 0 {
+		name = strings.Join(dns.SplitDomainName(hdr.Name)[:labels], ".")
+	}
+	r := DnsRecord{Name: name, TTL: int(hdr.Ttl)}
+
+	switch rr := rr.(type) {
+	case *dns.A:
+		r.Type, r.Address = RecordTypeA, rr.A.String()
+	case *dns.AAAA:
+		r.Type, r.Address = RecordTypeAAAA, rr.AAAA.String()
+	case *dns.CNAME:
+		r.Type, r.Target = RecordTypeCNAME, rr.Target
+	case *dns.NS:
+		r.Type, r.Target = RecordTypeNS, rr.Ns
+	case *dns.MX:
+		r.Type, r.Exchange, r.Preference = RecordTypeMX, rr.Mx, int(rr.Preference)
+	case *dns.SRV:
+		r.Type, r.Target, r.Priority, r.Weight, r.Port = RecordTypeSRV, rr.Target, int(rr.Priority), int(rr.Weight), int(rr.Port)
+	case *dns.CAA:
+		r.Type, r.Flag, r.Tag, r.Value = RecordTypeCAA, int(rr.Flag), rr.Tag, rr.Value
+	case *dns.TXT:
+		var text strings.Builder
+		for _, s := range rr.Txt {
+			text.WriteString(unescapeTXT(s))
+		}
+		r.Type, r.Text = RecordTypeTXT, text.String()
+	default:
+		return DnsRecord{}, fmt.Errorf("%s: record type %s is not supported by Yandex 360", hdr.Name, dns.TypeToString[hdr.Rrtype])
+	}
+	return r, nil
+}
+
+// WriteZone writes records of the zone origin to w as an RFC 1035 zone file,
+// sorted by name and type.
+func WriteZone(w io.Writer, origin string, records []DnsRecord) error {
+	records = append([]DnsRecord(nil), records...)
+	sortRecords(records)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %s\n", dns.Fqdn(origin))
+	for _, r := range records {
+		rr, err := r.RR(origin)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(bw, rr.String())
+	}
+	return bw.Flush()
+}
+
+// ParseZone reads the records of the RFC 1035 zone file r of the zone origin.
+// SOA records are skipped, Yandex 360 manages them, other types it does not
+// support and records failing Validate are an error.
+func ParseZone(r io.Reader, origin string) ([]DnsRecord, error) {
+	zp := dns.NewZoneParser(r, dns.Fqdn(origin), "")
+	var records []DnsRecord
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		record, err := RecordFromRR(rr, origin)
+		if err == nil {
+			err = record.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone file: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("invalid zone file: %w", err)
+	}
+	return records, nil
+}
+
+// ExportZone writes all records of the domain to w as a zone file.
+func (a *ApiClient) ExportZone(ctx context.Context, apiSettings *ApiSettings, w io.Writer) error {
+	records, err := a.GetDnsRecords(ctx, apiSettings)
+	if err != nil {
+		return fmt.Errorf("failed to ExportZone: %w", err)
+	}
+	if err := WriteZone(w, apiSettings.Domain, records); err != nil {
+		return fmt.Errorf("failed to ExportZone: %w", err)
+	}
+	return nil
+}
+
+// ImportOptions configure ImportZone.
+type ImportOptions struct {
+	// Prune deletes the records missing from the zone file. Without it only
+	// the records of the names and types in the file are replaced.
+	Prune bool
+	// DryRun computes the plan without applying it
+	DryRun bool
+}
+
+// ImportZone brings the records of the domain to the zone file r and returns
+// the plan applied. Importing the same file again changes nothing.
+func (a *ApiClient) ImportZone(ctx context.Context, apiSettings *ApiSettings, r io.Reader, opts ImportOptions) (*Plan, error) {
+	records, err := ParseZone(r, apiSettings.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ImportZone: %w", err)
+	}
+
+	syncOpts := SyncOptions{DryRun: opts.DryRun}
+	if !opts.Prune {
+		sets := map[recordSetKey]bool{}
+		for _, record := range records {
+			sets[keyOf(record)] = true
+		}
+		syncOpts.Owned = func(record DnsRecord) bool { return sets[keyOf(record)] }
+	}
+	plan, err := a.SyncDnsRecords(ctx, apiSettings, records, syncOpts)
+	if err != nil {
+		return plan, fmt.Errorf("failed to ImportZone: %w", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,110 @@
+package yandex360api
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZone_WriteAndParse(t *testing.T) {
+	records := []DnsRecord{
+		NewARecord("@", "1.2.3.4", 300),
+		NewAAAARecord("www", "2001:db8::1", 300),
+		NewCNAMERecord("mail", "domain.mail.yandex.net", 21600),
+		NewMXRecord("@", "mx.yandex.net.", 10, 21600),
+		NewSRVRecord("_sip._tcp", "sip.example.com.", 10, 20, 5060, 300),
+		NewCAARecord("@", 0, "issue", "letsencrypt.org", 300),
+		NewNSRecord("sub", "ns1.example.com.", 300),
+		NewTXTRecord("@", "v=spf1 redirect=_spf.yandex.net", 300),
+		NewTXTRecord("mail._domainkey", "v=DKIM1; k=rsa; p="+strings.Repeat("A", 400), 300),
+		NewTXTRecord("spf", `v=spf1 include:"_spf.yandex.net" -all`, 300),
+		NewTXTRecord("dkim._domainkey", `v=DKIM1; n=a\b; p=`+strings.Repeat(`\"`, 200)+"AB", 300),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteZone(&buf, "example.com", records))
+	zone := buf.String()
+	require.True(t, strings.HasPrefix(zone, "$ORIGIN example.com.\n"))
+	require.Contains(t, zone, "mail.example.com.\t21600\tIN\tCNAME\tdomain.mail.yandex.net.\n")
+	require.Contains(t, zone, "_sip._tcp.example.com.\t300\tIN\tSRV\t10 20 5060 sip.example.com.\n")
+
+	parsed, err := ParseZone(strings.NewReader(zone), "example.com")
+	require.NoError(t, err)
+	plan, err := ComputePlan("example.com", records, parsed, SyncOptions{})
+	require.NoError(t, err)
+	require.True(t, plan.Empty(), plan.Diff())
+	require.Contains(t, zone, `spf.example.com.	300	IN	TXT	"v=spf1 include:\"_spf.yandex.net\" -all"`+"\n")
+	texts := map[string]string{}
+	for _, r := range parsed {
+		texts[r.Name+" "+r.Type] = r.Text
+	}
+	for _, r := range records {
+		require.Equal(t, r.Text, texts[r.Name+" "+r.Type], r.Name)
+	}
+
+	// relative names, SOA records and defaults of the zone file
+	parsed, err = ParseZone(strings.NewReader(`$TTL 600
+@	IN	SOA	ns1.yandex.net. hostmaster.example.com. 1 3600 600 604800 600
+@	IN	MX	10 mx.yandex.net.
+www	300	IN	A	1.2.3.4
+`), "example.com.")
+	require.NoError(t, err)
+	require.Equal(t, []DnsRecord{NewMXRecord("@", "mx.yandex.net.", 10, 600), NewARecord("www", "1.2.3.4", 300)}, parsed)
+
+	_, err = ParseZone(strings.NewReader("4.3.2.1.in-addr.arpa. 300 IN PTR www.example.com.\n"), "example.com")
+	require.ErrorContains(t, err, "is not in zone example.com.")
+	_, err = ParseZone(strings.NewReader("www 300 IN PTR host.example.com.\n"), "example.com")
+	require.ErrorContains(t, err, "record type PTR is not supported by Yandex 360")
+	_, err = ParseZone(strings.NewReader("www 300 IN A\n"), "example.com")
+	require.ErrorIs(t, err, ErrInvalidRecord)
+}
+
+func TestApiClient_ExportImportZone(t *testing.T) {
+	server := httptest.NewServer(NewYandex360ApiMock(Yandex360ApiMock_TestData).Handler())
+	t.Cleanup(server.Close)
+	apiUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client := NewApiClient()
+	source := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1001, Domain: "example1.com", Token: Yandex360ApiMock_TestData.authKey}
+	target := &ApiSettings{ApiUrl: apiUrl, OrganizationId: 1002, Domain: "example3.com", Token: Yandex360ApiMock_TestData.authKey}
+
+	_, err = client.AddDnsRecord(context.TODO(), source, NewMXRecord("@", "mx.yandex.net.", 10, 21600))
+	require.NoError(t, err)
+	var exported bytes.Buffer
+	require.NoError(t, client.ExportZone(context.TODO(), source, &exported))
+
+	// a migration to another domain, the names are relative to the origin
+	zone := strings.ReplaceAll(exported.String(), "example1.com.", "example3.com.")
+	plan, err := client.ImportZone(context.TODO(), target, strings.NewReader(zone), ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, plan.Count(ChangeCreate), plan.Diff())
+	require.Equal(t, 1, plan.Count(ChangeUpdate), "the A record of the origin")
+	require.Zero(t, plan.Count(ChangeDelete), "the records of other names are kept")
+
+	_, err = client.ImportZone(context.TODO(), target, strings.NewReader(zone), ImportOptions{Prune: true})
+	require.NoError(t, err)
+	plan, err = client.ImportZone(context.TODO(), target, strings.NewReader(zone), ImportOptions{Prune: true})
+	require.NoError(t, err)
+	require.True(t, plan.Empty(), "importing again changes nothing: %s", plan.Diff())
+
+	// the round trip keeps all records
+	var reexported bytes.Buffer
+	require.NoError(t, client.ExportZone(context.TODO(), target, &reexported))
+	require.Equal(t, zone, reexported.String())
+
+	// without prune, records of other names are kept
+	_, err = client.AddTxtRecord(context.TODO(), target, "extra", "value", 300)
+	require.NoError(t, err)
+	plan, err = client.ImportZone(context.TODO(), target, strings.NewReader(zone), ImportOptions{})
+	require.NoError(t, err)
+	require.True(t, plan.Empty(), plan.Diff())
+	plan, err = client.ImportZone(context.TODO(), target, strings.NewReader(zone), ImportOptions{Prune: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.Count(ChangeDelete))
+	require.Equal(t, "extra", plan.Changes[0].Current.Name)
+}